@@ -20,6 +20,30 @@ func main() {
 	domainsFlag := flag.String("domains", "", "Comma-separated list of libvirt domains to monitor (empty for all)")
 	logFile := flag.String("log", "", "Log file path (optional)")
 	refreshInterval := flag.String("interval", "2s", "Refresh interval (e.g., 500ms, 1s, 2s)")
+	collectorFlag := flag.String("collector", "virsh", "Stats collector: virsh (spawn virsh), libvirt (native RPC over the libvirtd socket), cgroup (read /sys/fs/cgroup, no libvirt access needed) or qmp (QEMU monitor sockets, for VMs not managed by libvirt)")
+	connectFlag := flag.String("connect", "", "Comma-separated libvirt connection URIs, one per host (e.g., qemu:///system, qemu:///session, qemu+ssh://host/system)")
+	socketPath := flag.String("socket", "", "libvirtd socket path for the libvirt collector (default derived from -connect)")
+	cgroupRoot := flag.String("cgroup-root", stats.DefaultCgroupRoot, "cgroup v2 mount point for the cgroup collector")
+	qmpSockets := flag.String("qmp-sockets", "", "Glob of QMP sockets for the qmp collector (e.g., /run/qemu/*.qmp)")
+	workers := flag.Int("workers", stats.DefaultWorkers, "Maximum concurrent per-domain virsh commands (IPs, OS type)")
+	commandTimeout := flag.Duration("timeout", stats.DefaultCommandTimeout, "Deadline for each virsh command or libvirt call")
+	metadataTTL := flag.Duration("metadata-ttl", stats.DefaultMetadataTTL, "How long static domain metadata (OS type, autostart, max memory, ...) is cached")
+	ipSourcesFlag := flag.String("ip-sources", strings.Join(stats.DefaultIPSources, ","), "Ordered IP address sources to try per domain: lease, agent, arp")
+	perf := flag.Bool("perf", false, "Collect perf event counters (IPC, cache misses) for domains with perf events enabled")
+	dirtyRate := flag.Duration("dirtyrate", 0, "Measure each running VM's dirty page rate and memory bandwidth this often (e.g., 30s); 0 disables")
+	migrationBandwidth := flag.Float64("migration-bandwidth", stats.DefaultMigrationBandwidth, "Migration link speed in MiB/s, for the live migration estimate")
+	poolInterval := flag.Duration("pool-interval", stats.DefaultPoolInterval, "How often storage pools are listed for the storage view; 0 disables")
+	networkInterval := flag.Duration("network-interval", stats.DefaultNetworkInterval, "How often virtual networks and DHCP leases are listed for the networks view; 0 disables")
+	recordFile := flag.String("record", "", "Record every collector result to this file for later -replay")
+	replayFile := flag.String("replay", "", "Play back a -record file instead of connecting to libvirt")
+	replaySpeed := flag.Float64("replay-speed", 1, "Playback speed for -replay (e.g., 10 plays ten times faster)")
+	replayLoop := flag.Bool("replay-loop", true, "Start -replay over once the recording ends")
+	historyLength := flag.Int("history", stats.DefaultHistoryLength, "Samples kept per metric for the sparklines (e.g., 120 at -interval 2s covers 4 minutes)")
+	storeDir := flag.String("store", "", "Directory to keep metric history in across restarts (e.g., ~/.local/state/vmstats); empty disables")
+	rawRetention := flag.Duration("store-raw-retention", stats.DefaultRawRetention, "How long -store keeps every sample")
+	minuteRetention := flag.Duration("store-minute-retention", stats.DefaultMinuteRetention, "How long -store keeps 1-minute rollups")
+	hourRetention := flag.Duration("store-hour-retention", stats.DefaultHourRetention, "How long -store keeps 1-hour rollups")
+	storeMaxSize := flag.Int64("store-max-size", stats.DefaultStoreMaxBytes>>20, "Size bound of -store in MiB, oldest and finest data dropped first; 0 for none")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	flag.Parse()
 
@@ -40,6 +64,12 @@ func main() {
 		fmt.Println("Warning: Interval too low, setting to 500ms")
 	}
 
+	ipSources, err := stats.ParseIPSources(*ipSourcesFlag)
+	if err != nil {
+		fmt.Printf("Invalid -ip-sources: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Parse domains
 	var domains []string
 	if *domainsFlag != "" {
@@ -66,17 +96,157 @@ func main() {
 		log.SetOutput(io.Discard)
 	}
 
+	// Parse connection URIs; no -connect means one host on the default connection
+	uris := []string{""}
+	if *connectFlag != "" {
+		uris = strings.Split(*connectFlag, ",")
+		for i := range uris {
+			uris[i] = strings.TrimSpace(uris[i])
+		}
+	}
+	if *socketPath != "" && len(uris) > 1 {
+		fmt.Println("-socket can only be used with a single -connect URI")
+		os.Exit(1)
+	}
+	if (*collectorFlag == "cgroup" || *collectorFlag == "qmp") && len(uris) > 1 {
+		fmt.Printf("The %s collector only reads the local host; it cannot be used with several -connect URIs\n", *collectorFlag)
+		os.Exit(1)
+	}
+	if *collectorFlag == "qmp" && *qmpSockets == "" {
+		fmt.Println("The qmp collector needs -qmp-sockets")
+		os.Exit(1)
+	}
+	if *historyLength < 2 {
+		fmt.Println("-history must be at least 2")
+		os.Exit(1)
+	}
+	if *replaySpeed <= 0 {
+		fmt.Println("-replay-speed must be positive")
+		os.Exit(1)
+	}
+	if *replayFile != "" && *recordFile != "" {
+		fmt.Println("-record and -replay cannot be used together")
+		os.Exit(1)
+	}
+	if *replayFile != "" && *storeDir != "" {
+		fmt.Println("-store and -replay cannot be used together")
+		os.Exit(1)
+	}
+
+	var recorder *stats.Recorder
+	if *recordFile != "" {
+		f, err := os.Create(*recordFile)
+		if err != nil {
+			fmt.Printf("Error creating recording: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := recorder.Flush(); err != nil {
+				log.Printf("Error writing recording: %v", err)
+			}
+			if err := f.Close(); err != nil {
+				log.Printf("Error closing recording: %v", err)
+			}
+		}()
+		recorder = stats.NewRecorder(f)
+	}
+
 	if len(domains) > 0 {
-		log.Printf("Starting vmstats for domains: %v (refresh: %s)", domains, duration)
+		log.Printf("Starting vmstats for domains: %v (refresh: %s, uris: %q)", domains, duration, uris)
 	} else {
-		log.Printf("Starting vmstats for ALL domains (refresh: %s)", duration)
+		log.Printf("Starting vmstats for ALL domains (refresh: %s, uris: %q)", duration, uris)
+	}
+
+	// Initialize one collector per host
+	var hosts []ui.Host
+	if *replayFile != "" {
+		hosts, err = replayHosts(*replayFile, *replaySpeed, *replayLoop)
+		if err != nil {
+			fmt.Printf("Error loading recording: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range hosts {
+			hosts[i].MigrationBandwidth = *migrationBandwidth
+			hosts[i].PoolInterval = *poolInterval
+			hosts[i].NetworkInterval = *networkInterval
+		}
+		uris = nil
+	}
+
+	seen := make(map[string]bool)
+	for _, uri := range uris {
+		var collector stats.StatsCollector
+		switch *collectorFlag {
+		case "virsh":
+			vc := stats.NewVirshCollector(uri)
+			vc.Workers = *workers
+			vc.CommandTimeout = *commandTimeout
+			vc.MetadataTTL = *metadataTTL
+			vc.IPSources = ipSources
+			vc.Perf = *perf
+			vc.DirtyRateInterval = *dirtyRate
+			collector = vc
+		case "libvirt":
+			lc := stats.NewLibvirtCollector(uri, *socketPath)
+			lc.Timeout = *commandTimeout
+			lc.MetadataTTL = *metadataTTL
+			lc.IPSources = ipSources
+			lc.Perf = *perf
+			lc.DirtyRateInterval = *dirtyRate
+			defer func() {
+				if err := lc.Close(); err != nil {
+					log.Printf("Error closing libvirt connection: %v", err)
+				}
+			}()
+			collector = lc
+		case "cgroup":
+			cc := stats.NewCgroupCollector()
+			cc.Root = *cgroupRoot
+			collector = cc
+		case "qmp":
+			qc := stats.NewQMPCollector(*qmpSockets)
+			qc.Timeout = *commandTimeout
+			qc.Workers = *workers
+			collector = qc
+		default:
+			fmt.Printf("Unknown collector %q (expected virsh, libvirt, cgroup or qmp)\n", *collectorFlag)
+			os.Exit(1)
+		}
+
+		// Fall back to the full URI when two URIs map to the same label
+		name := stats.HostFromURI(uri)
+		if seen[name] {
+			name = uri
+		}
+		seen[name] = true
+
+		if recorder != nil {
+			collector = recorder.Wrap(name, collector)
+		}
+		hosts = append(hosts, ui.Host{Name: name, URI: uri, Collector: collector, MigrationBandwidth: *migrationBandwidth, PoolInterval: *poolInterval, NetworkInterval: *networkInterval})
 	}
 
-	// Initialize collector
-	collector := stats.NewVirshCollector()
+	// Charts start from the stored history of the last run, if any
+	history := stats.NewHistory(*historyLength)
+	var store *stats.Store
+	if *storeDir != "" {
+		store = stats.NewStore(*storeDir)
+		store.RawRetention = *rawRetention
+		store.MinuteRetention = *minuteRetention
+		store.HourRetention = *hourRetention
+		store.MaxBytes = *storeMaxSize << 20
+		if err := store.Prefill(history, duration); err != nil {
+			log.Printf("Error loading stored history: %v", err)
+		}
+		defer func() {
+			if err := store.Close(); err != nil {
+				log.Printf("Error writing stored history: %v", err)
+			}
+		}()
+	}
 
 	// Initialize Bubble Tea program
-	model := ui.InitialModel(domains, collector, duration)
+	model := ui.InitialModel(domains, hosts, duration, history, store)
 	p := tea.NewProgram(model, tea.WithAltScreen())
 
 	if _, err := p.Run(); err != nil {
@@ -85,3 +255,28 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// replayHosts loads a recording and creates one host per recorded host
+func replayHosts(path string, speed float64, loop bool) ([]ui.Host, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Error closing recording: %v", err)
+		}
+	}()
+
+	recording, err := stats.ReadRecording(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []ui.Host
+	for _, c := range recording.Replay(speed, loop) {
+		hosts = append(hosts, ui.Host{Name: c.Host, URI: "replay of " + path, Collector: c})
+	}
+	log.Printf("Replaying %s at %gx (hosts: %q)", path, speed, recording.Hosts())
+	return hosts, nil
+}
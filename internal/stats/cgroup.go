@@ -0,0 +1,358 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for CgroupCollector
+const (
+	DefaultCgroupRoot   = "/sys/fs/cgroup"
+	DefaultBlockDevRoot = "/sys/dev/block"
+)
+
+// Domain states as libvirt numbers them (virDomainState)
+const (
+	domainStateNoState = 0
+	domainStateRunning = 1
+	domainStatePaused  = 3
+	domainStateShutoff = 5
+	domainStateCrashed = 6
+)
+
+// CgroupStats holds what the host's cgroup v2 accounting reports for a
+// domain's QEMU processes
+type CgroupStats struct {
+	// Collected is set when the stats come from the domain's cgroup
+	Collected bool
+
+	MemoryCurrent int64 // bytes charged to the cgroup
+	MemoryMax     int64 // bytes, 0 when unlimited
+	MemoryAnon    int64 // bytes
+	MemoryFile    int64 // bytes of host page cache
+	MemorySwap    int64 // bytes swapped out
+
+	// CPU throttling by cpu.max
+	ThrottledPeriods int64
+	ThrottledTime    int64 // ns
+
+	CPUPressure    PressureStats
+	MemoryPressure PressureStats
+	IOPressure     PressureStats
+}
+
+// PressureStats is one pressure stall information (PSI) file: the share of
+// wall time some or all tasks in the cgroup were stalled on a resource
+type PressureStats struct {
+	SomeAvg10  float64 // %
+	SomeAvg60  float64 // %
+	SomeAvg300 float64 // %
+	SomeTotal  int64   // µs
+	FullAvg10  float64 // %
+	FullAvg60  float64 // %
+	FullAvg300 float64 // %
+	FullTotal  int64   // µs
+}
+
+// CgroupCollector collects stats from the cgroup v2 hierarchy systemd
+// creates for libvirt domains (machine.slice/machine-qemu*.scope). It only
+// needs read access to the cgroup filesystem, not to libvirt, so it sees
+// running domains only and knows nothing of their configuration or guest.
+type CgroupCollector struct {
+	// Root is where the cgroup v2 hierarchy is mounted
+	Root string
+	// BlockDevRoot resolves io.stat device numbers to names
+	BlockDevRoot string
+}
+
+// NewCgroupCollector creates a collector reading the host's cgroup hierarchy
+func NewCgroupCollector() *CgroupCollector {
+	return &CgroupCollector{Root: DefaultCgroupRoot, BlockDevRoot: DefaultBlockDevRoot}
+}
+
+// GetVMStats reads the cgroup of every running domain
+func (c *CgroupCollector) GetVMStats(ctx context.Context, domains []string) ([]VMStats, error) {
+	slice := filepath.Join(c.Root, "machine.slice")
+	scopes, err := os.ReadDir(slice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list machine scopes: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		wanted[d] = true
+	}
+
+	hostCPUs := runtime.NumCPU()
+	var vms []VMStats
+	for _, scope := range scopes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		id, name, ok := domainFromScope(scope.Name())
+		if !scope.IsDir() || !ok || (len(wanted) > 0 && !wanted[name]) {
+			continue
+		}
+
+		vm, err := c.readScope(filepath.Join(slice, scope.Name()))
+		if os.IsNotExist(err) {
+			continue // The domain stopped while being read
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read cgroup of %s: %w", name, err)
+		}
+		vm.DomainName = name
+		vm.ID = id
+		vm.HostCPUs = hostCPUs
+		vms = append(vms, vm)
+	}
+
+	sort.Slice(vms, func(i, j int) bool { return vms[i].DomainName < vms[j].DomainName })
+	return vms, nil
+}
+
+// domainFromScope extracts the domain ID and name from a scope such as
+// "machine-qemu\x2d3\x2dvm1.scope". libvirt may have shortened the name to
+// fit the machine name limits.
+func domainFromScope(scope string) (int, string, bool) {
+	unit, ok := strings.CutSuffix(scope, ".scope")
+	if !ok {
+		return 0, "", false
+	}
+	rest, ok := strings.CutPrefix(unescapeUnitName(unit), "machine-qemu-")
+	if !ok {
+		return 0, "", false
+	}
+	// The domain ID comes first
+	idStr, name, ok := strings.Cut(rest, "-")
+	if !ok || name == "" {
+		return 0, "", false
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, "", false
+	}
+	return id, name, true
+}
+
+// unescapeUnitName reverses systemd's \xNN escaping of unit names
+func unescapeUnitName(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if b, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				sb.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// readScope reads one domain's cgroup. cpu.stat is required; the other
+// files depend on which controllers are enabled and are skipped when absent.
+func (c *CgroupCollector) readScope(dir string) (VMStats, error) {
+	vm := VMStats{State: domainStateRunning, Cgroup: CgroupStats{Collected: true}}
+
+	cpu, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return vm, err
+	}
+	vm.CPU.Time = cpu["usage_usec"] * 1000
+	vm.CPU.User = cpu["user_usec"] * 1000
+	vm.CPU.System = cpu["system_usec"] * 1000
+	vm.Cgroup.ThrottledPeriods = cpu["nr_throttled"]
+	vm.Cgroup.ThrottledTime = cpu["throttled_usec"] * 1000
+
+	// libvirt places each vCPU thread in its own child cgroup. Scope names
+	// contain backslashes, so the children are listed rather than globbed.
+	children, _ := os.ReadDir(filepath.Join(dir, "libvirt"))
+	for _, child := range children {
+		suffix, ok := strings.CutPrefix(child.Name(), "vcpu")
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		stat, err := readKeyValues(filepath.Join(dir, "libvirt", child.Name(), "cpu.stat"))
+		if err != nil {
+			continue
+		}
+		vm.VCPUStats = append(vm.VCPUStats, VCPUStats{ID: id, State: 1, Time: stat["usage_usec"] * 1000})
+	}
+	sort.Slice(vm.VCPUStats, func(i, j int) bool { return vm.VCPUStats[i].ID < vm.VCPUStats[j].ID })
+
+	if value, err := readCgroupValue(filepath.Join(dir, "memory.current")); err == nil {
+		vm.Cgroup.MemoryCurrent = value
+		// The closest to the QEMU process's resident memory
+		vm.BalloonStats.RSS = value / 1024
+	}
+	if value, err := readCgroupValue(filepath.Join(dir, "memory.max")); err == nil {
+		vm.Cgroup.MemoryMax = value
+	}
+	if mem, err := readKeyValues(filepath.Join(dir, "memory.stat")); err == nil {
+		vm.Cgroup.MemoryAnon = mem["anon"]
+		vm.Cgroup.MemoryFile = mem["file"]
+	}
+	if value, err := readCgroupValue(filepath.Join(dir, "memory.swap.current")); err == nil {
+		vm.Cgroup.MemorySwap = value
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "io.stat")); err == nil {
+		vm.BlockStats = parseIOStat(string(data), c.blockDevName)
+	}
+
+	for file, pressure := range map[string]*PressureStats{
+		"cpu.pressure":    &vm.Cgroup.CPUPressure,
+		"memory.pressure": &vm.Cgroup.MemoryPressure,
+		"io.pressure":     &vm.Cgroup.IOPressure,
+	} {
+		if data, err := os.ReadFile(filepath.Join(dir, file)); err == nil {
+			*pressure = parsePressure(string(data))
+		}
+	}
+
+	if events, err := readKeyValues(filepath.Join(dir, "cgroup.events")); err == nil && events["frozen"] == 1 {
+		vm.State = domainStatePaused
+	}
+
+	vm.LastUpdate = time.Now().UnixNano()
+	return vm, nil
+}
+
+// blockDevName resolves a "major:minor" device number to its name, falling
+// back to the number itself
+func (c *CgroupCollector) blockDevName(dev string) string {
+	data, err := os.ReadFile(filepath.Join(c.BlockDevRoot, dev, "uevent"))
+	if err != nil {
+		return dev
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, ok := strings.CutPrefix(line, "DEVNAME="); ok {
+			return name
+		}
+	}
+	return dev
+}
+
+// readCgroupValue reads a single-value file such as memory.current. "max"
+// means no limit and reads as 0.
+func readCgroupValue(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// readKeyValues reads a flat keyed file such as cpu.stat or memory.stat
+func readKeyValues(path string) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]int64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
+
+// parseIOStat parses io.stat, one line per device:
+// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func parseIOStat(output string, name func(dev string) string) []BlockStats {
+	var blocks []BlockStats
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		b := BlockStats{Name: name(fields[0])}
+		b.Path = "/dev/" + b.Name
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				b.ReadBytes = n
+			case "wbytes":
+				b.WriteBytes = n
+			case "rios":
+				b.ReadReqs = n
+			case "wios":
+				b.WriteReqs = n
+			}
+		}
+		blocks = append(blocks, b)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Name < blocks[j].Name })
+	return blocks
+}
+
+// parsePressure parses a PSI file:
+// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+// full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePressure(output string) PressureStats {
+	var p PressureStats
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var avg10, avg60, avg300 *float64
+		var total *int64
+		switch fields[0] {
+		case "some":
+			avg10, avg60, avg300, total = &p.SomeAvg10, &p.SomeAvg60, &p.SomeAvg300, &p.SomeTotal
+		case "full":
+			avg10, avg60, avg300, total = &p.FullAvg10, &p.FullAvg60, &p.FullAvg300, &p.FullTotal
+		default:
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "avg10":
+				*avg10, _ = strconv.ParseFloat(value, 64)
+			case "avg60":
+				*avg60, _ = strconv.ParseFloat(value, 64)
+			case "avg300":
+				*avg300, _ = strconv.ParseFloat(value, 64)
+			case "total":
+				*total, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+	}
+	return p
+}
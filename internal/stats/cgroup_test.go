@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates files under root from a map of relative path to content
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// cgroupFixture lays out a host with two running domains, one frozen, and
+// an unrelated scope
+func cgroupFixture(t *testing.T) *CgroupCollector {
+	t.Helper()
+	root := t.TempDir()
+	vm1 := `machine.slice/machine-qemu\x2d1\x2dweb\x2dserver.scope/`
+	vm2 := `machine.slice/machine-qemu\x2d7\x2ddb.scope/`
+	writeTree(t, filepath.Join(root, "cgroup"), map[string]string{
+		vm1 + "cpu.stat":                  "usage_usec 5000000\nuser_usec 3000000\nsystem_usec 2000000\nnr_periods 0\nnr_throttled 4\nthrottled_usec 1500\n",
+		vm1 + "libvirt/vcpu0/cpu.stat":    "usage_usec 2000000\nuser_usec 1500000\nsystem_usec 500000\n",
+		vm1 + "libvirt/vcpu1/cpu.stat":    "usage_usec 2500000\n",
+		vm1 + "libvirt/emulator/cpu.stat": "usage_usec 500000\n",
+		vm1 + "memory.current":            "1073741824\n",
+		vm1 + "memory.max":                "max\n",
+		vm1 + "memory.swap.current":       "4096\n",
+		vm1 + "memory.stat":               "anon 805306368\nfile 134217728\nkernel 8388608\n",
+		vm1 + "io.stat":                   "253:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0\n8:16 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n",
+		vm1 + "cpu.pressure":              "some avg10=1.50 avg60=0.75 avg300=0.25 total=123456\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		vm1 + "io.pressure":               "some avg10=12.00 avg60=8.00 avg300=2.00 total=999\nfull avg10=10.00 avg60=6.00 avg300=1.00 total=888\n",
+		vm1 + "cgroup.events":             "populated 1\nfrozen 0\n",
+		// Only cpu.stat: the memory and io controllers are not enabled
+		vm2 + "cpu.stat":      "usage_usec 100\n",
+		vm2 + "cgroup.events": "populated 1\nfrozen 1\n",
+		"machine.slice/machine-lxc\\x2d9\\x2dct.scope/cpu.stat": "usage_usec 1\n",
+		"machine.slice/systemd-machined.service/cpu.stat":       "usage_usec 1\n",
+	})
+	writeTree(t, filepath.Join(root, "dev"), map[string]string{
+		"253:0/uevent": "MAJOR=253\nMINOR=0\nDEVNAME=dm-0\nDEVTYPE=disk\n",
+	})
+
+	return &CgroupCollector{Root: filepath.Join(root, "cgroup"), BlockDevRoot: filepath.Join(root, "dev")}
+}
+
+func TestCgroupCollectorGetVMStats(t *testing.T) {
+	c := cgroupFixture(t)
+	vms, err := c.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("GetVMStats: %v", err)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("expected 2 domains, got %d", len(vms))
+	}
+
+	db, web := vms[0], vms[1]
+	if web.DomainName != "web-server" || db.DomainName != "db" {
+		t.Fatalf("unexpected domains %q, %q", db.DomainName, web.DomainName)
+	}
+	if web.State != domainStateRunning || db.State != domainStatePaused {
+		t.Errorf("states = %d, %d; expected running and paused", web.State, db.State)
+	}
+
+	if web.CPU.Time != 5e9 || web.CPU.User != 3e9 || web.CPU.System != 2e9 {
+		t.Errorf("unexpected CPU stats %+v", web.CPU)
+	}
+	if len(web.VCPUStats) != 2 || web.VCPUStats[0].Time != 2e9 || web.VCPUStats[1].ID != 1 || web.VCPUStats[1].Time != 2.5e9 {
+		t.Errorf("unexpected vCPU stats %+v", web.VCPUStats)
+	}
+	if overhead := web.CPUOverhead(); overhead != 0.5e9 {
+		t.Errorf("overhead = %d; expected the emulator's 0.5s", overhead)
+	}
+
+	cg := web.Cgroup
+	if !cg.Collected || cg.MemoryCurrent != 1<<30 || cg.MemoryMax != 0 || cg.MemoryAnon != 768<<20 || cg.MemoryFile != 128<<20 || cg.MemorySwap != 4096 {
+		t.Errorf("unexpected memory stats %+v", cg)
+	}
+	if web.BalloonStats.RSS != 1<<20 {
+		t.Errorf("RSS = %d KiB; expected memory.current", web.BalloonStats.RSS)
+	}
+	if cg.ThrottledPeriods != 4 || cg.ThrottledTime != 1500000 {
+		t.Errorf("unexpected throttling %d periods, %d ns", cg.ThrottledPeriods, cg.ThrottledTime)
+	}
+	if cg.CPUPressure.SomeAvg10 != 1.5 || cg.CPUPressure.SomeTotal != 123456 {
+		t.Errorf("unexpected CPU pressure %+v", cg.CPUPressure)
+	}
+	if cg.IOPressure.FullAvg60 != 6 || cg.IOPressure.FullTotal != 888 {
+		t.Errorf("unexpected I/O pressure %+v", cg.IOPressure)
+	}
+
+	if len(web.BlockStats) != 2 {
+		t.Fatalf("expected 2 block devices, got %+v", web.BlockStats)
+	}
+	// Unresolvable devices keep their number
+	if b := web.BlockStats[0]; b.Name != "8:16" || b.ReadReqs != 1 {
+		t.Errorf("unexpected first device %+v", b)
+	}
+	if b := web.BlockStats[1]; b.Name != "dm-0" || b.Path != "/dev/dm-0" || b.ReadBytes != 1459200 || b.WriteBytes != 314773504 || b.ReadReqs != 192 || b.WriteReqs != 353 {
+		t.Errorf("unexpected second device %+v", b)
+	}
+
+	if !db.Cgroup.Collected || db.Cgroup.MemoryCurrent != 0 || len(db.BlockStats) != 0 {
+		t.Errorf("expected missing controllers to be skipped, got %+v", db)
+	}
+}
+
+func TestCgroupCollectorDomainFilter(t *testing.T) {
+	c := cgroupFixture(t)
+	vms, err := c.GetVMStats(context.Background(), []string{"db", "missing"})
+	if err != nil {
+		t.Fatalf("GetVMStats: %v", err)
+	}
+	if len(vms) != 1 || vms[0].DomainName != "db" {
+		t.Errorf("expected only db, got %+v", vms)
+	}
+}
+
+func TestCgroupCollectorNoMachineSlice(t *testing.T) {
+	c := &CgroupCollector{Root: t.TempDir()}
+	if _, err := c.GetVMStats(context.Background(), nil); err == nil {
+		t.Error("expected an error without machine.slice")
+	}
+}
+
+func TestDomainFromScope(t *testing.T) {
+	tests := []struct {
+		scope, expected string
+		id              int
+		ok              bool
+	}{
+		{`machine-qemu\x2d1\x2dvm1.scope`, "vm1", 1, true},
+		{`machine-qemu\x2d12\x2dmy\x2dvm.scope`, "my-vm", 12, true},
+		{`machine-qemu\x2d3\x2dvm.with.dots.scope`, "vm.with.dots", 3, true},
+		{`machine-lxc\x2d1\x2dct.scope`, "", 0, false},
+		{`machine-qemu\x2d4.scope`, "", 0, false},
+		{`machine-qemu\x2dx\x2dvm1.scope`, "", 0, false},
+	}
+	for _, tt := range tests {
+		id, name, ok := domainFromScope(tt.scope)
+		if id != tt.id || name != tt.expected || ok != tt.ok {
+			t.Errorf("domainFromScope(%q) = %d, %q, %v; expected %d, %q, %v", tt.scope, id, name, ok, tt.id, tt.expected, tt.ok)
+		}
+	}
+}
@@ -1,32 +1,156 @@
 package stats
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-// StatsCollector defines an interface for collecting VM stats
+// StatsCollector defines an interface for collecting VM stats. Cancelling
+// ctx abandons an in-flight collection.
 type StatsCollector interface {
-	GetVMStats(domains []string) ([]VMStats, error)
+	GetVMStats(ctx context.Context, domains []string) ([]VMStats, error)
 }
 
+// Capability names one of the optional interfaces a collector may implement
+// besides StatsCollector
+type Capability int
+
+const (
+	CapHostStats Capability = iota // HostCollector
+	CapPools                       // PoolCollector
+	CapNetworks                    // NetworkCollector
+	CapEvents                      // EventSource
+)
+
+// CapabilityReporter is implemented by collectors that implement every
+// optional interface on behalf of something else, such as the collector
+// they wrap or a recording, and can only serve some of them
+type CapabilityReporter interface {
+	Supports(capability Capability) bool
+}
+
+// Supports reports whether c can be used through the optional interface
+// named by capability: it implements it, and if c is a CapabilityReporter,
+// reports it as supported
+func Supports(c StatsCollector, capability Capability) bool {
+	var ok bool
+	switch capability {
+	case CapHostStats:
+		_, ok = c.(HostCollector)
+	case CapPools:
+		_, ok = c.(PoolCollector)
+	case CapNetworks:
+		_, ok = c.(NetworkCollector)
+	case CapEvents:
+		_, ok = c.(EventSource)
+	}
+	if reporter, isReporter := c.(CapabilityReporter); ok && isReporter {
+		return reporter.Supports(capability)
+	}
+	return ok
+}
+
+// Defaults for VirshCollector enrichment
+const (
+	DefaultWorkers        = 8
+	DefaultCommandTimeout = 5 * time.Second
+)
+
 // VirshCollector collects stats using the virsh command
-type VirshCollector struct{}
+type VirshCollector struct {
+	uri string
+
+	// Workers bounds how many per-domain virsh commands run at once
+	Workers int
+	// CommandTimeout bounds each virsh invocation so a wedged domain or
+	// hung libvirtd cannot stall a refresh
+	CommandTimeout time.Duration
+	// MetadataTTL bounds how long static domain metadata is cached
+	MetadataTTL time.Duration
+	// IPSources lists the domifaddr sources tried, in order, until one
+	// yields addresses
+	IPSources []string
+	// Perf requests perf event counters
+	Perf bool
+	// DirtyRateInterval is how often a dirty page rate calculation is
+	// started on running domains; 0 disables dirty rate and memory
+	// bandwidth collection
+	DirtyRateInterval time.Duration
+
+	metadata    *metadataCache
+	node        nodeInfoCache
+	dirtyRate   dirtyRateSchedule
+	unsupported atomic.Uint32 // optional stats groups the host rejected
+}
 
-// NewVirshCollector creates a new VirshCollector
-func NewVirshCollector() *VirshCollector {
-	return &VirshCollector{}
+// NewVirshCollector creates a new VirshCollector. An empty uri uses virsh's
+// default connection.
+func NewVirshCollector(uri string) *VirshCollector {
+	return &VirshCollector{
+		uri:            uri,
+		Workers:        DefaultWorkers,
+		CommandTimeout: DefaultCommandTimeout,
+		MetadataTTL:    DefaultMetadataTTL,
+		IPSources:      DefaultIPSources,
+		metadata:       newMetadataCache(),
+	}
+}
+
+// virsh builds a virsh command bound to the collector's connection
+func (c *VirshCollector) virsh(ctx context.Context, args ...string) *exec.Cmd {
+	if c.uri != "" {
+		args = append([]string{"--connect", c.uri}, args...)
+	}
+	return exec.CommandContext(ctx, "virsh", args...)
+}
+
+// run executes virsh with the per-command timeout and returns its stdout
+func (c *VirshCollector) run(ctx context.Context, args ...string) ([]byte, error) {
+	if c.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.CommandTimeout)
+		defer cancel()
+	}
+	output, err := c.virsh(ctx, args...).Output()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return output, err
 }
 
 // GetVMStats parses virsh domstats output
-func (c *VirshCollector) GetVMStats(domains []string) ([]VMStats, error) {
-	args := []string{"domstats", "--vcpu", "--balloon", "--block", "--interface", "--state"}
-	args = append(args, domains...)
-	cmd := exec.Command("virsh", args...)
-	output, err := cmd.Output()
+func (c *VirshCollector) GetVMStats(ctx context.Context, domains []string) ([]VMStats, error) {
+	domstats := func(groups uint32) ([]byte, error) {
+		args := []string{"domstats", "--cpu-total", "--vcpu", "--balloon", "--block", "--interface", "--state"}
+		for _, group := range optionalStatsGroups {
+			if groups&group.flag != 0 {
+				args = append(args, group.option)
+			}
+		}
+		return c.run(ctx, append(args, domains...)...)
+	}
+
+	groups := wantedStatsGroups(c.Perf, c.DirtyRateInterval) &^ c.unsupported.Load()
+	output, err := domstats(groups)
+	if groups != 0 && virshRejectsFlag(err) {
+		// Older libvirt rejects some groups; find out which and keep
+		// collecting the others
+		if rejected := probeStatsGroups(groups, func(groups uint32) bool {
+			_, err := domstats(groups)
+			return virshRejectsFlag(err)
+		}); rejected != 0 {
+			c.unsupported.Or(rejected)
+			groups &^= rejected
+			output, err = domstats(groups)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute virsh: %w", err)
 	}
@@ -36,18 +160,210 @@ func (c *VirshCollector) GetVMStats(domains []string) ([]VMStats, error) {
 		return nil, err
 	}
 
+	// Host CPUs are best effort; without them domain CPU % is unnormalized
+	node, _ := c.nodeInfo(ctx)
+
+	// domstats has no domain IDs, which tell restarts apart; also best
+	// effort, as the CPU time check still catches most restarts
+	ids := c.domainIDs(ctx)
+
 	// Set timestamp for CPU calculation
 	now := time.Now().UnixNano()
 	for i := range stats {
 		stats[i].LastUpdate = now
+		stats[i].HostCPUs = node.CPUs
+		if id, ok := ids[stats[i].DomainName]; ok {
+			stats[i].ID = id
+		}
+		markCollected(&stats[i], groups)
 	}
+	c.metadata.observe(stats)
 
-	enrichWithIPs(stats)
-	enrichWithOSType(stats)
+	// Results are read on every refresh, but a new measurement is only
+	// started every DirtyRateInterval
+	startDirtyRate := groups&domainStatsDirtyRate != 0 && c.dirtyRate.due(time.Now(), c.DirtyRateInterval)
+
+	// One lease listing per network replaces a lease lookup per domain
+	var leases leaseTable
+	if wantsLeases(c.IPSources) {
+		leases = c.dhcpLeases(ctx)
+	}
+
+	// Enrichment is per domain and best effort: a failed or timed out
+	// command only leaves that domain's extras empty
+	runParallel(ctx, len(stats), c.Workers, func(i int) {
+		c.enrichWithMetadata(ctx, &stats[i])
+		c.enrichWithConfig(ctx, &stats[i])
+		c.enrichWithIPs(ctx, &stats[i], leases)
+		if startDirtyRate && stats[i].State == 1 {
+			_, _ = c.run(ctx, "domdirtyrate-calc", stats[i].DomainName, "--seconds", strconv.Itoa(dirtyRateWindow))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	return stats, nil
 }
 
+// virshRejectsFlag reports whether virsh failed because it or the daemon
+// does not know an option or stats flag, as opposed to timing out or failing
+// for another reason. Only the messages virsh and libvirt use for those are
+// matched; "unsupported configuration" and the like are about a domain.
+func virshRejectsFlag(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	for _, line := range strings.Split(string(exitErr.Stderr), "\n") {
+		switch {
+		case strings.Contains(line, "doesn't support option"), // virsh
+			strings.Contains(line, "unsupported flags"),     // VIR_ERR_INVALID_ARG
+			strings.Contains(line, "argument unsupported:"), // VIR_ERR_ARGUMENT_UNSUPPORTED
+			strings.Contains(line, "invalid argument:") && strings.Contains(line, "flag"):
+			return true
+		}
+	}
+	return false
+}
+
+// nodeInfo returns the hypervisor's hardware description, read once
+func (c *VirshCollector) nodeInfo(ctx context.Context) (NodeInfo, error) {
+	return c.node.get(func() (NodeInfo, error) {
+		output, err := c.run(ctx, "nodeinfo")
+		if err != nil {
+			return NodeInfo{}, fmt.Errorf("failed to get node info: %w", err)
+		}
+		return parseNodeInfo(string(output)), nil
+	})
+}
+
+// GetHostStats reads the hypervisor's CPU times and memory usage
+func (c *VirshCollector) GetHostStats(ctx context.Context) (HostStats, error) {
+	node, err := c.nodeInfo(ctx)
+	if err != nil {
+		return HostStats{}, err
+	}
+	host := HostStats{Node: node}
+
+	output, err := c.run(ctx, "nodecpustats")
+	if err != nil {
+		return HostStats{}, fmt.Errorf("failed to get node CPU stats: %w", err)
+	}
+	parseNodeStats(string(output), func(field string, value int64) {
+		applyHostCPUStat(field, value, &host.CPU)
+	})
+
+	output, err = c.run(ctx, "nodememstats")
+	if err != nil {
+		return HostStats{}, fmt.Errorf("failed to get node memory stats: %w", err)
+	}
+	parseNodeStats(string(output), func(field string, value int64) {
+		applyHostMemoryStat(field, value, &host.Memory)
+	})
+
+	host.LastUpdate = time.Now().UnixNano()
+	return host, nil
+}
+
+// GetStoragePools lists every storage pool with its usage and target path
+func (c *VirshCollector) GetStoragePools(ctx context.Context) ([]StoragePool, error) {
+	output, err := c.run(ctx, "pool-list", "--all", "--name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage pools: %w", err)
+	}
+
+	var pools []StoragePool
+	for _, name := range strings.Split(string(output), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			pools = append(pools, StoragePool{Name: name})
+		}
+	}
+
+	// Details are best effort per pool, like domain enrichment
+	runParallel(ctx, len(pools), c.Workers, func(i int) {
+		p := &pools[i]
+		if output, err := c.run(ctx, "pool-info", "--bytes", p.Name); err == nil {
+			parsePoolInfo(string(output), p)
+		}
+		if output, err := c.run(ctx, "pool-dumpxml", p.Name); err == nil {
+			p.Type, p.Path, _ = parsePoolXML(string(output))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// GetNetworks lists every virtual network with its bridge, forward mode and,
+// for active networks, DHCP leases
+func (c *VirshCollector) GetNetworks(ctx context.Context) ([]Network, error) {
+	output, err := c.run(ctx, "net-list", "--all", "--name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	var networks []Network
+	for _, name := range strings.Split(string(output), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			networks = append(networks, Network{Name: name})
+		}
+	}
+
+	// Details are best effort per network, like domain enrichment
+	runParallel(ctx, len(networks), c.Workers, func(i int) {
+		n := &networks[i]
+		if output, err := c.run(ctx, "net-info", n.Name); err == nil {
+			parseNetInfo(string(output), n)
+		}
+		if output, err := c.run(ctx, "net-dumpxml", n.Name); err == nil {
+			_ = parseNetworkXML(string(output), n)
+		}
+		if !n.Active {
+			return
+		}
+		if output, err := c.run(ctx, "net-dhcp-leases", n.Name); err == nil {
+			n.Leases = parseDHCPLeases(string(output))
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+// WatchEvents streams domain events from a long-running `virsh event`.
+// Each event also drops the domain's cached metadata, since a domain that
+// was redefined, started or stopped may have a new configuration.
+func (c *VirshCollector) WatchEvents(ctx context.Context) (<-chan DomainEvent, error) {
+	return watchLoop(ctx, c.streamEvents), nil
+}
+
+// streamEvents runs `virsh event --loop --all` until it exits or ctx is
+// cancelled
+func (c *VirshCollector) streamEvents(ctx context.Context, events chan<- DomainEvent) error {
+	cmd := c.virsh(ctx, "event", "--loop", "--all")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to watch events: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to watch events: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		ev, ok := parseEventLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		c.metadata.invalidate(ev.Domain)
+		emit(ctx, events, ev)
+	}
+	return cmd.Wait()
+}
+
 func parseVirshOutput(output string) ([]VMStats, error) {
 	var allStats []VMStats
 	lines := strings.Split(output, "\n")
@@ -82,30 +398,7 @@ func parseVirshOutput(output string) ([]VMStats, error) {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
-		// Parse state
-		if strings.HasPrefix(key, "state.") {
-			parseState(key, value, currentStats)
-		}
-
-		// Parse balloon stats
-		if strings.HasPrefix(key, "balloon.") {
-			parseBaloonStat(key, value, currentStats)
-		}
-
-		// Parse VCPU stats
-		if strings.HasPrefix(key, "vcpu.") {
-			parseVCPUStat(key, value, currentStats)
-		}
-
-		// Parse block stats
-		if strings.HasPrefix(key, "block.") {
-			parseBlockStat(key, value, currentStats)
-		}
-
-		// Parse interface stats
-		if strings.HasPrefix(key, "net.") {
-			parseInterfaceStat(key, value, currentStats)
-		}
+		applyStat(key, value, currentStats)
 	}
 
 	// Append the last one
@@ -116,6 +409,77 @@ func parseVirshOutput(output string) ([]VMStats, error) {
 	return allStats, nil
 }
 
+// optionalStatsGroups are stats groups that are only requested on demand and
+// that older hosts may reject, with their domstats options
+var optionalStatsGroups = []struct {
+	flag   uint32
+	option string
+}{
+	{domainStatsIOThread, "--iothread"},
+	{domainStatsPerf, "--perf"},
+	{domainStatsMemory, "--memory"},
+	{domainStatsDirtyRate, "--dirtyrate"},
+}
+
+// wantedStatsGroups returns the optional stats groups a collector asks for.
+// IOThreads are always wanted but only exist on newer hosts.
+func wantedStatsGroups(perf bool, dirtyRateInterval time.Duration) uint32 {
+	groups := uint32(domainStatsIOThread)
+	if perf {
+		groups |= domainStatsPerf
+	}
+	if dirtyRateInterval > 0 {
+		groups |= domainStatsDirtyRate | domainStatsMemory
+	}
+	return groups
+}
+
+// probeStatsGroups tries each of groups on its own after a stats call asking
+// for all of them failed, and returns the ones the host rejects. Groups that
+// work alone are kept, so one unsupported group does not disable the rest.
+func probeStatsGroups(groups uint32, rejects func(groups uint32) bool) uint32 {
+	var rejected uint32
+	for _, group := range optionalStatsGroups {
+		if groups&group.flag != 0 && rejects(group.flag) {
+			rejected |= group.flag
+		}
+	}
+	return rejected
+}
+
+// markCollected records which optional groups were collected, so the UI can
+// tell "not requested" apart from "nothing reported"
+func markCollected(vm *VMStats, groups uint32) {
+	vm.Perf.Collected = groups&domainStatsPerf != 0
+	vm.DirtyRate.Collected = groups&domainStatsDirtyRate != 0
+}
+
+// applyStat routes a single domstats field to the parser for its group
+func applyStat(key, value string, stats *VMStats) {
+	switch {
+	case strings.HasPrefix(key, "state."):
+		parseState(key, value, stats)
+	case strings.HasPrefix(key, "balloon."):
+		parseBaloonStat(key, value, stats)
+	case strings.HasPrefix(key, "cpu."):
+		parseCPUStat(key, value, stats)
+	case strings.HasPrefix(key, "perf."):
+		parsePerfStat(key, value, stats)
+	case strings.HasPrefix(key, "dirtyrate."):
+		parseDirtyRateStat(key, value, stats)
+	case strings.HasPrefix(key, "memory.bandwidth.monitor."):
+		parseMemoryBandwidthStat(key, value, stats)
+	case strings.HasPrefix(key, "vcpu."):
+		parseVCPUStat(key, value, stats)
+	case strings.HasPrefix(key, "iothread."):
+		parseIOThreadStat(key, value, stats)
+	case strings.HasPrefix(key, "block."):
+		parseBlockStat(key, value, stats)
+	case strings.HasPrefix(key, "net."):
+		parseInterfaceStat(key, value, stats)
+	}
+}
+
 func parseBaloonStat(key, value string, stats *VMStats) {
 	val, _ := strconv.ParseInt(value, 10, 64)
 	switch key {
@@ -131,6 +495,56 @@ func parseBaloonStat(key, value string, stats *VMStats) {
 		stats.BalloonStats.Usable = val
 	case "balloon.rss":
 		stats.BalloonStats.RSS = val
+	case "balloon.swap_in":
+		stats.BalloonStats.SwapIn = val
+	case "balloon.swap_out":
+		stats.BalloonStats.SwapOut = val
+	case "balloon.major_fault":
+		stats.BalloonStats.MajorFault = val
+	case "balloon.minor_fault":
+		stats.BalloonStats.MinorFault = val
+	case "balloon.disk_caches":
+		stats.BalloonStats.DiskCaches = val
+	case "balloon.hugetlb_pgalloc":
+		stats.BalloonStats.HugetlbPgAlloc = val
+	case "balloon.hugetlb_pgfail":
+		stats.BalloonStats.HugetlbPgFail = val
+	case "balloon.last-update":
+		stats.BalloonStats.LastUpdate = val
+	}
+}
+
+func parseCPUStat(key, value string, stats *VMStats) {
+	val, _ := strconv.ParseInt(value, 10, 64)
+	switch key {
+	case "cpu.time":
+		stats.CPU.Time = val
+	case "cpu.user":
+		stats.CPU.User = val
+	case "cpu.system":
+		stats.CPU.System = val
+	}
+}
+
+func parsePerfStat(key, value string, stats *VMStats) {
+	val, _ := strconv.ParseInt(value, 10, 64)
+	switch key {
+	case "perf.cpu_cycles":
+		stats.Perf.CPUCycles = val
+	case "perf.instructions":
+		stats.Perf.Instructions = val
+	case "perf.cache_references":
+		stats.Perf.CacheReferences = val
+	case "perf.cache_misses":
+		stats.Perf.CacheMisses = val
+	case "perf.branch_instructions":
+		stats.Perf.BranchInstructions = val
+	case "perf.branch_misses":
+		stats.Perf.BranchMisses = val
+	case "perf.context_switches":
+		stats.Perf.ContextSwitches = val
+	case "perf.cpu_migrations":
+		stats.Perf.CPUMigrations = val
 	}
 }
 
@@ -163,6 +577,10 @@ func parseVCPUStat(key, value string, stats *VMStats) {
 			stats.VCPUStats[vcpuID].State = int(val)
 		case "time":
 			stats.VCPUStats[vcpuID].Time = val
+		case "wait":
+			stats.VCPUStats[vcpuID].Wait = val
+		case "delay":
+			stats.VCPUStats[vcpuID].Delay = val
 		}
 	}
 
@@ -182,6 +600,42 @@ func parseVCPUStat(key, value string, stats *VMStats) {
 	}
 }
 
+// parseIOThreadStat parses iothread.<id>.* fields. Entries are keyed by
+// IOThread ID, which need not be contiguous.
+func parseIOThreadStat(key, value string, stats *VMStats) {
+	parts := strings.Split(key, ".")
+	if len(parts) != 3 {
+		return
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return
+	}
+
+	var thread *IOThreadStats
+	for i := range stats.IOThreadStats {
+		if stats.IOThreadStats[i].ID == id {
+			thread = &stats.IOThreadStats[i]
+			break
+		}
+	}
+	if thread == nil {
+		stats.IOThreadStats = append(stats.IOThreadStats, IOThreadStats{ID: id})
+		thread = &stats.IOThreadStats[len(stats.IOThreadStats)-1]
+	}
+
+	val, _ := strconv.ParseInt(value, 10, 64)
+	switch parts[2] {
+	case "poll-max-ns":
+		thread.PollMaxNs = val
+	case "poll-grow":
+		thread.PollGrow = val
+	case "poll-shrink":
+		thread.PollShrink = val
+	}
+}
+
 func parseBlockStat(key, value string, stats *VMStats) {
 	parts := strings.Split(key, ".")
 	if len(parts) < 2 {
@@ -223,8 +677,8 @@ func parseBlockStat(key, value string, stats *VMStats) {
 
 	// Parse rd/wr stats
 	if len(parts) >= 4 {
-		operation := parts[2] // rd or wr
-		metric := parts[3]    // reqs or bytes
+		operation := parts[2] // rd, wr or fl
+		metric := parts[3]    // reqs, bytes or times
 		val, _ := strconv.ParseInt(value, 10, 64)
 
 		switch operation {
@@ -234,6 +688,8 @@ func parseBlockStat(key, value string, stats *VMStats) {
 				stats.BlockStats[blockID].ReadReqs = val
 			case "bytes":
 				stats.BlockStats[blockID].ReadBytes = val
+			case "times":
+				stats.BlockStats[blockID].ReadTime = val
 			}
 		case "wr":
 			switch metric {
@@ -241,6 +697,15 @@ func parseBlockStat(key, value string, stats *VMStats) {
 				stats.BlockStats[blockID].WriteReqs = val
 			case "bytes":
 				stats.BlockStats[blockID].WriteBytes = val
+			case "times":
+				stats.BlockStats[blockID].WriteTime = val
+			}
+		case "fl":
+			switch metric {
+			case "reqs":
+				stats.BlockStats[blockID].FlushReqs = val
+			case "times":
+				stats.BlockStats[blockID].FlushTime = val
 			}
 		}
 	}
@@ -313,74 +778,118 @@ func parseState(key, value string, stats *VMStats) {
 	}
 }
 
-func enrichWithIPs(vms []VMStats) {
-	for i := range vms {
-		// Only check IPs for running VMs (State == 1)
-		if vms[i].State != 1 {
-			continue
+// enrichWithIPs tries each configured address source until one yields
+// addresses for the domain's interfaces. Lease addresses come from leases
+// unless they could not be listed.
+func (c *VirshCollector) enrichWithIPs(ctx context.Context, vm *VMStats, leases leaseTable) {
+	// Only check IPs for running VMs (State == 1)
+	if vm.State != 1 {
+		return
+	}
+
+	for _, source := range c.IPSources {
+		var addrs []discoveredAddr
+		if source == IPSourceLease && leases != nil {
+			addrs = leases.lookup(vm)
+		} else {
+			output, err := c.run(ctx, "domifaddr", vm.DomainName, "--full", "--source", source)
+			if err != nil {
+				// The source may be unavailable for this domain (e.g. no
+				// guest agent); IPs are "nice to have", so move on to the
+				// next one
+				continue
+			}
+			addrs = parseDomIfAddr(string(output), source)
 		}
 
-		cmd := exec.Command("virsh", "domifaddr", vms[i].DomainName, "--full", "--source", "lease")
-		output, err := cmd.Output()
-		if err != nil {
-			// Try without source arg if lease fails, or maybe agent
-			// For now, just ignore errors as IPs are "nice to have"
-			continue
+		// MACs come from the domain config, so agent addresses reported
+		// under guest-side interface names still match
+		if assigned := assignAddresses(vm, addrs); assigned > 0 {
+			return
 		}
+	}
+}
 
-		parseDomIfAddr(string(output), &vms[i])
+// domainIDs lists the ID of every domain by name, or returns nil if they
+// could not be listed
+func (c *VirshCollector) domainIDs(ctx context.Context) map[string]int {
+	output, err := c.run(ctx, "list", "--all")
+	if err != nil {
+		return nil
 	}
+	return parseDomainIDs(string(output))
 }
 
-func parseDomIfAddr(output string, vm *VMStats) {
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "Name") || strings.HasPrefix(line, "-") {
+// parseDomainIDs reads the ID and name columns of virsh list. Inactive
+// domains are listed with a "-" ID and get -1, as libvirt reports them.
+func parseDomainIDs(output string) map[string]int {
+	ids := make(map[string]int)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
 			continue
 		}
+		if fields[0] == "-" {
+			ids[fields[1]] = -1
+		} else if id, err := strconv.Atoi(fields[0]); err == nil {
+			ids[fields[1]] = id
+		}
+	}
+	return ids
+}
 
-		// Expected format: interface MAC protocol address
-		// vnet0 52:54:00:12:34:56 ipv4 192.168.122.238/24
-		fields := strings.Fields(line)
-		if len(fields) >= 4 {
-			ifName := fields[0]
-			// protocol := fields[2]
-			address := fields[3]
-
-			// Strip CIDR from address if present
-			if idx := strings.Index(address, "/"); idx != -1 {
-				address = address[:idx]
-			}
+// dhcpLeases lists the leases of every active network, or returns nil if
+// they could not all be listed
+func (c *VirshCollector) dhcpLeases(ctx context.Context) leaseTable {
+	output, err := c.run(ctx, "net-list", "--name")
+	if err != nil {
+		return nil
+	}
 
-			// Find matching interface in stats and append IP
-			for j := range vm.InterfaceStats {
-				if vm.InterfaceStats[j].Name == ifName {
-					vm.InterfaceStats[j].IPs = append(vm.InterfaceStats[j].IPs, address)
-					break
-				}
-			}
+	var leases []DHCPLease
+	for _, name := range strings.Split(string(output), "\n") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		output, err := c.run(ctx, "net-dhcp-leases", name)
+		if err != nil {
+			return nil
 		}
+		leases = append(leases, parseDHCPLeases(string(output))...)
 	}
+	return newLeaseTable(leases)
 }
 
-func enrichWithOSType(vms []VMStats) {
-	for i := range vms {
-		cmd := exec.Command("virsh", "dominfo", vms[i].DomainName)
-		output, err := cmd.Output()
+// enrichWithConfig joins the domain's XML configuration onto its stats. The
+// parsed XML is cached alongside the domain's metadata.
+func (c *VirshCollector) enrichWithConfig(ctx context.Context, vm *VMStats) {
+	cfg, ok := c.metadata.lookupConfig(vm.DomainName, time.Now(), c.MetadataTTL)
+	if !ok {
+		output, err := c.run(ctx, "dumpxml", vm.DomainName)
 		if err != nil {
-			continue
+			return
+		}
+		if cfg, err = parseDomainXML(string(output)); err != nil {
+			return
 		}
+		c.metadata.storeConfig(vm.DomainName, cfg)
+	}
+	applyDomainConfig(cfg, vm)
+}
 
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "OS Type:") {
-				parts := strings.Split(line, ":")
-				if len(parts) > 1 {
-					vms[i].OSType = strings.TrimSpace(parts[1])
-				}
-				break
-			}
+// enrichWithMetadata fills static metadata from the cache, running virsh
+// dominfo only for domains that are new or whose entry was invalidated
+func (c *VirshCollector) enrichWithMetadata(ctx context.Context, vm *VMStats) {
+	md, ok := c.metadata.lookup(vm.DomainName, time.Now(), c.MetadataTTL)
+	if !ok {
+		output, err := c.run(ctx, "dominfo", vm.DomainName)
+		if err != nil {
+			return
 		}
+		md = parseDomInfo(string(output))
+		c.metadata.store(vm.DomainName, md, time.Now())
 	}
+
+	vm.Metadata = md
+	vm.OSType = md.OSType
 }
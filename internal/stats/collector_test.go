@@ -1,7 +1,14 @@
 package stats
 
 import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseVirshOutput(t *testing.T) {
@@ -90,6 +97,183 @@ func TestParseVirshOutput(t *testing.T) {
 	}
 }
 
+func TestParseVCPUWaitDelay(t *testing.T) {
+	output := `Domain: 'busy'
+  vcpu.current=2
+  vcpu.0.state=1
+  vcpu.0.time=1000000000
+  vcpu.0.wait=250000000
+  vcpu.0.delay=120000000
+  vcpu.1.wait=7
+  vcpu.1.delay=9
+`
+
+	allStats, err := parseVirshOutput(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	vcpus := allStats[0].VCPUStats
+	if len(vcpus) != 2 {
+		t.Fatalf("Expected 2 VCPUs, got %d", len(vcpus))
+	}
+	if vcpus[0].Wait != 250000000 || vcpus[0].Delay != 120000000 {
+		t.Errorf("Expected VCPU 0 wait 250000000 delay 120000000, got %d %d", vcpus[0].Wait, vcpus[0].Delay)
+	}
+	if vcpus[1].Wait != 7 || vcpus[1].Delay != 9 {
+		t.Errorf("Expected VCPU 1 wait 7 delay 9, got %d %d", vcpus[1].Wait, vcpus[1].Delay)
+	}
+}
+
+func TestParseCPUTotal(t *testing.T) {
+	output := `Domain: 'web'
+  cpu.time=12000000000
+  cpu.user=1500000000
+  cpu.system=3000000000
+  cpu.cache.monitor.count=0
+  vcpu.current=2
+  vcpu.maximum=2
+  vcpu.0.state=1
+  vcpu.0.time=5000000000
+  vcpu.1.state=1
+  vcpu.1.time=4000000000
+`
+
+	allStats, err := parseVirshOutput(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	vm := allStats[0]
+	if vm.CPU.Time != 12000000000 || vm.CPU.User != 1500000000 || vm.CPU.System != 3000000000 {
+		t.Errorf("Unexpected domain CPU stats: %+v", vm.CPU)
+	}
+	if overhead := vm.CPUOverhead(); overhead != 3000000000 {
+		t.Errorf("Expected 3000000000ns overhead, got %d", overhead)
+	}
+}
+
+func TestParsePerfStats(t *testing.T) {
+	output := `Domain: 'noisy'
+  perf.cmt=0
+  perf.cpu_cycles=2000000000
+  perf.instructions=3000000000
+  perf.cache_references=40000000
+  perf.cache_misses=4000000
+  perf.context_switches=5000
+`
+
+	allStats, err := parseVirshOutput(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	p := allStats[0].Perf
+	if p.CPUCycles != 2000000000 || p.Instructions != 3000000000 {
+		t.Errorf("Expected cycles/instructions 2000000000/3000000000, got %d/%d", p.CPUCycles, p.Instructions)
+	}
+	if p.CacheReferences != 40000000 || p.CacheMisses != 4000000 {
+		t.Errorf("Expected cache refs/misses 40000000/4000000, got %d/%d", p.CacheReferences, p.CacheMisses)
+	}
+	if p.ContextSwitches != 5000 {
+		t.Errorf("Expected 5000 context switches, got %d", p.ContextSwitches)
+	}
+}
+
+func TestParseBalloonStats(t *testing.T) {
+	output := `Domain: 'web'
+  balloon.current=4194304
+  balloon.maximum=4194304
+  balloon.swap_in=128
+  balloon.swap_out=256
+  balloon.major_fault=42
+  balloon.minor_fault=123456
+  balloon.unused=1048576
+  balloon.available=4012345
+  balloon.usable=2500000
+  balloon.last-update=1700000000
+  balloon.disk_caches=1500000
+  balloon.hugetlb_pgalloc=7
+  balloon.hugetlb_pgfail=1
+  balloon.rss=3000000
+`
+
+	allStats, err := parseVirshOutput(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	b := allStats[0].BalloonStats
+	if b.SwapIn != 128 || b.SwapOut != 256 {
+		t.Errorf("Expected swap in/out 128/256, got %d/%d", b.SwapIn, b.SwapOut)
+	}
+	if b.MajorFault != 42 || b.MinorFault != 123456 {
+		t.Errorf("Expected major/minor faults 42/123456, got %d/%d", b.MajorFault, b.MinorFault)
+	}
+	if b.DiskCaches != 1500000 {
+		t.Errorf("Expected disk caches 1500000, got %d", b.DiskCaches)
+	}
+	if b.HugetlbPgAlloc != 7 || b.HugetlbPgFail != 1 {
+		t.Errorf("Expected hugetlb alloc/fail 7/1, got %d/%d", b.HugetlbPgAlloc, b.HugetlbPgFail)
+	}
+	if b.LastUpdate != 1700000000 {
+		t.Errorf("Expected last update 1700000000, got %d", b.LastUpdate)
+	}
+}
+
+func TestParseIOThreadStats(t *testing.T) {
+	output := `Domain: 'db'
+  iothread.count=2
+  iothread.1.poll-max-ns=32768
+  iothread.1.poll-grow=0
+  iothread.1.poll-shrink=0
+  iothread.4.poll-max-ns=0
+  iothread.4.poll-grow=2
+  iothread.4.poll-shrink=4
+`
+
+	allStats, err := parseVirshOutput(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []IOThreadStats{
+		{ID: 1, PollMaxNs: 32768},
+		{ID: 4, PollGrow: 2, PollShrink: 4},
+	}
+	threads := allStats[0].IOThreadStats
+	if len(threads) != len(expected) {
+		t.Fatalf("Expected %d IOThreads, got %+v", len(expected), threads)
+	}
+	for i := range expected {
+		if threads[i] != expected[i] {
+			t.Errorf("Expected IOThread %+v, got %+v", expected[i], threads[i])
+		}
+	}
+}
+
+func TestParseBlockTimes(t *testing.T) {
+	output := `Domain: 'db'
+  block.count=1
+  block.0.name=vda
+  block.0.rd.reqs=5000
+  block.0.rd.bytes=104857600
+  block.0.rd.times=2500000000
+  block.0.wr.reqs=2000
+  block.0.wr.bytes=41943040
+  block.0.wr.times=4000000000
+  block.0.fl.reqs=300
+  block.0.fl.times=900000000
+`
+
+	allStats, err := parseVirshOutput(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	disk := allStats[0].BlockStats[0]
+	if disk.ReadTime != 2500000000 || disk.WriteTime != 4000000000 {
+		t.Errorf("Expected rd/wr times 2500000000/4000000000, got %d/%d", disk.ReadTime, disk.WriteTime)
+	}
+	if disk.FlushReqs != 300 || disk.FlushTime != 900000000 {
+		t.Errorf("Expected 300 flushes taking 900000000ns, got %d/%d", disk.FlushReqs, disk.FlushTime)
+	}
+}
+
 func TestParseVirshOutputMulti(t *testing.T) {
 	output := `Domain: 'vm1'
   balloon.current=1024
@@ -131,3 +315,375 @@ func TestParseVirshEmpty(t *testing.T) {
 		t.Errorf("Expected empty slice, got %d items", len(stats))
 	}
 }
+
+func TestParseDomainIDs(t *testing.T) {
+	output := ` Id   Name     State
+-------------------------
+ 1    vm1      running
+ 12   vm2      paused
+ -    vm3      shut off
+
+`
+	ids := parseDomainIDs(output)
+	expected := map[string]int{"vm1": 1, "vm2": 12, "vm3": -1}
+	if len(ids) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ids)
+	}
+	for name, id := range expected {
+		if ids[name] != id {
+			t.Errorf("Expected ID %d for %s, got %d", id, name, ids[name])
+		}
+	}
+}
+
+func TestVirshCommandConnectURI(t *testing.T) {
+	cmd := NewVirshCollector("qemu:///session").virsh(context.Background(), "domstats", "--state")
+	expected := []string{"virsh", "--connect", "qemu:///session", "domstats", "--state"}
+	if strings.Join(cmd.Args, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected args %v, got %v", expected, cmd.Args)
+	}
+
+	cmd = NewVirshCollector("").virsh(context.Background(), "dominfo", "vm1")
+	expected = []string{"virsh", "dominfo", "vm1"}
+	if strings.Join(cmd.Args, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected args %v, got %v", expected, cmd.Args)
+	}
+}
+
+// fakeVirsh puts a shell script named virsh first on PATH for the test
+func fakeVirsh(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "virsh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("Failed to write fake virsh: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestVirshCollectorPerfUnsupported(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeVirsh(t, `echo "$@" >> `+calls+`
+case "$*" in
+*--perf*)
+	echo "error: command 'domstats' doesn't support option --perf" >&2
+	exit 1
+	;;
+domstats*)
+	printf "Domain: 'vm1'\n  state.state=5\n"
+	;;
+esac
+`)
+
+	collector := NewVirshCollector("")
+	collector.Perf = true
+
+	for i := 0; i < 2; i++ {
+		allStats, err := collector.GetVMStats(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("Expected perf to be skipped, got %v", err)
+		}
+		if len(allStats) != 1 || allStats[0].Perf.Collected {
+			t.Errorf("Expected 1 domain without perf stats, got %+v", allStats)
+		}
+	}
+
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("Failed to read calls: %v", err)
+	}
+	// Once with the other groups and once on its own
+	if n := strings.Count(string(log), "--perf"); n != 2 {
+		t.Errorf("Expected --perf to be tried twice, got %d attempts:\n%s", n, log)
+	}
+	var last string
+	for _, line := range strings.Split(string(log), "\n") {
+		if strings.HasPrefix(line, "domstats") {
+			last = line
+		}
+	}
+	if !strings.Contains(last, "--iothread") {
+		t.Errorf("Expected iothread stats to still be requested, got %q", last)
+	}
+}
+
+func TestVirshCollectorStatsGroupsKeptOnFailure(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeVirsh(t, `echo "$@" >> `+calls+`
+if [ ! -e `+calls+`.failed ]; then
+	touch `+calls+`.failed
+	echo "error: failed to connect to the hypervisor" >&2
+	exit 1
+fi
+printf "Domain: 'vm1'\n  state.state=5\n"
+`)
+
+	collector := NewVirshCollector("")
+	collector.Perf = true
+
+	if _, err := collector.GetVMStats(context.Background(), nil); err == nil {
+		t.Fatal("Expected the first refresh to fail")
+	}
+	allStats, err := collector.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(allStats) != 1 || !allStats[0].Perf.Collected {
+		t.Errorf("Expected perf stats to still be collected, got %+v", allStats)
+	}
+
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("Failed to read calls: %v", err)
+	}
+	if n := strings.Count(string(log), "domstats"); n != 2 {
+		t.Errorf("Expected no probing after a transient failure, got %d domstats calls:\n%s", n, log)
+	}
+}
+
+func TestVirshCollectorStatsGroupsKeptOnUnrelatedError(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeVirsh(t, `echo "$@" >> `+calls+`
+case "$*" in
+domstats*)
+	echo "error: unsupported configuration: disk 'vdb' has no source" >&2
+	exit 1
+	;;
+esac
+`)
+
+	collector := NewVirshCollector("")
+	for i := 0; i < 2; i++ {
+		if _, err := collector.GetVMStats(context.Background(), nil); err == nil {
+			t.Fatal("Expected the domstats error")
+		}
+	}
+
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("Failed to read calls: %v", err)
+	}
+	if n := strings.Count(string(log), "domstats"); n != 2 || strings.Count(string(log), "--iothread") != 2 {
+		t.Errorf("Expected iothread stats to stay enabled without probing, got:\n%s", log)
+	}
+}
+
+func TestVirshRejectsFlag(t *testing.T) {
+	tests := map[string]bool{
+		"error: command 'domstats' doesn't support option --dirtyrate":                            true,
+		"error: unsupported flags (0x200) in function qemuConnectGetAllDomainStats":               true,
+		"error: argument unsupported: Stats types bits 0x200 are not supported by this daemon":    true,
+		"error: invalid argument: flag VIR_DOMAIN_STATS_DIRTYRATE is not supported":               true,
+		"error: unsupported configuration: disk 'vdb' has no source":                              false,
+		"error: Operation not supported: this function is not supported by the connection driver": false,
+		"error: failed to connect to the hypervisor":                                              false,
+	}
+	for stderr, expected := range tests {
+		err := &exec.ExitError{Stderr: []byte(stderr + "\n")}
+		if got := virshRejectsFlag(err); got != expected {
+			t.Errorf("virshRejectsFlag(%q) = %v; expected %v", stderr, got, expected)
+		}
+	}
+}
+
+func TestVirshCollectorCommandTimeout(t *testing.T) {
+	fakeVirsh(t, `case "$1" in
+domstats)
+	printf "Domain: 'vm1'\n  state.state=1\nDomain: 'vm2'\n  state.state=5\n"
+	;;
+dominfo)
+	[ "$2" = "vm1" ] && exec sleep 10
+	printf "OS Type:        hvm\n"
+	;;
+esac
+`)
+
+	collector := NewVirshCollector("")
+	collector.CommandTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	allStats, err := collector.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the wedged dominfo to be cut off, refresh took %s", elapsed)
+	}
+
+	if len(allStats) != 2 {
+		t.Fatalf("Expected 2 stats entries, got %d", len(allStats))
+	}
+	if allStats[0].OSType != "" {
+		t.Errorf("Expected no OS type for timed out vm1, got '%s'", allStats[0].OSType)
+	}
+	if allStats[1].OSType != "hvm" {
+		t.Errorf("Expected OS type 'hvm' for vm2, got '%s'", allStats[1].OSType)
+	}
+}
+
+func TestVirshCollectorCancelled(t *testing.T) {
+	fakeVirsh(t, "exec sleep 10\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := NewVirshCollector("").GetVMStats(ctx, nil)
+	if err == nil {
+		t.Fatal("Expected an error for a cancelled collection")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestVirshCollectorCachesMetadata(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "dominfo-calls")
+	fakeVirsh(t, `case "$1" in
+domstats)
+	printf "Domain: 'vm1'\n  state.state=5\n"
+	;;
+dominfo)
+	echo x >> "`+calls+`"
+	printf "UUID:           u1\nOS Type:        hvm\nCPU(s):         2\n"
+	;;
+esac
+`)
+
+	collector := NewVirshCollector("")
+	for i := 0; i < 3; i++ {
+		allStats, err := collector.GetVMStats(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if allStats[0].OSType != "hvm" || allStats[0].Metadata.VCPUs != 2 {
+			t.Errorf("Expected hvm with 2 vCPUs, got %+v", allStats[0].Metadata)
+		}
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("Failed to read call log: %v", err)
+	}
+	if n := strings.Count(string(data), "x"); n != 1 {
+		t.Errorf("Expected dominfo to run once across refreshes, ran %d times", n)
+	}
+}
+
+func TestVirshCollectorIPSourceFallback(t *testing.T) {
+	fakeVirsh(t, `case "$1" in
+domstats)
+	printf "Domain: 'vm1'\n  state.state=1\n  net.count=1\n  net.0.name=vnet0\n"
+	;;
+domifaddr)
+	case "$5" in
+	lease) exit 1 ;;
+	agent)
+		printf " Name       MAC address          Protocol     Address\n"
+		printf "-------------------------------------------------------\n"
+		printf " lo         00:00:00:00:00:00    ipv4         127.0.0.1/8\n"
+		printf " eth0       52:54:00:12:34:56    ipv4         10.0.0.5/24\n"
+		;;
+	esac
+	;;
+dumpxml)
+	printf "<domain><devices><interface type='bridge'><mac address='52:54:00:12:34:56'/><target dev='vnet0'/></interface></devices></domain>\n"
+	;;
+esac
+`)
+
+	allStats, err := NewVirshCollector("").GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	iface := allStats[0].InterfaceStats[0]
+	expected := IPAddress{Address: "10.0.0.5", Prefix: 24, Family: "ipv4", Source: IPSourceAgent}
+	if len(iface.IPs) != 1 || iface.IPs[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, iface.IPs)
+	}
+}
+
+func TestVirshCollectorLeasesByMAC(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeVirsh(t, `echo "$@" >> `+calls+`
+case "$1" in
+domstats)
+	printf "Domain: 'vm1'\n  state.state=1\n  net.count=1\n  net.0.name=vnet0\n"
+	printf "Domain: 'vm2'\n  state.state=1\n  net.count=1\n  net.0.name=vnet1\n"
+	;;
+net-list)
+	printf "default\n"
+	;;
+net-dhcp-leases)
+	printf " Expiry Time           MAC address         Protocol   IP address           Hostname   Client ID or DUID\n"
+	printf "-----------------------------------------------------------------------------------------------------\n"
+	printf " 2024-05-01 12:30:00   52:54:00:12:34:56   ipv4       192.168.122.10/24    vm1        -\n"
+	printf " 2024-05-01 12:30:00   52:54:00:ab:cd:ef   ipv4       192.168.122.20/24    vm2        -\n"
+	;;
+dumpxml)
+	case "$2" in
+	vm1) printf "<domain><devices><interface type='network'><mac address='52:54:00:12:34:56'/><target dev='vnet0'/></interface></devices></domain>\n" ;;
+	vm2) printf "<domain><devices><interface type='network'><mac address='52:54:00:AB:CD:EF'/><target dev='vnet1'/></interface></devices></domain>\n" ;;
+	esac
+	;;
+esac
+`)
+
+	allStats, err := NewVirshCollector("").GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for i, expected := range []string{"192.168.122.10", "192.168.122.20"} {
+		ips := allStats[i].InterfaceStats[0].IPs
+		if len(ips) != 1 || ips[0].Address != expected || ips[0].Source != IPSourceLease {
+			t.Errorf("Expected %s to get lease %s, got %+v", allStats[i].DomainName, expected, ips)
+		}
+	}
+
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("Failed to read calls: %v", err)
+	}
+	if strings.Contains(string(log), "domifaddr") {
+		t.Errorf("Expected leases to be resolved without domifaddr, got calls:\n%s", log)
+	}
+	if n := strings.Count(string(log), "net-dhcp-leases"); n != 1 {
+		t.Errorf("Expected one lease listing for the network, got %d", n)
+	}
+}
+
+func TestVirshCollectorWatchEvents(t *testing.T) {
+	fakeVirsh(t, `case "$1" in
+event)
+	echo "event 'lifecycle' for domain 'vm1': Started Booted"
+	echo "event 'balloon-change' for domain 'vm1': 1048576KiB"
+	echo "event 'lifecycle' for domain 'vm1': Stopped Shutdown"
+	exec sleep 10
+	;;
+esac
+`)
+
+	collector := NewVirshCollector("")
+	collector.metadata.store("vm1", DomainMetadata{UUID: "cached"}, time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := collector.WatchEvents(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, want := range []string{"Started", "Stopped"} {
+		select {
+		case ev := <-events:
+			if ev.Domain != "vm1" || ev.Type != want || ev.Time.IsZero() {
+				t.Errorf("Expected a timestamped %s event for vm1, got %+v", want, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", want)
+		}
+	}
+	if _, ok := collector.metadata.lookup("vm1", time.Now(), time.Hour); ok {
+		t.Error("Expected the event to invalidate cached metadata")
+	}
+}
@@ -0,0 +1,211 @@
+package stats
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// domainXML is the subset of the libvirt domain XML that vmstats reads
+type domainXML struct {
+	VCPU struct {
+		Current int `xml:"current,attr"`
+		Max     int `xml:",chardata"`
+	} `xml:"vcpu"`
+	CPU struct {
+		Mode     string `xml:"mode,attr"`
+		Model    string `xml:"model"`
+		Topology struct {
+			Sockets int `xml:"sockets,attr"`
+			Dies    int `xml:"dies,attr"`
+			Cores   int `xml:"cores,attr"`
+			Threads int `xml:"threads,attr"`
+		} `xml:"topology"`
+	} `xml:"cpu"`
+	MemoryBacking struct {
+		HugePages *struct {
+			Pages []struct {
+				Size string `xml:"size,attr"`
+				Unit string `xml:"unit,attr"`
+			} `xml:"page"`
+		} `xml:"hugepages"`
+	} `xml:"memoryBacking"`
+	Devices struct {
+		Disks []struct {
+			Device string `xml:"device,attr"`
+			Driver struct {
+				Type     string `xml:"type,attr"`
+				Cache    string `xml:"cache,attr"`
+				IOThread int    `xml:"iothread,attr"`
+			} `xml:"driver"`
+			Source struct {
+				File   string `xml:"file,attr"`
+				Dev    string `xml:"dev,attr"`
+				Pool   string `xml:"pool,attr"`
+				Volume string `xml:"volume,attr"`
+				Name   string `xml:"name,attr"`
+			} `xml:"source"`
+			Target struct {
+				Dev string `xml:"dev,attr"`
+				Bus string `xml:"bus,attr"`
+			} `xml:"target"`
+		} `xml:"disk"`
+		Interfaces []struct {
+			Type string `xml:"type,attr"`
+			MAC  struct {
+				Address string `xml:"address,attr"`
+			} `xml:"mac"`
+			Source struct {
+				Bridge  string `xml:"bridge,attr"`
+				Network string `xml:"network,attr"`
+				Dev     string `xml:"dev,attr"`
+			} `xml:"source"`
+			Target struct {
+				Dev string `xml:"dev,attr"`
+			} `xml:"target"`
+			Model struct {
+				Type string `xml:"type,attr"`
+			} `xml:"model"`
+		} `xml:"interface"`
+		Graphics []struct {
+			Type     string `xml:"type,attr"`
+			Port     int    `xml:"port,attr"`
+			TLSPort  int    `xml:"tlsPort,attr"`
+			AutoPort string `xml:"autoport,attr"`
+			Listen   string `xml:"listen,attr"`
+		} `xml:"graphics"`
+	} `xml:"devices"`
+}
+
+// parseDomainXML parses a domain XML description into its configuration
+func parseDomainXML(xmlDesc string) (DomainConfig, error) {
+	var doc domainXML
+	if err := xml.Unmarshal([]byte(xmlDesc), &doc); err != nil {
+		return DomainConfig{}, fmt.Errorf("failed to parse domain XML: %w", err)
+	}
+
+	cfg := DomainConfig{
+		VCPUs:    doc.VCPU.Current,
+		MaxVCPUs: doc.VCPU.Max,
+		CPUMode:  doc.CPU.Mode,
+		CPUModel: doc.CPU.Model,
+		Sockets:  doc.CPU.Topology.Sockets,
+		Dies:     doc.CPU.Topology.Dies,
+		Cores:    doc.CPU.Topology.Cores,
+		Threads:  doc.CPU.Topology.Threads,
+	}
+	if cfg.VCPUs == 0 {
+		cfg.VCPUs = cfg.MaxVCPUs
+	}
+
+	if hp := doc.MemoryBacking.HugePages; hp != nil {
+		cfg.HugePages = true
+		if len(hp.Pages) > 0 {
+			cfg.HugePageSize = toKiB(hp.Pages[0].Size, hp.Pages[0].Unit)
+		}
+	}
+
+	for _, d := range doc.Devices.Disks {
+		source := d.Source.File
+		switch {
+		case d.Source.Dev != "":
+			source = d.Source.Dev
+		case d.Source.Pool != "":
+			source = d.Source.Pool + "/" + d.Source.Volume
+		case d.Source.Name != "":
+			source = d.Source.Name
+		}
+		cfg.Disks = append(cfg.Disks, DiskConfig{
+			Target:   d.Target.Dev,
+			Device:   d.Device,
+			Bus:      d.Target.Bus,
+			Format:   d.Driver.Type,
+			Cache:    d.Driver.Cache,
+			IOThread: d.Driver.IOThread,
+			Source:   source,
+		})
+	}
+
+	for _, i := range doc.Devices.Interfaces {
+		iface := InterfaceConfig{
+			Target:  i.Target.Dev,
+			Type:    i.Type,
+			MAC:     i.MAC.Address,
+			Model:   i.Model.Type,
+			Bridge:  i.Source.Bridge,
+			Network: i.Source.Network,
+		}
+		if i.Type == "direct" {
+			iface.Bridge = i.Source.Dev // macvtap parent device
+		}
+		cfg.Interfaces = append(cfg.Interfaces, iface)
+	}
+
+	for _, g := range doc.Devices.Graphics {
+		cfg.Graphics = append(cfg.Graphics, GraphicsConfig{
+			Type:     g.Type,
+			Port:     g.Port,
+			TLSPort:  g.TLSPort,
+			AutoPort: g.AutoPort == "yes",
+			Listen:   g.Listen,
+		})
+	}
+
+	return cfg, nil
+}
+
+// toKiB converts a libvirt scaled integer to KiB; libvirt's default unit
+// for page sizes is KiB
+func toKiB(value, unit string) int64 {
+	n, _ := strconv.ParseInt(value, 10, 64)
+	switch strings.ToLower(unit) {
+	case "b", "bytes":
+		return n / 1024
+	case "kb":
+		return n * 1000 / 1024
+	case "mb":
+		return n * 1000 * 1000 / 1024
+	case "m", "mib":
+		return n * 1024
+	case "gb":
+		return n * 1000 * 1000 * 1000 / 1024
+	case "g", "gib":
+		return n * 1024 * 1024
+	default: // "", "k", "kib"
+		return n
+	}
+}
+
+// applyDomainConfig attaches the configuration to a domain and joins the
+// per-device details onto its block and interface stats, matching disks by
+// target and interfaces by target or MAC
+func applyDomainConfig(cfg DomainConfig, vm *VMStats) {
+	vm.Config = cfg
+
+	for _, disk := range cfg.Disks {
+		for j := range vm.BlockStats {
+			b := &vm.BlockStats[j]
+			if b.Name != disk.Target {
+				continue
+			}
+			b.Bus = disk.Bus
+			b.Format = disk.Format
+			b.Cache = disk.Cache
+			b.IOThread = disk.IOThread
+		}
+	}
+
+	for _, iface := range cfg.Interfaces {
+		for j := range vm.InterfaceStats {
+			n := &vm.InterfaceStats[j]
+			if n.Name != iface.Target && (n.MAC == "" || !strings.EqualFold(n.MAC, iface.MAC)) {
+				continue
+			}
+			n.MAC = iface.MAC
+			n.Model = iface.Model
+			n.Bridge = iface.Bridge
+			n.Network = iface.Network
+		}
+	}
+}
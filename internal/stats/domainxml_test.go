@@ -0,0 +1,141 @@
+package stats
+
+import "testing"
+
+const testDomainXML = `<domain type='kvm' id='3'>
+  <name>db</name>
+  <vcpu placement='static' current='4'>8</vcpu>
+  <iothreads>2</iothreads>
+  <memoryBacking>
+    <hugepages>
+      <page size='1' unit='G'/>
+    </hugepages>
+  </memoryBacking>
+  <cpu mode='custom' match='exact' check='full'>
+    <model fallback='forbid'>Skylake-Server</model>
+    <topology sockets='1' dies='1' cores='4' threads='2'/>
+  </cpu>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2' cache='none' iothread='1'/>
+      <source file='/var/lib/libvirt/images/db.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <disk type='volume' device='disk'>
+      <driver name='qemu' type='raw' cache='writeback'/>
+      <source pool='fast' volume='db-data'/>
+      <target dev='vdb' bus='virtio'/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <driver name='qemu' type='raw'/>
+      <target dev='sda' bus='sata'/>
+    </disk>
+    <interface type='network'>
+      <mac address='52:54:00:12:34:56'/>
+      <source network='default' bridge='virbr0'/>
+      <target dev='vnet0'/>
+      <model type='virtio'/>
+    </interface>
+    <interface type='bridge'>
+      <mac address='52:54:00:ab:cd:ef'/>
+      <source bridge='br0'/>
+      <target dev='vnet1'/>
+      <model type='e1000e'/>
+    </interface>
+    <graphics type='vnc' port='5900' autoport='yes' listen='127.0.0.1'/>
+    <graphics type='spice' port='5901' tlsPort='5902' autoport='yes' listen='0.0.0.0'/>
+  </devices>
+</domain>`
+
+func TestParseDomainXML(t *testing.T) {
+	cfg, err := parseDomainXML(testDomainXML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.VCPUs != 4 || cfg.MaxVCPUs != 8 {
+		t.Errorf("Expected 4/8 vCPUs, got %d/%d", cfg.VCPUs, cfg.MaxVCPUs)
+	}
+	if cfg.CPUMode != "custom" || cfg.CPUModel != "Skylake-Server" {
+		t.Errorf("Expected custom Skylake-Server CPU, got %s %s", cfg.CPUMode, cfg.CPUModel)
+	}
+	if cfg.Sockets != 1 || cfg.Dies != 1 || cfg.Cores != 4 || cfg.Threads != 2 {
+		t.Errorf("Unexpected topology: %d/%d/%d/%d", cfg.Sockets, cfg.Dies, cfg.Cores, cfg.Threads)
+	}
+	if !cfg.HugePages || cfg.HugePageSize != 1024*1024 {
+		t.Errorf("Expected 1 GiB hugepages, got %v %d KiB", cfg.HugePages, cfg.HugePageSize)
+	}
+
+	expectedDisks := []DiskConfig{
+		{Target: "vda", Device: "disk", Bus: "virtio", Format: "qcow2", Cache: "none", IOThread: 1, Source: "/var/lib/libvirt/images/db.qcow2"},
+		{Target: "vdb", Device: "disk", Bus: "virtio", Format: "raw", Cache: "writeback", Source: "fast/db-data"},
+		{Target: "sda", Device: "cdrom", Bus: "sata", Format: "raw"},
+	}
+	if len(cfg.Disks) != len(expectedDisks) {
+		t.Fatalf("Expected %d disks, got %+v", len(expectedDisks), cfg.Disks)
+	}
+	for i := range expectedDisks {
+		if cfg.Disks[i] != expectedDisks[i] {
+			t.Errorf("Expected disk %+v, got %+v", expectedDisks[i], cfg.Disks[i])
+		}
+	}
+
+	expectedIfaces := []InterfaceConfig{
+		{Target: "vnet0", Type: "network", MAC: "52:54:00:12:34:56", Model: "virtio", Bridge: "virbr0", Network: "default"},
+		{Target: "vnet1", Type: "bridge", MAC: "52:54:00:ab:cd:ef", Model: "e1000e", Bridge: "br0"},
+	}
+	if len(cfg.Interfaces) != len(expectedIfaces) {
+		t.Fatalf("Expected %d interfaces, got %+v", len(expectedIfaces), cfg.Interfaces)
+	}
+	for i := range expectedIfaces {
+		if cfg.Interfaces[i] != expectedIfaces[i] {
+			t.Errorf("Expected interface %+v, got %+v", expectedIfaces[i], cfg.Interfaces[i])
+		}
+	}
+
+	expectedGraphics := []GraphicsConfig{
+		{Type: "vnc", Port: 5900, AutoPort: true, Listen: "127.0.0.1"},
+		{Type: "spice", Port: 5901, TLSPort: 5902, AutoPort: true, Listen: "0.0.0.0"},
+	}
+	if len(cfg.Graphics) != len(expectedGraphics) {
+		t.Fatalf("Expected %d graphics, got %+v", len(expectedGraphics), cfg.Graphics)
+	}
+	for i := range expectedGraphics {
+		if cfg.Graphics[i] != expectedGraphics[i] {
+			t.Errorf("Expected graphics %+v, got %+v", expectedGraphics[i], cfg.Graphics[i])
+		}
+	}
+
+	if _, err := parseDomainXML("<domain>"); err == nil {
+		t.Error("Expected an error for malformed XML")
+	}
+}
+
+func TestApplyDomainConfig(t *testing.T) {
+	cfg, err := parseDomainXML(testDomainXML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	vm := VMStats{
+		BlockStats:     []BlockStats{{Name: "vda"}, {Name: "vdb"}},
+		InterfaceStats: []InterfaceStats{{Name: "vnet1"}, {Name: "vnet0"}},
+	}
+	applyDomainConfig(cfg, &vm)
+
+	if vm.Config.CPUModel != "Skylake-Server" {
+		t.Errorf("Expected config to be attached, got %+v", vm.Config)
+	}
+	if vda := vm.BlockStats[0]; vda.Bus != "virtio" || vda.Format != "qcow2" || vda.Cache != "none" || vda.IOThread != 1 {
+		t.Errorf("Unexpected vda config: %+v", vda)
+	}
+	if vdb := vm.BlockStats[1]; vdb.Format != "raw" || vdb.IOThread != 0 {
+		t.Errorf("Expected vdb raw on the main loop, got %+v", vdb)
+	}
+	if vnet1 := vm.InterfaceStats[0]; vnet1.MAC != "52:54:00:ab:cd:ef" || vnet1.Model != "e1000e" || vnet1.Bridge != "br0" {
+		t.Errorf("Unexpected vnet1 config: %+v", vnet1)
+	}
+	if vnet0 := vm.InterfaceStats[1]; vnet0.Network != "default" || vnet0.Model != "virtio" {
+		t.Errorf("Unexpected vnet0 config: %+v", vnet0)
+	}
+}
@@ -0,0 +1,142 @@
+package stats
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// EventSource is implemented by collectors that can stream domain events as
+// they happen, so a refresh does not have to wait for the next tick
+type EventSource interface {
+	// WatchEvents streams events until ctx is cancelled, reconnecting
+	// when the underlying watch ends. The channel is closed once ctx is
+	// done.
+	WatchEvents(ctx context.Context) (<-chan DomainEvent, error)
+}
+
+// DomainEvent is a domain lifecycle or device event
+type DomainEvent struct {
+	Time   time.Time
+	Host   string
+	Domain string
+	// Type is the event as virsh names it: Started, Stopped, Crashed,
+	// Suspended, Resumed, Shutdown, Defined, Undefined, PMSuspended,
+	// Reboot, Device added or Device removed
+	Type   string
+	Detail string
+}
+
+// Lifecycle events and their details by number (virDomainEventType and the
+// per-event detail enums), named as virsh prints them
+var lifecycleEvents = []struct {
+	name    string
+	details []string
+}{
+	{"Defined", []string{"Added", "Updated", "Renamed", "Snapshot"}},
+	{"Undefined", []string{"Removed", "Renamed"}},
+	{"Started", []string{"Booted", "Migrated", "Restored", "Snapshot", "Event wakeup", "Recreated"}},
+	{"Suspended", []string{"Paused", "Migrated", "I/O Error", "Watchdog", "Restored", "Snapshot", "API error", "Post-copy", "Post-copy Error"}},
+	{"Resumed", []string{"Unpaused", "Migrated", "Snapshot", "Post-copy", "Post-copy Error"}},
+	{"Stopped", []string{"Shutdown", "Destroyed", "Crashed", "Migrated", "Saved", "Failed", "Snapshot", "Daemon"}},
+	{"Shutdown", []string{"Finished", "Finished after guest request", "Finished after host request"}},
+	{"PMSuspended", []string{"Memory", "Disk"}},
+	{"Crashed", []string{"Panicked", "Crashloaded"}},
+}
+
+// lifecycleEvent names a lifecycle event received over the remote protocol
+func lifecycleEvent(event, detail int32) (string, string) {
+	if event < 0 || int(event) >= len(lifecycleEvents) {
+		return "Unknown", ""
+	}
+	e := lifecycleEvents[event]
+	if detail < 0 || int(detail) >= len(e.details) {
+		return e.name, ""
+	}
+	return e.name, e.details[detail]
+}
+
+// Event kinds from `virsh event --all` that change what vmstats shows
+var watchedEvents = map[string]string{
+	"lifecycle":      "",
+	"reboot":         "Reboot",
+	"device-added":   "Device added",
+	"device-removed": "Device removed",
+}
+
+// parseEventLine parses one line of `virsh event --loop --all` output, such
+// as "event 'lifecycle' for domain 'vm1': Stopped Destroyed". Events that
+// do not affect the display are skipped.
+func parseEventLine(line string) (DomainEvent, bool) {
+	_, rest, ok := strings.Cut(line, "event '")
+	if !ok {
+		return DomainEvent{}, false
+	}
+	kind, rest, ok := strings.Cut(rest, "' for domain '")
+	if !ok {
+		return DomainEvent{}, false
+	}
+	name, ok := watchedEvents[kind]
+	if !ok {
+		return DomainEvent{}, false
+	}
+
+	// Domain names may contain quotes, so split on the last one
+	idx := strings.LastIndex(rest, "'")
+	if idx == -1 {
+		return DomainEvent{}, false
+	}
+	ev := DomainEvent{Domain: rest[:idx], Type: name}
+	detail := strings.TrimSpace(strings.TrimPrefix(rest[idx+1:], ":"))
+
+	if kind == "lifecycle" {
+		ev.Type, ev.Detail, _ = strings.Cut(detail, " ")
+	} else {
+		ev.Detail = detail
+	}
+	return ev, ev.Type != ""
+}
+
+// Backoff between attempts to re-establish an event watch
+const (
+	eventRetryMin = time.Second
+	eventRetryMax = time.Minute
+)
+
+// watchLoop runs stream until ctx is cancelled, restarting it with backoff
+// whenever it ends, and forwards its events on the returned channel
+func watchLoop(ctx context.Context, stream func(ctx context.Context, events chan<- DomainEvent) error) <-chan DomainEvent {
+	events := make(chan DomainEvent)
+	go func() {
+		defer close(events)
+		backoff := eventRetryMin
+		for ctx.Err() == nil {
+			started := time.Now()
+			_ = stream(ctx, events)
+
+			// A watch that ran for a while was healthy; start over
+			if time.Since(started) > eventRetryMax {
+				backoff = eventRetryMin
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, eventRetryMax)
+		}
+	}()
+	return events
+}
+
+// emit stamps an event and hands it to the consumer, giving up if ctx is
+// cancelled first
+func emit(ctx context.Context, events chan<- DomainEvent, ev DomainEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case events <- ev:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,45 @@
+package stats
+
+import "testing"
+
+func TestParseEventLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected DomainEvent
+		ok       bool
+	}{
+		{"event 'lifecycle' for domain 'vm1': Started Booted", DomainEvent{Domain: "vm1", Type: "Started", Detail: "Booted"}, true},
+		{"event 'lifecycle' for domain 'vm1': Stopped Destroyed", DomainEvent{Domain: "vm1", Type: "Stopped", Detail: "Destroyed"}, true},
+		{"event 'lifecycle' for domain 'it's': Suspended I/O Error", DomainEvent{Domain: "it's", Type: "Suspended", Detail: "I/O Error"}, true},
+		{"2024-05-01 12:30:00.123+0000: event 'reboot' for domain 'vm2'", DomainEvent{Domain: "vm2", Type: "Reboot"}, true},
+		{"event 'device-removed' for domain 'vm2': virtio-disk1", DomainEvent{Domain: "vm2", Type: "Device removed", Detail: "virtio-disk1"}, true},
+		{"event 'balloon-change' for domain 'vm2': 1048576KiB", DomainEvent{}, false},
+		{"events received: 3", DomainEvent{}, false},
+		{"", DomainEvent{}, false},
+	}
+	for _, tt := range tests {
+		ev, ok := parseEventLine(tt.line)
+		if ok != tt.ok || ev != tt.expected {
+			t.Errorf("parseEventLine(%q) = %+v, %v; expected %+v, %v", tt.line, ev, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestLifecycleEvent(t *testing.T) {
+	tests := []struct {
+		event, detail  int32
+		name, expected string
+	}{
+		{2, 0, "Started", "Booted"},
+		{5, 2, "Stopped", "Crashed"},
+		{8, 0, "Crashed", "Panicked"},
+		{5, 99, "Stopped", ""},
+		{42, 0, "Unknown", ""},
+	}
+	for _, tt := range tests {
+		name, detail := lifecycleEvent(tt.event, tt.detail)
+		if name != tt.name || detail != tt.expected {
+			t.Errorf("lifecycleEvent(%d, %d) = %q %q, expected %q %q", tt.event, tt.detail, name, detail, tt.name, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package stats
+
+// DefaultHistoryLength is how many samples are kept per metric in memory
+const DefaultHistoryLength = 120
+
+// Metrics kept in history for each domain. Disk and network metrics are
+// kept per device.
+const (
+	MetricCPU       = "cpu"        // Domain CPU usage, % of host
+	MetricMemory    = "memory"     // Memory in use, %
+	MetricDiskRead  = "disk.read"  // Bytes/s
+	MetricDiskWrite = "disk.write" // Bytes/s
+	MetricNetRx     = "net.rx"     // Bytes/s
+	MetricNetTx     = "net.tx"     // Bytes/s
+)
+
+// SeriesKey identifies one metric of a domain, or of one of its devices
+type SeriesKey struct {
+	Host   string
+	Domain string
+	Metric string
+	Device string // Disk or interface name, empty for domain metrics
+}
+
+// Point is one sample of a series
+type Point struct {
+	Time  int64 // Unix nanoseconds
+	Value float64
+}
+
+// Ring holds the latest points of a series, dropping the oldest once full
+type Ring struct {
+	points []Point
+	start  int
+	n      int
+}
+
+// NewRing creates a ring that keeps size points
+func NewRing(size int) *Ring {
+	return &Ring{points: make([]Point, max(size, 1))}
+}
+
+// Add appends a point, overwriting the oldest when the ring is full
+func (r *Ring) Add(p Point) {
+	if r.n < len(r.points) {
+		r.points[(r.start+r.n)%len(r.points)] = p
+		r.n++
+		return
+	}
+	r.points[r.start] = p
+	r.start = (r.start + 1) % len(r.points)
+}
+
+// Len returns the number of points held
+func (r *Ring) Len() int {
+	return r.n
+}
+
+// Points returns a copy of the points, oldest first
+func (r *Ring) Points() []Point {
+	out := make([]Point, r.n)
+	for i := range out {
+		out[i] = r.points[(r.start+i)%len(r.points)]
+	}
+	return out
+}
+
+// History keeps the recent samples of every domain's metrics in memory.
+// It is not safe for concurrent use.
+type History struct {
+	length int
+	series map[SeriesKey]*Ring
+	// last is the LastUpdate of each domain's latest recorded sample; rates
+	// are only recorded for a domain already seen in the previous sample
+	last map[SeriesKey]int64
+}
+
+// NewHistory creates a history keeping length samples per series
+func NewHistory(length int) *History {
+	return &History{
+		length: max(length, 1),
+		series: make(map[SeriesKey]*Ring),
+		last:   make(map[SeriesKey]int64),
+	}
+}
+
+// Length returns how many samples are kept per series
+func (h *History) Length() int {
+	return h.length
+}
+
+// Add appends a point to a series
+func (h *History) Add(key SeriesKey, p Point) {
+	r, ok := h.series[key]
+	if !ok {
+		r = NewRing(h.length)
+		h.series[key] = r
+	}
+	r.Add(p)
+}
+
+// Points returns a series' points, oldest first
+func (h *History) Points(key SeriesKey) []Point {
+	if r, ok := h.series[key]; ok {
+		return r.Points()
+	}
+	return nil
+}
+
+// Record adds a host's latest sample, after CalculateRates, and returns the
+// points it added. Inactive domains, repeated samples and rates that could
+// not be derived (a domain's first sample, a restart, a counter reset) are
+// left out. Domains no longer reported by the host are forgotten.
+func (h *History) Record(host string, vms []VMStats) []SeriesPoint {
+	var added []SeriesPoint
+	present := make(map[SeriesKey]bool, len(vms))
+	for i := range vms {
+		vm := &vms[i]
+		domain := SeriesKey{Host: host, Domain: vm.DomainName}
+		present[domain] = true
+
+		if !domainActive(vm.State) {
+			delete(h.last, domain)
+			continue
+		}
+		last, seen := h.last[domain]
+		if seen && last == vm.LastUpdate {
+			continue
+		}
+		h.last[domain] = vm.LastUpdate
+
+		add := func(metric, device string, value float64) {
+			p := SeriesPoint{
+				SeriesKey: SeriesKey{Host: host, Domain: vm.DomainName, Metric: metric, Device: device},
+				Point:     Point{Time: vm.LastUpdate, Value: value},
+			}
+			h.Add(p.SeriesKey, p.Point)
+			added = append(added, p)
+		}
+		if usage, ok := vm.MemoryUsage(); ok {
+			add(MetricMemory, "", usage)
+		}
+		if !seen || vm.Restarted {
+			continue
+		}
+
+		add(MetricCPU, "", vm.CPU.Usage)
+		for _, disk := range vm.BlockStats {
+			if !disk.Reset {
+				add(MetricDiskRead, disk.Name, disk.ReadBytesRate)
+				add(MetricDiskWrite, disk.Name, disk.WriteBytesRate)
+			}
+		}
+		for _, iface := range vm.InterfaceStats {
+			if !iface.Reset {
+				add(MetricNetRx, iface.Name, iface.RxBytesRate)
+				add(MetricNetTx, iface.Name, iface.TxBytesRate)
+			}
+		}
+	}
+
+	for key := range h.series {
+		if key.Host == host && !present[SeriesKey{Host: host, Domain: key.Domain}] {
+			delete(h.series, key)
+		}
+	}
+	for key := range h.last {
+		if key.Host == host && !present[key] {
+			delete(h.last, key)
+		}
+	}
+	return added
+}
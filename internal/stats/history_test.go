@@ -0,0 +1,94 @@
+package stats
+
+import "testing"
+
+func TestRing(t *testing.T) {
+	r := NewRing(3)
+	if r.Len() != 0 || len(r.Points()) != 0 {
+		t.Fatalf("expected an empty ring, got %+v", r.Points())
+	}
+	for i := 1; i <= 5; i++ {
+		r.Add(Point{Time: int64(i), Value: float64(i * 10)})
+	}
+
+	points := r.Points()
+	expected := []Point{{3, 30}, {4, 40}, {5, 50}}
+	if r.Len() != 3 || len(points) != len(expected) {
+		t.Fatalf("expected the 3 newest points, got %+v", points)
+	}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Errorf("point %d = %+v; expected %+v", i, points[i], expected[i])
+		}
+	}
+}
+
+func TestHistoryRecord(t *testing.T) {
+	h := NewHistory(10)
+	sample := func(at int64, usage float64) []VMStats {
+		return []VMStats{{
+			DomainName:     "vm1",
+			State:          1,
+			LastUpdate:     at,
+			CPU:            CPUStats{Usage: usage},
+			BalloonStats:   BalloonStats{Current: 1000, Unused: 600, DiskCaches: 150, LastUpdate: 1},
+			BlockStats:     []BlockStats{{Name: "vda", ReadBytesRate: usage * 100}},
+			InterfaceStats: []InterfaceStats{{Name: "vnet0", TxBytesRate: usage}},
+		}, {
+			DomainName: "off", State: 5, LastUpdate: at,
+		}}
+	}
+	cpu := SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}
+	memory := SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricMemory}
+	disk := SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricDiskRead, Device: "vda"}
+	net := SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricNetTx, Device: "vnet0"}
+
+	// The first sample has no rates yet
+	h.Record("h1", sample(1, 0))
+	if len(h.Points(cpu)) != 0 {
+		t.Errorf("expected no CPU point from the first sample, got %+v", h.Points(cpu))
+	}
+	if points := h.Points(memory); len(points) != 1 || points[0].Value != 25 {
+		t.Errorf("expected 25%% memory in use, got %+v", points)
+	}
+
+	h.Record("h1", sample(2, 40))
+	h.Record("h1", sample(2, 40)) // The same sample polled again
+	h.Record("h1", sample(3, 50))
+	if points := h.Points(cpu); len(points) != 2 || points[0] != (Point{2, 40}) || points[1] != (Point{3, 50}) {
+		t.Errorf("unexpected CPU history %+v", points)
+	}
+	if points := h.Points(disk); len(points) != 2 || points[1].Value != 5000 {
+		t.Errorf("unexpected disk history %+v", points)
+	}
+	if points := h.Points(net); len(points) != 2 || points[1].Value != 50 {
+		t.Errorf("unexpected network history %+v", points)
+	}
+	if points := h.Points(SeriesKey{Host: "h1", Domain: "off", Metric: MetricCPU}); len(points) != 0 {
+		t.Errorf("expected no history for a shut off domain, got %+v", points)
+	}
+
+	// Restarts and resets leave gaps rather than bogus zeros
+	restarted := sample(4, 0)
+	restarted[0].Restarted = true
+	h.Record("h1", restarted)
+	reset := sample(5, 60)
+	reset[0].BlockStats[0].Reset = true
+	h.Record("h1", reset)
+	if points := h.Points(cpu); len(points) != 3 || points[2] != (Point{5, 60}) {
+		t.Errorf("expected the restart to be skipped, got %+v", points)
+	}
+	if points := h.Points(disk); len(points) != 2 {
+		t.Errorf("expected the reset disk to be skipped, got %+v", points)
+	}
+
+	// Other hosts are left alone; domains gone from a host are forgotten
+	h.Record("h2", []VMStats{{DomainName: "vm1", State: 1, LastUpdate: 5}})
+	if len(h.Points(cpu)) != 3 {
+		t.Error("expected another host's sample not to touch h1")
+	}
+	h.Record("h1", nil)
+	if len(h.Points(cpu)) != 0 || len(h.Points(memory)) != 0 {
+		t.Error("expected the history of a vanished domain to be dropped")
+	}
+}
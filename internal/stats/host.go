@@ -0,0 +1,175 @@
+package stats
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NodeInfo describes the hypervisor's hardware
+type NodeInfo struct {
+	CPUModel  string
+	Memory    int64 // KiB
+	CPUs      int
+	MHz       int
+	NUMANodes int
+	Sockets   int // per NUMA node
+	Cores     int // per socket
+	Threads   int // per core
+}
+
+// HostCollector is implemented by collectors that can also report the
+// hypervisor's own CPU and memory usage
+type HostCollector interface {
+	GetHostStats(ctx context.Context) (HostStats, error)
+}
+
+// HostStats is a snapshot of the hypervisor's CPU and memory
+type HostStats struct {
+	Node       NodeInfo
+	CPU        HostCPUStats
+	Memory     HostMemoryStats
+	LastUpdate int64 // Unix nano
+	// CPUUsage is the % of host CPU time spent busy between two
+	// snapshots, across all CPUs
+	CPUUsage float64
+}
+
+// HostCPUStats are CPU times summed over all host CPUs, in nanoseconds
+type HostCPUStats struct {
+	Kernel int64
+	User   int64
+	Idle   int64
+	IOWait int64
+}
+
+// Busy returns the time spent running code
+func (s HostCPUStats) Busy() int64 {
+	return s.Kernel + s.User
+}
+
+// Total returns the time accounted for, busy or not
+func (s HostCPUStats) Total() int64 {
+	return s.Kernel + s.User + s.Idle + s.IOWait
+}
+
+// HostMemoryStats is the host's memory usage in KiB
+type HostMemoryStats struct {
+	Total   int64
+	Free    int64
+	Buffers int64
+	Cached  int64
+}
+
+// Available returns the memory that can be handed out without swapping:
+// free memory plus reclaimable buffers and page cache
+func (s HostMemoryStats) Available() int64 {
+	return s.Free + s.Buffers + s.Cached
+}
+
+// applyHostCPUStat sets a host CPU time by its libvirt field name
+func applyHostCPUStat(field string, value int64, s *HostCPUStats) {
+	switch field {
+	case "kernel", "system": // virsh prints the kernel time as "system"
+		s.Kernel = value
+	case "user":
+		s.User = value
+	case "idle":
+		s.Idle = value
+	case "iowait":
+		s.IOWait = value
+	}
+}
+
+// applyHostMemoryStat sets a host memory figure by its libvirt field name
+func applyHostMemoryStat(field string, value int64, s *HostMemoryStats) {
+	switch field {
+	case "total":
+		s.Total = value
+	case "free":
+		s.Free = value
+	case "buffers":
+		s.Buffers = value
+	case "cached":
+		s.Cached = value
+	}
+}
+
+// parseNodeStats parses the "field: value" lines of `virsh nodecpustats`
+// and `virsh nodememstats`, calling apply for each
+func parseNodeStats(output string, apply func(field string, value int64)) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		apply(strings.TrimSpace(key), n)
+	}
+}
+
+// parseNodeInfo parses `virsh nodeinfo` output
+func parseNodeInfo(output string) NodeInfo {
+	var info NodeInfo
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		var n int
+		if fields := strings.Fields(value); len(fields) > 0 {
+			n, _ = strconv.Atoi(fields[0])
+		}
+
+		switch strings.TrimSpace(key) {
+		case "CPU model":
+			info.CPUModel = value
+		case "CPU(s)":
+			info.CPUs = n
+		case "CPU frequency":
+			info.MHz = n // "2400 MHz"
+		case "CPU socket(s)":
+			info.Sockets = n
+		case "Core(s) per socket":
+			info.Cores = n
+		case "Thread(s) per core":
+			info.Threads = n
+		case "NUMA cell(s)":
+			info.NUMANodes = n
+		case "Memory size":
+			info.Memory = int64(n) // "16314568 KiB"
+		}
+	}
+	return info
+}
+
+// nodeInfoCache holds the node info once it has been read successfully;
+// hardware does not change while we are connected
+type nodeInfoCache struct {
+	mu   sync.Mutex
+	info *NodeInfo
+}
+
+// get returns the cached node info, calling fetch until it succeeds once
+func (n *nodeInfoCache) get(fetch func() (NodeInfo, error)) (NodeInfo, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.info != nil {
+		return *n.info, nil
+	}
+	info, err := fetch()
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	n.info = &info
+	return info, nil
+}
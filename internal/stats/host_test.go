@@ -0,0 +1,128 @@
+package stats
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseNodeInfo(t *testing.T) {
+	output := `CPU model:           x86_64
+CPU(s):              16
+CPU frequency:       2400 MHz
+CPU socket(s):       1
+Core(s) per socket:  8
+Thread(s) per core:  2
+NUMA cell(s):        1
+Memory size:         65536000 KiB
+`
+
+	info := parseNodeInfo(output)
+	expected := NodeInfo{
+		CPUModel:  "x86_64",
+		Memory:    65536000,
+		CPUs:      16,
+		MHz:       2400,
+		NUMANodes: 1,
+		Sockets:   1,
+		Cores:     8,
+		Threads:   2,
+	}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+
+	// Some hosts leave fields blank
+	info = parseNodeInfo("CPU model:\nCPU(s):              4\nCPU frequency:\n")
+	if info != (NodeInfo{CPUs: 4}) {
+		t.Errorf("Expected only the CPU count, got %+v", info)
+	}
+}
+
+func TestDecodeNodeInfo(t *testing.T) {
+	var e xdrEncoder
+	model := "x86_64"
+	for i := 0; i < 32; i++ {
+		ch := int32(0)
+		if i < len(model) {
+			ch = int32(model[i])
+		}
+		e.int32(ch)
+	}
+	e.uint64(65536000)
+	for _, v := range []int32{16, 2400, 1, 1, 8, 2} {
+		e.int32(v)
+	}
+
+	info, err := decodeNodeInfo(e.buf.Bytes())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.CPUModel != "x86_64" || info.CPUs != 16 || info.Memory != 65536000 || info.Threads != 2 {
+		t.Errorf("Unexpected node info: %+v", info)
+	}
+
+	if _, err := decodeNodeInfo(e.buf.Bytes()[:40]); err == nil {
+		t.Error("Expected an error for a truncated reply")
+	}
+}
+
+func TestNodeInfoCacheRetries(t *testing.T) {
+	var cache nodeInfoCache
+	calls := 0
+	fetch := func() (NodeInfo, error) {
+		calls++
+		if calls == 1 {
+			return NodeInfo{}, errors.New("connection refused")
+		}
+		return NodeInfo{CPUs: 8}, nil
+	}
+
+	if _, err := cache.get(fetch); err == nil {
+		t.Error("Expected the first fetch error to be returned")
+	}
+	for i := 0; i < 2; i++ {
+		if info, err := cache.get(fetch); err != nil || info.CPUs != 8 {
+			t.Errorf("Expected 8 CPUs, got %+v (err=%v)", info, err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected a failed fetch to be retried once and then cached, got %d calls", calls)
+	}
+}
+
+func TestParseNodeStats(t *testing.T) {
+	cpuOutput := `user:                  2289030000000
+system:                 745070000000
+idle:                 27966330000000
+iowait:                  12590000000
+`
+	memOutput := `total  :             16314568 KiB
+free   :              4507116 KiB
+buffers:               291236 KiB
+cached :              7183468 KiB
+`
+
+	var host HostStats
+	parseNodeStats(cpuOutput, func(field string, value int64) {
+		applyHostCPUStat(field, value, &host.CPU)
+	})
+	parseNodeStats(memOutput, func(field string, value int64) {
+		applyHostMemoryStat(field, value, &host.Memory)
+	})
+
+	expectedCPU := HostCPUStats{Kernel: 745070000000, User: 2289030000000, Idle: 27966330000000, IOWait: 12590000000}
+	if host.CPU != expectedCPU {
+		t.Errorf("Expected CPU %+v, got %+v", expectedCPU, host.CPU)
+	}
+	if host.CPU.Busy() != 3034100000000 || host.CPU.Total() != 31013020000000 {
+		t.Errorf("Unexpected busy/total: %d/%d", host.CPU.Busy(), host.CPU.Total())
+	}
+
+	expectedMem := HostMemoryStats{Total: 16314568, Free: 4507116, Buffers: 291236, Cached: 7183468}
+	if host.Memory != expectedMem {
+		t.Errorf("Expected memory %+v, got %+v", expectedMem, host.Memory)
+	}
+	if host.Memory.Available() != 11981820 {
+		t.Errorf("Expected 11981820 KiB available, got %d", host.Memory.Available())
+	}
+}
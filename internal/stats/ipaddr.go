@@ -0,0 +1,105 @@
+package stats
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IP address sources understood by libvirt's domifaddr
+const (
+	IPSourceLease = "lease"
+	IPSourceAgent = "agent"
+	IPSourceARP   = "arp"
+)
+
+// DefaultIPSources is the order in which address sources are tried
+var DefaultIPSources = []string{IPSourceLease, IPSourceAgent, IPSourceARP}
+
+// ParseIPSources parses a comma-separated, ordered list of IP sources
+func ParseIPSources(s string) ([]string, error) {
+	var sources []string
+	for _, source := range strings.Split(s, ",") {
+		source = strings.TrimSpace(source)
+		switch source {
+		case IPSourceLease, IPSourceAgent, IPSourceARP:
+			sources = append(sources, source)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown IP source %q (expected lease, agent or arp)", source)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no IP sources given")
+	}
+	return sources, nil
+}
+
+// discoveredAddr is an address reported for an interface before it has been
+// matched to one of the domain's InterfaceStats
+type discoveredAddr struct {
+	ifName string
+	mac    string
+	addr   IPAddress
+}
+
+// parseDomIfAddr parses `virsh domifaddr --full` output
+func parseDomIfAddr(output, source string) []discoveredAddr {
+	var addrs []discoveredAddr
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "Name") || strings.HasPrefix(line, "-") {
+			continue
+		}
+
+		// Expected format: interface MAC protocol address
+		// vnet0 52:54:00:12:34:56 ipv4 192.168.122.238/24
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+
+		address, prefix := fields[3], 0
+		if idx := strings.Index(address, "/"); idx != -1 {
+			prefix, _ = strconv.Atoi(address[idx+1:])
+			address = address[:idx]
+		}
+
+		addrs = append(addrs, discoveredAddr{
+			ifName: fields[0],
+			mac:    fields[1],
+			addr: IPAddress{
+				Address: address,
+				Prefix:  prefix,
+				Family:  fields[2],
+				Source:  source,
+			},
+		})
+	}
+	return addrs
+}
+
+// assignAddresses attaches discovered addresses to the domain's interfaces,
+// matching by host-side interface name first and MAC second (the guest agent
+// reports guest-side names). Loopback addresses are dropped. It returns how
+// many addresses were assigned.
+func assignAddresses(vm *VMStats, addrs []discoveredAddr) int {
+	assigned := 0
+	for _, a := range addrs {
+		if ip := net.ParseIP(a.addr.Address); ip != nil && ip.IsLoopback() {
+			continue
+		}
+
+		for j := range vm.InterfaceStats {
+			iface := &vm.InterfaceStats[j]
+			if iface.Name == a.ifName || (iface.MAC != "" && strings.EqualFold(iface.MAC, a.mac)) {
+				iface.IPs = append(iface.IPs, a.addr)
+				assigned++
+				break
+			}
+		}
+	}
+	return assigned
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"testing"
+)
+
+func TestParseDomIfAddr(t *testing.T) {
+	output := ` Name       MAC address          Protocol     Address
+-------------------------------------------------------------------------------
+ lo         00:00:00:00:00:00    ipv4         127.0.0.1/8
+ enp1s0     52:54:00:12:34:56    ipv4         192.168.122.238/24
+ enp1s0     52:54:00:12:34:56    ipv6         fe80::5054:ff:fe12:3456/64
+`
+
+	addrs := parseDomIfAddr(output, IPSourceAgent)
+	if len(addrs) != 3 {
+		t.Fatalf("Expected 3 addresses, got %d", len(addrs))
+	}
+
+	v6 := addrs[2]
+	if v6.ifName != "enp1s0" || v6.mac != "52:54:00:12:34:56" {
+		t.Errorf("Expected enp1s0 52:54:00:12:34:56, got %s %s", v6.ifName, v6.mac)
+	}
+	expected := IPAddress{Address: "fe80::5054:ff:fe12:3456", Prefix: 64, Family: "ipv6", Source: IPSourceAgent}
+	if v6.addr != expected {
+		t.Errorf("Expected %+v, got %+v", expected, v6.addr)
+	}
+}
+
+func TestAssignAddresses(t *testing.T) {
+	vm := VMStats{InterfaceStats: []InterfaceStats{
+		{Name: "vnet0"},
+		{Name: "vnet1", MAC: "52:54:00:aa:bb:cc"},
+	}}
+
+	assigned := assignAddresses(&vm, []discoveredAddr{
+		{ifName: "vnet0", addr: IPAddress{Address: "192.168.122.10"}},
+		{ifName: "eth1", mac: "52:54:00:AA:BB:CC", addr: IPAddress{Address: "10.0.0.7"}},
+		{ifName: "lo", addr: IPAddress{Address: "127.0.0.1"}},
+		{ifName: "lo", addr: IPAddress{Address: "::1"}},
+		{ifName: "eth9", mac: "52:54:00:00:00:09", addr: IPAddress{Address: "10.9.9.9"}},
+	})
+
+	if assigned != 2 {
+		t.Errorf("Expected 2 assigned addresses, got %d", assigned)
+	}
+	if ips := vm.InterfaceStats[0].IPs; len(ips) != 1 || ips[0].Address != "192.168.122.10" {
+		t.Errorf("Expected vnet0 to match by name, got %+v", ips)
+	}
+	if ips := vm.InterfaceStats[1].IPs; len(ips) != 1 || ips[0].Address != "10.0.0.7" {
+		t.Errorf("Expected vnet1 to match by MAC, got %+v", ips)
+	}
+}
+
+func TestParseIPSources(t *testing.T) {
+	sources, err := ParseIPSources("agent, lease")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sources) != 2 || sources[0] != IPSourceAgent || sources[1] != IPSourceLease {
+		t.Errorf("Expected [agent lease], got %v", sources)
+	}
+
+	if _, err := ParseIPSources("lease,dhcp"); err == nil {
+		t.Error("Expected an error for an unknown source")
+	}
+	if _, err := ParseIPSources(""); err == nil {
+		t.Error("Expected an error for an empty list")
+	}
+}
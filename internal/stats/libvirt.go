@@ -0,0 +1,960 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLibvirtSocket is the libvirtd socket for the system connection
+const DefaultLibvirtSocket = "/var/run/libvirt/libvirt-sock"
+
+// Stats groups requested from connectGetAllDomainStats (virDomainStatsTypes)
+const (
+	domainStatsState     = 1 << 0
+	domainStatsCPUTotal  = 1 << 1
+	domainStatsBalloon   = 1 << 2
+	domainStatsVCPU      = 1 << 3
+	domainStatsInterface = 1 << 4
+	domainStatsBlock     = 1 << 5
+	domainStatsPerf      = 1 << 6
+	domainStatsIOThread  = 1 << 7
+	domainStatsMemory    = 1 << 8
+	domainStatsDirtyRate = 1 << 9
+)
+
+// Address sources for domainInterfaceAddresses
+// (virDomainInterfaceAddressesSource)
+var interfaceAddressesSources = map[string]uint32{
+	IPSourceLease: 0,
+	IPSourceAgent: 1,
+	IPSourceARP:   2,
+}
+
+// Address types in remote_domain_ip_addr and remote_network_dhcp_lease
+const ipAddrTypeIPv6 = 1
+
+// Domain event IDs (virDomainEventID)
+const domainEventIDLifecycle = 0
+
+// Network listing flags (virConnectListAllNetworksFlags)
+const listNetworksActive = 1 << 1
+
+// LibvirtCollector collects stats by speaking the libvirt remote protocol
+// directly over the daemon's unix socket, without spawning virsh
+type LibvirtCollector struct {
+	uri    string
+	socket string
+	client rpcClient
+	connMu sync.Mutex
+
+	// Timeout bounds each remote procedure call
+	Timeout time.Duration
+	// MetadataTTL bounds how long static domain metadata is cached
+	MetadataTTL time.Duration
+	// IPSources lists the address sources tried, in order, until one
+	// yields addresses
+	IPSources []string
+	// Perf requests perf event counters
+	Perf bool
+	// DirtyRateInterval is how often a dirty page rate calculation is
+	// started on running domains; 0 disables dirty rate and memory
+	// bandwidth collection
+	DirtyRateInterval time.Duration
+
+	metadata    *metadataCache
+	node        nodeInfoCache
+	dirtyRate   dirtyRateSchedule
+	unsupported atomic.Uint32 // optional stats groups the daemon rejected
+}
+
+// NewLibvirtCollector creates a new LibvirtCollector. An empty uri opens the
+// daemon's default connection; an empty socket is derived from the uri.
+func NewLibvirtCollector(uri, socket string) *LibvirtCollector {
+	return &LibvirtCollector{
+		uri:         uri,
+		socket:      socket,
+		Timeout:     DefaultCommandTimeout,
+		MetadataTTL: DefaultMetadataTTL,
+		IPSources:   DefaultIPSources,
+		metadata:    newMetadataCache(),
+	}
+}
+
+// HostFromURI returns a short label for the hypervisor behind a libvirt URI,
+// such as "kvm1" for qemu+ssh://root@kvm1/system
+func HostFromURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	host := u.Hostname()
+	if host == "" {
+		host = "localhost"
+	}
+	if u.Path == "/session" {
+		host += " (session)"
+	}
+	return host
+}
+
+// LibvirtSocketForURI returns the local daemon socket serving a libvirt URI.
+// Only local URIs can be reached natively; remote transports such as
+// qemu+ssh need the virsh collector.
+func LibvirtSocketForURI(uri string) (string, error) {
+	if uri == "" {
+		return DefaultLibvirtSocket, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid libvirt URI %q: %w", uri, err)
+	}
+	if socket := u.Query().Get("socket"); socket != "" {
+		return socket, nil
+	}
+	if _, transport, ok := strings.Cut(u.Scheme, "+"); (ok && transport != "unix") || u.Host != "" {
+		return "", fmt.Errorf("remote libvirt URI %q is not supported by the libvirt collector, use -collector virsh", uri)
+	}
+
+	if u.Path == "/session" {
+		runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+		if runtimeDir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			runtimeDir = filepath.Join(home, ".cache")
+		}
+		return filepath.Join(runtimeDir, "libvirt", "libvirt-sock"), nil
+	}
+	return DefaultLibvirtSocket, nil
+}
+
+// GetVMStats fetches domain stats in a single connectGetAllDomainStats call
+func (c *LibvirtCollector) GetVMStats(ctx context.Context, domains []string) ([]VMStats, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	groups := wantedStatsGroups(c.Perf, c.DirtyRateInterval) &^ c.unsupported.Load()
+	stats, refs, err := c.getAllDomainStats(ctx, domains, groups)
+	if groups != 0 && rejectsFlag(err) {
+		// Older daemons reject some groups; find out which and keep
+		// collecting the others
+		if rejected := probeStatsGroups(groups, func(groups uint32) bool {
+			_, _, err := c.getAllDomainStats(ctx, domains, groups)
+			return rejectsFlag(err)
+		}); rejected != 0 {
+			c.unsupported.Or(rejected)
+			groups &^= rejected
+			stats, refs, err = c.getAllDomainStats(ctx, domains, groups)
+		}
+	}
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return nil, err
+	}
+
+	// Host CPUs are best effort; without them domain CPU % is unnormalized
+	node, err := c.nodeInfo(ctx)
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+	}
+
+	// Set timestamp for CPU calculation
+	now := time.Now().UnixNano()
+	for i := range stats {
+		stats[i].LastUpdate = now
+		stats[i].HostCPUs = node.CPUs
+		markCollected(&stats[i], groups)
+	}
+	c.metadata.observe(stats)
+
+	if groups&domainStatsDirtyRate != 0 && c.dirtyRate.due(time.Now(), c.DirtyRateInterval) {
+		c.startDirtyRateCalc(ctx, stats, refs)
+	}
+
+	c.enrichWithMetadata(ctx, stats, refs)
+	c.enrichWithConfig(ctx, stats, refs)
+	// One lease listing per network replaces a lease lookup per domain
+	var leases leaseTable
+	if wantsLeases(c.IPSources) {
+		leases = c.dhcpLeases(ctx)
+	}
+	c.enrichWithIPs(ctx, stats, refs, leases)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Close closes the connection to libvirtd
+func (c *LibvirtCollector) Close() error {
+	if !c.client.connected() {
+		return nil
+	}
+	// Best effort: tell the daemon we are going away
+	_, _ = c.call(context.Background(), procConnectClose, nil)
+	return c.client.close()
+}
+
+func (c *LibvirtCollector) connect(ctx context.Context) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.client.connected() {
+		return nil
+	}
+
+	return c.open(ctx, &c.client)
+}
+
+// open dials the daemon and opens the collector's connection on client
+func (c *LibvirtCollector) open(ctx context.Context, client *rpcClient) error {
+	socket := c.socket
+	if socket == "" {
+		var err error
+		if socket, err = LibvirtSocketForURI(c.uri); err != nil {
+			return err
+		}
+	}
+
+	dialer := net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to libvirt: %w", err)
+	}
+
+	client.mu.Lock()
+	client.conn = conn
+	client.mu.Unlock()
+
+	var args xdrEncoder
+	if c.uri != "" {
+		args.optionalString(&c.uri)
+	} else {
+		args.optionalString(nil) // default URI for this socket
+	}
+	args.uint32(0) // flags
+	if _, err := client.call(ctx, procConnectOpen, args.buf.Bytes(), time.Now().Add(c.Timeout)); err != nil {
+		_ = client.close()
+		return fmt.Errorf("failed to open libvirt connection: %w", err)
+	}
+	return nil
+}
+
+// call makes a procedure call, reconnecting first if an earlier call was
+// abandoned and took the connection down with it
+func (c *LibvirtCollector) call(ctx context.Context, proc int32, args []byte) ([]byte, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.call(ctx, proc, args, time.Now().Add(c.Timeout))
+}
+
+// dropOnIOError closes the connection after transport failures so the next
+// refresh reconnects; errors reported by libvirtd itself keep it open. A
+// call abandoned because ctx ended has already closed the connection it
+// broke, and closing again here could take down one a newer refresh opened.
+func (c *LibvirtCollector) dropOnIOError(ctx context.Context, err error) {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	var lerr *LibvirtError
+	if !errors.As(err, &lerr) {
+		_ = c.client.close()
+	}
+}
+
+func (c *LibvirtCollector) lookupDomain(ctx context.Context, name string) (domainRef, error) {
+	var args xdrEncoder
+	args.string(name)
+	body, err := c.call(ctx, procDomainLookupByName, args.buf.Bytes())
+	if err != nil {
+		return domainRef{}, err
+	}
+	d := &xdrDecoder{buf: body}
+	ref := d.domain()
+	return ref, d.err
+}
+
+// getAllDomainStats returns the stats for each domain along with the domain
+// references needed to address follow-up calls, in the same order. optional
+// adds stats groups on top of the ones always collected.
+func (c *LibvirtCollector) getAllDomainStats(ctx context.Context, domains []string, optional uint32) ([]VMStats, []domainRef, error) {
+	var refs []domainRef
+	for _, name := range domains {
+		ref, err := c.lookupDomain(ctx, name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to look up domain %q: %w", name, err)
+		}
+		refs = append(refs, ref)
+	}
+
+	var args xdrEncoder
+	args.uint32(uint32(len(refs)))
+	for _, ref := range refs {
+		args.domain(ref)
+	}
+	args.uint32(domainStatsState | domainStatsCPUTotal | domainStatsBalloon | domainStatsVCPU | domainStatsBlock | domainStatsInterface | optional)
+	args.uint32(0) // flags
+
+	body, err := c.call(ctx, procConnectGetAllDomainStats, args.buf.Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get domain stats: %w", err)
+	}
+	return decodeDomainStats(body)
+}
+
+// nodeInfo returns the hypervisor's hardware description, read once
+func (c *LibvirtCollector) nodeInfo(ctx context.Context) (NodeInfo, error) {
+	return c.node.get(func() (NodeInfo, error) {
+		body, err := c.call(ctx, procNodeGetInfo, nil)
+		if err != nil {
+			return NodeInfo{}, fmt.Errorf("failed to get node info: %w", err)
+		}
+		return decodeNodeInfo(body)
+	})
+}
+
+// decodeNodeInfo decodes remote_node_get_info_ret. The model is a fixed
+// char[32], which XDR encodes as 32 four-byte integers.
+func decodeNodeInfo(body []byte) (NodeInfo, error) {
+	d := &xdrDecoder{buf: body}
+	model := make([]byte, 0, 32)
+	for i := 0; i < 32; i++ {
+		if ch := byte(d.int32()); ch != 0 {
+			model = append(model, ch)
+		}
+	}
+	info := NodeInfo{
+		CPUModel:  string(model),
+		Memory:    int64(d.uint64()),
+		CPUs:      int(d.int32()),
+		MHz:       int(d.int32()),
+		NUMANodes: int(d.int32()),
+		Sockets:   int(d.int32()),
+		Cores:     int(d.int32()),
+		Threads:   int(d.int32()),
+	}
+	if d.err != nil {
+		return NodeInfo{}, fmt.Errorf("failed to decode node info: %w", d.err)
+	}
+	return info, nil
+}
+
+// GetHostStats reads the hypervisor's CPU times and memory usage
+func (c *LibvirtCollector) GetHostStats(ctx context.Context) (HostStats, error) {
+	if err := c.connect(ctx); err != nil {
+		return HostStats{}, err
+	}
+
+	node, err := c.nodeInfo(ctx)
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return HostStats{}, err
+	}
+	host := HostStats{Node: node}
+
+	// All CPUs (VIR_NODE_CPU_STATS_ALL_CPUS) and all NUMA cells
+	// (VIR_NODE_MEMORY_STATS_ALL_CELLS)
+	cpuArgs := func(args *xdrEncoder, nparams int32) {
+		args.int32(-1) // cpuNum
+		args.int32(nparams)
+		args.uint32(0) // flags
+	}
+	memoryArgs := func(args *xdrEncoder, nparams int32) {
+		args.int32(nparams)
+		args.int32(-1) // cellNum
+		args.uint32(0) // flags
+	}
+
+	err = c.nodeStats(ctx, procNodeGetCPUStats, cpuArgs, func(field string, value int64) {
+		applyHostCPUStat(field, value, &host.CPU)
+	})
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return HostStats{}, fmt.Errorf("failed to get node CPU stats: %w", err)
+	}
+	err = c.nodeStats(ctx, procNodeGetMemoryStats, memoryArgs, func(field string, value int64) {
+		applyHostMemoryStat(field, value, &host.Memory)
+	})
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return HostStats{}, fmt.Errorf("failed to get node memory stats: %w", err)
+	}
+
+	host.LastUpdate = time.Now().UnixNano()
+	return host, nil
+}
+
+// nodeStats calls nodeGetCPUStats or nodeGetMemoryStats. Like the C API,
+// the first call passes nparams 0 to ask how many fields there are, and the
+// second fetches them.
+func (c *LibvirtCollector) nodeStats(ctx context.Context, proc int32, encode func(args *xdrEncoder, nparams int32), apply func(field string, value int64)) error {
+	var nparams int32
+	for _, query := range []bool{true, false} {
+		var args xdrEncoder
+		encode(&args, nparams)
+		body, err := c.call(ctx, proc, args.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if query {
+			nparams, err = decodeNodeStats(body, func(string, int64) {})
+		} else {
+			_, err = decodeNodeStats(body, apply)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeNodeStats decodes remote_node_get_{cpu,memory}_stats_ret, calling
+// apply for each field and returning the number of fields available
+func decodeNodeStats(body []byte, apply func(field string, value int64)) (int32, error) {
+	d := &xdrDecoder{buf: body}
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		field := d.string()
+		value := d.uint64()
+		if d.err == nil {
+			apply(field, int64(value))
+		}
+	}
+	nparams := d.int32()
+	if d.err != nil {
+		return 0, fmt.Errorf("failed to decode node stats: %w", d.err)
+	}
+	return nparams, nil
+}
+
+// GetStoragePools lists every storage pool with its usage and target path
+func (c *LibvirtCollector) GetStoragePools(ctx context.Context) ([]StoragePool, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	var args xdrEncoder
+	args.int32(1)  // need_results
+	args.uint32(0) // flags: active and inactive
+	body, err := c.call(ctx, procConnectListAllPools, args.buf.Bytes())
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return nil, fmt.Errorf("failed to list storage pools: %w", err)
+	}
+	refs, err := decodeObjectList(body, "storage pools")
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make([]StoragePool, len(refs))
+	for i, ref := range refs {
+		pools[i] = StoragePool{Name: ref.Name}
+		if err := c.poolInfo(ctx, ref, &pools[i]); err != nil {
+			c.dropOnIOError(ctx, err)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return pools, nil
+}
+
+// poolInfo fills in a pool's usage and target path
+func (c *LibvirtCollector) poolInfo(ctx context.Context, ref objectRef, pool *StoragePool) error {
+	var args xdrEncoder
+	args.object(ref)
+	body, err := c.call(ctx, procStoragePoolGetInfo, args.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	// remote_storage_pool_get_info_ret; the state is an unsigned char,
+	// which XDR widens to four bytes
+	d := &xdrDecoder{buf: body}
+	pool.State = poolStateName(d.uint32())
+	pool.Capacity = int64(d.uint64())
+	pool.Allocation = int64(d.uint64())
+	pool.Available = int64(d.uint64())
+	if d.err != nil {
+		return fmt.Errorf("failed to decode pool info: %w", d.err)
+	}
+
+	args = xdrEncoder{}
+	args.object(ref)
+	args.uint32(0) // flags
+	body, err = c.call(ctx, procStoragePoolGetXMLDesc, args.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	d = &xdrDecoder{buf: body}
+	xmlDesc := d.string()
+	if d.err != nil {
+		return fmt.Errorf("failed to decode pool XML: %w", d.err)
+	}
+	pool.Type, pool.Path, err = parsePoolXML(xmlDesc)
+	return err
+}
+
+// decodeObjectList decodes the reply of connectListAllStoragePools or
+// connectListAllNetworks
+func decodeObjectList(body []byte, what string) ([]objectRef, error) {
+	d := &xdrDecoder{buf: body}
+	n := d.count()
+	var refs []objectRef
+	for i := 0; i < n && d.err == nil; i++ {
+		refs = append(refs, d.object())
+	}
+	d.uint32() // ret
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", what, d.err)
+	}
+	return refs, nil
+}
+
+// GetNetworks lists every virtual network with its bridge, forward mode and,
+// for active networks, DHCP leases
+func (c *LibvirtCollector) GetNetworks(ctx context.Context) ([]Network, error) {
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	refs, err := c.listNetworks(ctx, 0)
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return nil, err
+	}
+	active, err := c.listNetworks(ctx, listNetworksActive)
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return nil, err
+	}
+	isActive := make(map[string]bool)
+	for _, ref := range active {
+		isActive[ref.Name] = true
+	}
+
+	networks := make([]Network, len(refs))
+	for i, ref := range refs {
+		n := &networks[i]
+		n.Name = ref.Name
+		n.Active = isActive[ref.Name]
+
+		// Details are best effort per network
+		if xmlDesc, err := c.networkXML(ctx, ref); err == nil {
+			_ = parseNetworkXML(xmlDesc, n)
+		} else {
+			c.dropOnIOError(ctx, err)
+		}
+		if n.Active {
+			if leases, err := c.networkLeases(ctx, ref); err == nil {
+				n.Leases = leases
+			} else {
+				c.dropOnIOError(ctx, err)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return networks, nil
+}
+
+// dhcpLeases lists the leases of every active network, or returns nil if
+// they could not all be listed
+func (c *LibvirtCollector) dhcpLeases(ctx context.Context) leaseTable {
+	refs, err := c.listNetworks(ctx, listNetworksActive)
+	if err != nil {
+		c.dropOnIOError(ctx, err)
+		return nil
+	}
+
+	var all []DHCPLease
+	for _, ref := range refs {
+		leases, err := c.networkLeases(ctx, ref)
+		if err != nil {
+			c.dropOnIOError(ctx, err)
+			return nil
+		}
+		all = append(all, leases...)
+	}
+	return newLeaseTable(all)
+}
+
+func (c *LibvirtCollector) listNetworks(ctx context.Context, flags uint32) ([]objectRef, error) {
+	var args xdrEncoder
+	args.int32(1) // need_results
+	args.uint32(flags)
+	body, err := c.call(ctx, procConnectListAllNetworks, args.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	return decodeObjectList(body, "networks")
+}
+
+func (c *LibvirtCollector) networkXML(ctx context.Context, ref objectRef) (string, error) {
+	var args xdrEncoder
+	args.object(ref)
+	args.uint32(0) // flags
+	body, err := c.call(ctx, procNetworkGetXMLDesc, args.buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	d := &xdrDecoder{buf: body}
+	xmlDesc := d.string()
+	return xmlDesc, d.err
+}
+
+func (c *LibvirtCollector) networkLeases(ctx context.Context, ref objectRef) ([]DHCPLease, error) {
+	var args xdrEncoder
+	args.object(ref)
+	args.optionalString(nil) // all MACs
+	args.int32(1)            // need_results
+	args.uint32(0)           // flags
+	body, err := c.call(ctx, procNetworkGetDHCPLeases, args.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return decodeDHCPLeases(body)
+}
+
+// decodeDHCPLeases decodes remote_network_get_dhcp_leases_ret
+func decodeDHCPLeases(body []byte) ([]DHCPLease, error) {
+	d := &xdrDecoder{buf: body}
+	n := d.count()
+	var leases []DHCPLease
+	for i := 0; i < n && d.err == nil; i++ {
+		d.string() // iface
+		expiry := int64(d.uint64())
+		family := "ipv4"
+		if d.int32() == ipAddrTypeIPv6 {
+			family = "ipv6"
+		}
+		mac := d.optionalString()
+		d.optionalString() // iaid
+		address := d.string()
+		prefix := int(d.uint32())
+		hostname := d.optionalString()
+		d.optionalString() // clientid
+
+		lease := DHCPLease{
+			IP:     IPAddress{Address: address, Prefix: prefix, Family: family, Source: IPSourceLease},
+			Expiry: time.Unix(expiry, 0),
+		}
+		if mac != nil {
+			lease.MAC = *mac
+		}
+		if hostname != nil {
+			lease.Hostname = *hostname
+		}
+		leases = append(leases, lease)
+	}
+	d.uint32() // ret
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to decode DHCP leases: %w", d.err)
+	}
+	return leases, nil
+}
+
+// WatchEvents streams domain lifecycle events over a connection of its own,
+// since the collector's connection is only read while a call is waiting
+// for its reply. Each event also drops the domain's cached metadata.
+func (c *LibvirtCollector) WatchEvents(ctx context.Context) (<-chan DomainEvent, error) {
+	return watchLoop(ctx, c.streamEvents), nil
+}
+
+// streamEvents registers for lifecycle events and forwards them until the
+// connection drops or ctx is cancelled
+func (c *LibvirtCollector) streamEvents(ctx context.Context, events chan<- DomainEvent) error {
+	var client rpcClient
+	if err := c.open(ctx, &client); err != nil {
+		return err
+	}
+	defer func() { _ = client.close() }()
+
+	var args xdrEncoder
+	args.int32(domainEventIDLifecycle)
+	args.uint32(0) // no domain: events for all of them
+	if _, err := client.call(ctx, procConnectDomainEventCallbackRegisterAny, args.buf.Bytes(), time.Now().Add(c.Timeout)); err != nil {
+		return fmt.Errorf("failed to register for domain events: %w", err)
+	}
+
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for {
+		pkt, err := readPacket(conn)
+		if err != nil {
+			return contextOr(ctx, err)
+		}
+		if pkt.program != remoteProgram || pkt.kind != packetTypeMessage || pkt.procedure != procDomainEventCallbackLifecycle {
+			continue
+		}
+		ev, err := decodeLifecycleEvent(pkt.body)
+		if err != nil {
+			continue
+		}
+		c.metadata.invalidate(ev.Domain)
+		emit(ctx, events, ev)
+	}
+}
+
+// decodeLifecycleEvent decodes remote_domain_event_callback_lifecycle_msg
+func decodeLifecycleEvent(body []byte) (DomainEvent, error) {
+	d := &xdrDecoder{buf: body}
+	d.int32() // callbackID
+	ref := d.domain()
+	event, detail := d.int32(), d.int32()
+	if d.err != nil {
+		return DomainEvent{}, fmt.Errorf("failed to decode lifecycle event: %w", d.err)
+	}
+	ev := DomainEvent{Domain: ref.Name}
+	ev.Type, ev.Detail = lifecycleEvent(event, detail)
+	return ev, nil
+}
+
+func decodeDomainStats(body []byte) ([]VMStats, []domainRef, error) {
+	d := &xdrDecoder{buf: body}
+
+	var allStats []VMStats
+	var refs []domainRef
+	records := d.count()
+	for i := 0; i < records && d.err == nil; i++ {
+		ref := d.domain()
+		vm := VMStats{DomainName: ref.Name, ID: int(ref.ID)}
+		params := d.count()
+		for j := 0; j < params && d.err == nil; j++ {
+			p := d.typedParam()
+			applyStat(p.Field, p.Value, &vm)
+		}
+		allStats = append(allStats, vm)
+		refs = append(refs, ref)
+	}
+
+	if d.err != nil {
+		return nil, nil, fmt.Errorf("failed to decode domain stats: %w", d.err)
+	}
+	return allStats, refs, nil
+}
+
+// startDirtyRateCalc starts a dirty page rate measurement on each running
+// domain; the result shows up in the dirtyrate group of later refreshes
+func (c *LibvirtCollector) startDirtyRateCalc(ctx context.Context, vms []VMStats, refs []domainRef) {
+	for i := range vms {
+		if vms[i].State != 1 {
+			continue
+		}
+		if ctx.Err() != nil || !c.client.connected() {
+			return
+		}
+
+		var args xdrEncoder
+		args.domain(refs[i])
+		args.int32(dirtyRateWindow)
+		args.uint32(0) // flags
+		if _, err := c.call(ctx, procDomainStartDirtyRateCalc, args.buf.Bytes()); err != nil {
+			c.dropOnIOError(ctx, err)
+		}
+	}
+}
+
+// enrichWithIPs tries each configured address source until one yields
+// addresses for the domain's interfaces. Lease addresses come from leases
+// unless they could not be listed.
+func (c *LibvirtCollector) enrichWithIPs(ctx context.Context, vms []VMStats, refs []domainRef, leases leaseTable) {
+	for i := range vms {
+		// Only check IPs for running VMs (State == 1)
+		if vms[i].State != 1 {
+			continue
+		}
+
+		for _, source := range c.IPSources {
+			if ctx.Err() != nil || !c.client.connected() {
+				return
+			}
+
+			if source == IPSourceLease && leases != nil {
+				if assigned := assignAddresses(&vms[i], leases.lookup(&vms[i])); assigned > 0 {
+					break
+				}
+				continue
+			}
+
+			var args xdrEncoder
+			args.domain(refs[i])
+			args.uint32(interfaceAddressesSources[source])
+			args.uint32(0) // flags
+
+			body, err := c.call(ctx, procDomainInterfaceAddresses, args.buf.Bytes())
+			if err != nil {
+				// The source may be unavailable for this domain (e.g. no
+				// guest agent); IPs are "nice to have"
+				c.dropOnIOError(ctx, err)
+				continue
+			}
+
+			// MACs come from the domain config, so agent addresses
+			// reported under guest-side interface names still match
+			if assigned := assignAddresses(&vms[i], decodeInterfaceAddresses(body, source)); assigned > 0 {
+				break
+			}
+		}
+	}
+}
+
+func decodeInterfaceAddresses(body []byte, source string) []discoveredAddr {
+	var addrs []discoveredAddr
+	d := &xdrDecoder{buf: body}
+	ifaces := d.count()
+	for i := 0; i < ifaces && d.err == nil; i++ {
+		ifName := d.string()
+		mac := ""
+		if hwaddr := d.optionalString(); hwaddr != nil {
+			mac = *hwaddr
+		}
+		n := d.count()
+		for j := 0; j < n && d.err == nil; j++ {
+			family := "ipv4"
+			if d.int32() == ipAddrTypeIPv6 {
+				family = "ipv6"
+			}
+			address := d.string()
+			prefix := int(d.uint32())
+			if d.err != nil {
+				return addrs
+			}
+
+			addrs = append(addrs, discoveredAddr{
+				ifName: ifName,
+				mac:    mac,
+				addr: IPAddress{
+					Address: address,
+					Prefix:  prefix,
+					Family:  family,
+					Source:  source,
+				},
+			})
+		}
+	}
+	return addrs
+}
+
+// domainXML returns the live XML description of a domain
+func (c *LibvirtCollector) domainXML(ctx context.Context, ref domainRef) (string, error) {
+	var args xdrEncoder
+	args.domain(ref)
+	args.uint32(0) // flags
+
+	body, err := c.call(ctx, procDomainGetXMLDesc, args.buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	d := &xdrDecoder{buf: body}
+	xmlDesc := d.string()
+	return xmlDesc, d.err
+}
+
+// enrichWithConfig joins each domain's XML configuration onto its stats. The
+// parsed XML is cached alongside the domain's metadata.
+func (c *LibvirtCollector) enrichWithConfig(ctx context.Context, vms []VMStats, refs []domainRef) {
+	for i := range vms {
+		if ctx.Err() != nil || !c.client.connected() {
+			return
+		}
+
+		cfg, ok := c.metadata.lookupConfig(vms[i].DomainName, time.Now(), c.MetadataTTL)
+		if !ok {
+			xmlDesc, err := c.domainXML(ctx, refs[i])
+			if err != nil {
+				c.dropOnIOError(ctx, err)
+				continue
+			}
+			if cfg, err = parseDomainXML(xmlDesc); err != nil {
+				continue
+			}
+			c.metadata.storeConfig(vms[i].DomainName, cfg)
+		}
+		applyDomainConfig(cfg, &vms[i])
+	}
+}
+
+// enrichWithMetadata fills static metadata from the cache, querying libvirtd
+// only for domains that are new or whose entry was invalidated
+func (c *LibvirtCollector) enrichWithMetadata(ctx context.Context, vms []VMStats, refs []domainRef) {
+	for i := range vms {
+		if ctx.Err() != nil || !c.client.connected() {
+			return
+		}
+
+		uuid := refs[i].uuidString()
+		md, ok := c.metadata.lookupUUID(uuid, time.Now(), c.MetadataTTL)
+		if !ok {
+			var err error
+			md, err = c.fetchMetadata(ctx, refs[i])
+			if err != nil {
+				c.dropOnIOError(ctx, err)
+				continue
+			}
+			c.metadata.store(vms[i].DomainName, md, time.Now())
+		}
+
+		vms[i].Metadata = md
+		vms[i].OSType = md.OSType
+	}
+}
+
+func (c *LibvirtCollector) fetchMetadata(ctx context.Context, ref domainRef) (DomainMetadata, error) {
+	md := DomainMetadata{UUID: ref.uuidString()}
+
+	var args xdrEncoder
+	args.domain(ref)
+	domArgs := args.buf.Bytes()
+
+	body, err := c.call(ctx, procDomainGetOSType, domArgs)
+	if err != nil {
+		return md, err
+	}
+	d := &xdrDecoder{buf: body}
+	md.OSType = d.string()
+
+	// remote_domain_get_info_ret: state, maxMem, memory, nrVirtCpu, cpuTime
+	body, err = c.call(ctx, procDomainGetInfo, domArgs)
+	if err != nil {
+		return md, err
+	}
+	d = &xdrDecoder{buf: body}
+	d.uint32() // state
+	md.MaxMemory = int64(d.uint64())
+	d.uint64() // memory
+	md.VCPUs = int(d.uint32())
+
+	body, err = c.call(ctx, procDomainGetAutostart, domArgs)
+	if err != nil {
+		return md, err
+	}
+	d = &xdrDecoder{buf: body}
+	md.Autostart = d.int32() != 0
+
+	body, err = c.call(ctx, procDomainIsPersistent, domArgs)
+	if err != nil {
+		return md, err
+	}
+	d = &xdrDecoder{buf: body}
+	md.Persistent = d.int32() != 0
+
+	return md, d.err
+}
@@ -0,0 +1,468 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Libvirt remote protocol constants (see libvirt's remote_protocol.x)
+const (
+	remoteProgram         = 0x20008086
+	remoteProtocolVersion = 1
+
+	packetTypeCall    = 0
+	packetTypeReply   = 1
+	packetTypeMessage = 2
+
+	packetStatusOK    = 0
+	packetStatusError = 1
+
+	// Header: length, program, version, procedure, type, serial, status
+	packetHeaderSize = 28
+	maxPacketSize    = 32 * 1024 * 1024
+)
+
+// Remote procedure numbers used by LibvirtCollector
+const (
+	procConnectOpen                           = 1
+	procConnectClose                          = 2
+	procNodeGetInfo                           = 6
+	procDomainGetXMLDesc                      = 14
+	procDomainGetAutostart                    = 15
+	procDomainGetInfo                         = 16
+	procDomainGetOSType                       = 19
+	procDomainLookupByName                    = 23
+	procNetworkGetXMLDesc                     = 43
+	procStoragePoolGetInfo                    = 87
+	procStoragePoolGetXMLDesc                 = 88
+	procDomainIsPersistent                    = 211
+	procNodeGetCPUStats                       = 227
+	procNodeGetMemoryStats                    = 228
+	procConnectListAllPools                   = 281
+	procConnectListAllNetworks                = 283
+	procConnectDomainEventCallbackRegisterAny = 316
+	procDomainEventCallbackLifecycle          = 318
+	procNetworkGetDHCPLeases                  = 341
+	procConnectGetAllDomainStats              = 344
+	procDomainInterfaceAddresses              = 353
+	procDomainStartDirtyRateCalc              = 427
+)
+
+// Typed parameter value discriminants (virTypedParameterType)
+const (
+	typedParamInt     = 1
+	typedParamUInt    = 2
+	typedParamLLong   = 3
+	typedParamULLong  = 4
+	typedParamDouble  = 5
+	typedParamBoolean = 6
+	typedParamString  = 7
+)
+
+// Libvirt error codes (virErrorNumber) that mean a request is not supported
+const (
+	errNoSupport           = 3
+	errInvalidArg          = 8
+	errArgumentUnsupported = 74
+)
+
+// LibvirtError is an error reported by the libvirt daemon
+type LibvirtError struct {
+	Code    int32
+	Domain  int32
+	Message string
+}
+
+func (e *LibvirtError) Error() string {
+	return fmt.Sprintf("libvirt error %d: %s", e.Code, e.Message)
+}
+
+// rejectsFlag reports whether err is the daemon refusing a flag or argument
+// it does not know, rather than failing to carry out a request it accepted
+func rejectsFlag(err error) bool {
+	var lerr *LibvirtError
+	if !errors.As(err, &lerr) {
+		return false
+	}
+	switch lerr.Code {
+	case errNoSupport, errInvalidArg, errArgumentUnsupported:
+		return true
+	}
+	return false
+}
+
+// domainRef identifies a domain on the wire (remote_nonnull_domain)
+type domainRef struct {
+	Name string
+	UUID [16]byte
+	ID   int32
+}
+
+// uuidString formats a domain UUID the way virsh prints it
+func (d domainRef) uuidString() string {
+	u := d.UUID
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// objectRef identifies a storage pool or network on the wire
+// (remote_nonnull_storage_pool, remote_nonnull_network)
+type objectRef struct {
+	Name string
+	UUID [16]byte
+}
+
+// typedParam is a decoded remote_typed_param
+type typedParam struct {
+	Field string
+	Value string
+}
+
+// xdrEncoder writes XDR-encoded values into a buffer
+type xdrEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *xdrEncoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *xdrEncoder) int32(v int32) {
+	e.uint32(uint32(v))
+}
+
+func (e *xdrEncoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *xdrEncoder) float64(v float64) {
+	e.uint64(math.Float64bits(v))
+}
+
+func (e *xdrEncoder) opaque(b []byte) {
+	e.buf.Write(b)
+	if pad := (4 - len(b)%4) % 4; pad > 0 {
+		e.buf.Write(make([]byte, pad))
+	}
+}
+
+func (e *xdrEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.opaque([]byte(s))
+}
+
+// optionalString encodes a remote_string, where nil is an absent pointer
+func (e *xdrEncoder) optionalString(s *string) {
+	if s == nil {
+		e.uint32(0)
+		return
+	}
+	e.uint32(1)
+	e.string(*s)
+}
+
+func (e *xdrEncoder) domain(d domainRef) {
+	e.string(d.Name)
+	e.opaque(d.UUID[:])
+	e.int32(d.ID)
+}
+
+func (e *xdrEncoder) object(o objectRef) {
+	e.string(o.Name)
+	e.opaque(o.UUID[:])
+}
+
+// xdrDecoder reads XDR-encoded values, remembering the first error
+type xdrDecoder struct {
+	buf []byte
+	err error
+}
+
+var errShortBuffer = errors.New("libvirt: truncated XDR data")
+
+func (d *xdrDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errShortBuffer
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *xdrDecoder) uint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *xdrDecoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *xdrDecoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *xdrDecoder) float64() float64 {
+	return math.Float64frombits(d.uint64())
+}
+
+func (d *xdrDecoder) opaque(n int) []byte {
+	b := d.next(n)
+	d.next((4 - n%4) % 4)
+	return b
+}
+
+func (d *xdrDecoder) string() string {
+	n := d.uint32()
+	if d.err == nil && int(n) > len(d.buf) {
+		d.err = errShortBuffer
+		return ""
+	}
+	return string(d.opaque(int(n)))
+}
+
+func (d *xdrDecoder) optionalString() *string {
+	if d.uint32() == 0 {
+		return nil
+	}
+	s := d.string()
+	return &s
+}
+
+// count reads an array length, guarding against lengths that cannot fit
+func (d *xdrDecoder) count() int {
+	n := d.uint32()
+	if d.err == nil && int(n) > len(d.buf) {
+		d.err = errShortBuffer
+		return 0
+	}
+	return int(n)
+}
+
+func (d *xdrDecoder) domain() domainRef {
+	var ref domainRef
+	ref.Name = d.string()
+	copy(ref.UUID[:], d.opaque(16))
+	ref.ID = d.int32()
+	return ref
+}
+
+func (d *xdrDecoder) object() objectRef {
+	var ref objectRef
+	ref.Name = d.string()
+	copy(ref.UUID[:], d.opaque(16))
+	return ref
+}
+
+// typedParam decodes a remote_typed_param, rendering the value as text the
+// same way virsh prints it so both collectors share one set of parsers
+func (d *xdrDecoder) typedParam() typedParam {
+	p := typedParam{Field: d.string()}
+	switch kind := d.int32(); kind {
+	case typedParamInt, typedParamBoolean:
+		p.Value = fmt.Sprintf("%d", d.int32())
+	case typedParamUInt:
+		p.Value = fmt.Sprintf("%d", d.uint32())
+	case typedParamLLong:
+		p.Value = fmt.Sprintf("%d", int64(d.uint64()))
+	case typedParamULLong:
+		p.Value = fmt.Sprintf("%d", d.uint64())
+	case typedParamDouble:
+		p.Value = fmt.Sprintf("%f", d.float64())
+	case typedParamString:
+		p.Value = d.string()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("libvirt: unknown typed parameter type %d", kind)
+		}
+	}
+	return p
+}
+
+func decodeLibvirtError(body []byte) error {
+	d := &xdrDecoder{buf: body}
+	e := &LibvirtError{Code: d.int32(), Domain: d.int32()}
+	if msg := d.optionalString(); msg != nil {
+		e.Message = *msg
+	}
+	if d.err != nil {
+		return fmt.Errorf("libvirt: malformed error reply: %w", d.err)
+	}
+	return e
+}
+
+// rpcClient is a minimal synchronous client for the libvirt remote protocol
+type rpcClient struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	serial uint32
+}
+
+// call sends a procedure call and waits for its reply body. The call is
+// abandoned at the deadline or when ctx is cancelled, which leaves the stream
+// unusable: a call abandoned because of ctx closes the connection itself,
+// and callers must close it after other I/O errors.
+func (c *rpcClient) call(ctx context.Context, proc int32, args []byte, deadline time.Time) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil, errors.New("libvirt: not connected")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// Unblock pending I/O as soon as ctx is done. The socket deadline is
+	// not cut to ctx's own, or it could fire before ctx reports why.
+	conn := c.conn
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	c.serial++
+	serial := c.serial
+
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	err := writePacket(c.conn, packet{
+		program:   remoteProgram,
+		version:   remoteProtocolVersion,
+		procedure: proc,
+		kind:      packetTypeCall,
+		serial:    serial,
+		status:    packetStatusOK,
+		body:      args,
+	})
+	if err != nil {
+		return nil, c.abandon(ctx, err)
+	}
+
+	for {
+		pkt, err := readPacket(c.conn)
+		if err != nil {
+			return nil, c.abandon(ctx, err)
+		}
+		// Skip anything that is not the reply to this call, such as
+		// asynchronous event messages
+		if pkt.program != remoteProgram || pkt.kind != packetTypeReply || pkt.serial != serial {
+			continue
+		}
+		if pkt.status == packetStatusError {
+			return nil, decodeLibvirtError(pkt.body)
+		}
+		return pkt.body, nil
+	}
+}
+
+// contextOr reports ctx's error in place of the I/O error it caused
+func contextOr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// abandon reports ctx's error in place of the I/O error it caused, closing
+// the connection a cancelled call left mid-packet. It is called with mu
+// held, so no other call can be using the connection.
+func (c *rpcClient) abandon(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return err
+	}
+	_ = c.conn.Close()
+	c.conn = nil
+	return ctxErr
+}
+
+func (c *rpcClient) connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+func (c *rpcClient) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+type packet struct {
+	program   uint32
+	version   uint32
+	procedure int32
+	kind      uint32
+	serial    uint32
+	status    uint32
+	body      []byte
+}
+
+func readPacket(r io.Reader) (packet, error) {
+	var hdr [packetHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return packet{}, err
+	}
+
+	d := &xdrDecoder{buf: hdr[:]}
+	length := d.uint32()
+	pkt := packet{
+		program:   d.uint32(),
+		version:   d.uint32(),
+		procedure: d.int32(),
+		kind:      d.uint32(),
+		serial:    d.uint32(),
+		status:    d.uint32(),
+	}
+	if length < packetHeaderSize || length > maxPacketSize {
+		return packet{}, fmt.Errorf("libvirt: invalid packet length %d", length)
+	}
+
+	pkt.body = make([]byte, length-packetHeaderSize)
+	if _, err := io.ReadFull(r, pkt.body); err != nil {
+		return packet{}, err
+	}
+	return pkt, nil
+}
+
+func writePacket(w io.Writer, pkt packet) error {
+	var e xdrEncoder
+	e.uint32(uint32(packetHeaderSize + len(pkt.body)))
+	e.uint32(pkt.program)
+	e.uint32(pkt.version)
+	e.int32(pkt.procedure)
+	e.uint32(pkt.kind)
+	e.uint32(pkt.serial)
+	e.uint32(pkt.status)
+	e.buf.Write(pkt.body)
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
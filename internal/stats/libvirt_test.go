@@ -0,0 +1,863 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLibvirtd replays canned reply bodies (or errors) per procedure over a
+// unix socket, recording the calls it receives
+type fakeLibvirtd struct {
+	socket  string
+	replies map[int32][]byte
+	errors  map[int32]*LibvirtError
+	// hang lists procedures that never get a reply
+	hang map[int32]bool
+	// handlers compute replies from the call arguments, taking precedence
+	// over replies
+	handlers map[int32]func(args []byte) []byte
+	// rejects compute errors from the call arguments; a nil error lets the
+	// call through
+	rejects map[int32]func(args []byte) *LibvirtError
+	// after lists unsolicited packets sent once a procedure was answered,
+	// such as events following a callback registration
+	after map[int32][]packet
+
+	mu    sync.Mutex
+	calls []packet
+}
+
+func newFakeLibvirtd(t *testing.T) *fakeLibvirtd {
+	t.Helper()
+	f := &fakeLibvirtd{
+		socket:   filepath.Join(t.TempDir(), "libvirt-sock"),
+		replies:  map[int32][]byte{procConnectOpen: nil, procConnectClose: nil},
+		errors:   map[int32]*LibvirtError{},
+		hang:     map[int32]bool{},
+		handlers: map[int32]func(args []byte) []byte{},
+		rejects:  map[int32]func(args []byte) *LibvirtError{},
+		after:    map[int32][]packet{},
+	}
+
+	l, err := net.Listen("unix", f.socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on fake socket: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeLibvirtd) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		call, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.calls = append(f.calls, call)
+		body, ok := f.replies[call.procedure]
+		lerr := f.errors[call.procedure]
+		hang := f.hang[call.procedure]
+		after := f.after[call.procedure]
+		if handler := f.handlers[call.procedure]; handler != nil {
+			body, ok = handler(call.body), true
+		}
+		if reject := f.rejects[call.procedure]; reject != nil {
+			if err := reject(call.body); err != nil {
+				lerr = err
+			}
+		}
+		f.mu.Unlock()
+
+		if hang {
+			continue
+		}
+
+		reply := call
+		reply.kind = packetTypeReply
+		reply.body = body
+		switch {
+		case lerr != nil:
+			reply.status = packetStatusError
+			reply.body = encodeLibvirtError(lerr)
+		case !ok:
+			reply.status = packetStatusError
+			reply.body = encodeLibvirtError(&LibvirtError{Code: 1, Message: "unsupported procedure"})
+		}
+
+		// Interleave an unrelated message to check the client skips it
+		_ = writePacket(conn, packet{program: remoteProgram, procedure: 318, kind: 2})
+		if err := writePacket(conn, reply); err != nil {
+			return
+		}
+		for _, pkt := range after {
+			if err := writePacket(conn, pkt); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (f *fakeLibvirtd) callsTo(proc int32) []packet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []packet
+	for _, c := range f.calls {
+		if c.procedure == proc {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func encodeLibvirtError(e *LibvirtError) []byte {
+	var enc xdrEncoder
+	enc.int32(e.Code)
+	enc.int32(e.Domain)
+	enc.optionalString(&e.Message)
+	return enc.buf.Bytes()
+}
+
+func encodeTypedParam(enc *xdrEncoder, field string, value interface{}) {
+	enc.string(field)
+	switch v := value.(type) {
+	case int32:
+		enc.int32(typedParamInt)
+		enc.int32(v)
+	case uint64:
+		enc.int32(typedParamULLong)
+		enc.uint64(v)
+	case string:
+		enc.int32(typedParamString)
+		enc.string(v)
+	}
+}
+
+var testDomain = domainRef{
+	Name: "noble_default",
+	UUID: [16]byte{0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	ID:   3,
+}
+
+func domainStatsReply() []byte {
+	params := []struct {
+		field string
+		value interface{}
+	}{
+		{"state.state", int32(1)},
+		{"state.reason", int32(1)},
+		{"balloon.current", uint64(16777216)},
+		{"balloon.rss", uint64(1128336)},
+		{"vcpu.current", uint64(2)},
+		{"vcpu.0.state", int32(1)},
+		{"vcpu.0.time", uint64(50350000000)},
+		{"vcpu.1.time", uint64(29380000000)},
+		{"block.count", uint64(1)},
+		{"block.0.name", "vda"},
+		{"block.0.rd.reqs", uint64(5000)},
+		{"net.count", uint64(1)},
+		{"net.0.name", "vnet0"},
+		{"net.0.rx.bytes", uint64(4096)},
+	}
+
+	var enc xdrEncoder
+	enc.uint32(1) // records
+	enc.domain(testDomain)
+	enc.uint32(uint32(len(params)))
+	for _, p := range params {
+		encodeTypedParam(&enc, p.field, p.value)
+	}
+	return enc.buf.Bytes()
+}
+
+func interfaceAddressesReply() []byte {
+	mac := "52:54:00:12:34:56"
+	var enc xdrEncoder
+	enc.uint32(1) // interfaces
+	enc.string("vnet0")
+	enc.optionalString(&mac)
+	enc.uint32(1) // addresses
+	enc.int32(0)  // ipv4
+	enc.string("192.168.122.238")
+	enc.uint32(24)
+	return enc.buf.Bytes()
+}
+
+// replyMetadata installs canned replies for the metadata procedures
+func (f *fakeLibvirtd) replyMetadata() {
+	var osType, info, autostart, persistent xdrEncoder
+	osType.string("hvm")
+	info.uint32(1)        // state
+	info.uint64(16777216) // maxMem
+	info.uint64(16777216) // memory
+	info.uint32(4)        // nrVirtCpu
+	info.uint64(0)        // cpuTime
+	autostart.int32(1)
+	persistent.int32(1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies[procDomainGetOSType] = osType.buf.Bytes()
+	f.replies[procDomainGetInfo] = info.buf.Bytes()
+	f.replies[procDomainGetAutostart] = autostart.buf.Bytes()
+	f.replies[procDomainIsPersistent] = persistent.buf.Bytes()
+}
+
+func TestLibvirtCollectorGetVMStats(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	fake.replies[procConnectGetAllDomainStats] = domainStatsReply()
+	fake.replies[procDomainInterfaceAddresses] = interfaceAddressesReply()
+	fake.replyMetadata()
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	allStats, err := collector.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(allStats) != 1 {
+		t.Fatalf("Expected 1 stats entry, got %d", len(allStats))
+	}
+	stats := allStats[0]
+
+	if stats.DomainName != "noble_default" {
+		t.Errorf("Expected domain name 'noble_default', got '%s'", stats.DomainName)
+	}
+	if stats.State != 1 {
+		t.Errorf("Expected state 1, got %d", stats.State)
+	}
+	if stats.BalloonStats.Current != 16777216 {
+		t.Errorf("Expected balloon current 16777216, got %d", stats.BalloonStats.Current)
+	}
+	if len(stats.VCPUStats) != 2 || stats.VCPUStats[1].Time != 29380000000 {
+		t.Errorf("Expected 2 vCPUs with vcpu 1 time 29380000000, got %+v", stats.VCPUStats)
+	}
+	if len(stats.BlockStats) != 1 || stats.BlockStats[0].Name != "vda" || stats.BlockStats[0].ReadReqs != 5000 {
+		t.Errorf("Expected block vda with 5000 read reqs, got %+v", stats.BlockStats)
+	}
+	if len(stats.InterfaceStats) != 1 || stats.InterfaceStats[0].RxBytes != 4096 {
+		t.Fatalf("Expected interface vnet0 with 4096 rx bytes, got %+v", stats.InterfaceStats)
+	}
+	expectedIP := IPAddress{Address: "192.168.122.238", Prefix: 24, Family: "ipv4", Source: IPSourceLease}
+	if ips := stats.InterfaceStats[0].IPs; len(ips) != 1 || ips[0] != expectedIP {
+		t.Errorf("Expected IP %+v, got %+v", expectedIP, ips)
+	}
+	if stats.ID != 3 {
+		t.Errorf("Expected domain ID 3, got %d", stats.ID)
+	}
+	if stats.OSType != "hvm" {
+		t.Errorf("Expected OS type 'hvm', got '%s'", stats.OSType)
+	}
+	expectedMD := DomainMetadata{
+		UUID:       "deadbeef-0102-0304-0506-0708090a0b0c",
+		OSType:     "hvm",
+		Autostart:  true,
+		Persistent: true,
+		MaxMemory:  16777216,
+		VCPUs:      4,
+	}
+	if stats.Metadata != expectedMD {
+		t.Errorf("Expected metadata %+v, got %+v", expectedMD, stats.Metadata)
+	}
+	if stats.LastUpdate == 0 {
+		t.Error("Expected LastUpdate to be set")
+	}
+
+	// Follow-up calls must address the domain by the UUID libvirtd returned
+	calls := fake.callsTo(procDomainGetOSType)
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 domainGetOSType call, got %d", len(calls))
+	}
+	d := &xdrDecoder{buf: calls[0].body}
+	if ref := d.domain(); ref != testDomain {
+		t.Errorf("Expected domain ref %+v, got %+v", testDomain, ref)
+	}
+
+	// The connection is reused across refreshes, and metadata is cached
+	allStats, err = collector.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error on second refresh, got %v", err)
+	}
+	if n := len(fake.callsTo(procConnectOpen)); n != 1 {
+		t.Errorf("Expected 1 connectOpen call, got %d", n)
+	}
+	if n := len(fake.callsTo(procDomainGetInfo)); n != 1 {
+		t.Errorf("Expected cached metadata to skip domainGetInfo, got %d calls", n)
+	}
+	if allStats[0].Metadata != expectedMD {
+		t.Errorf("Expected cached metadata %+v, got %+v", expectedMD, allStats[0].Metadata)
+	}
+
+	// Invalidation re-reads it
+	collector.metadata.invalidate("noble_default")
+	if _, err := collector.GetVMStats(context.Background(), nil); err != nil {
+		t.Fatalf("Expected no error on third refresh, got %v", err)
+	}
+	if n := len(fake.callsTo(procDomainGetInfo)); n != 2 {
+		t.Errorf("Expected invalidated metadata to be re-read, got %d domainGetInfo calls", n)
+	}
+}
+
+func TestLibvirtCollectorDomainFilter(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	var lookup xdrEncoder
+	lookup.domain(testDomain)
+	fake.replies[procDomainLookupByName] = lookup.buf.Bytes()
+	fake.replies[procConnectGetAllDomainStats] = domainStatsReply()
+	fake.replies[procDomainInterfaceAddresses] = interfaceAddressesReply()
+	fake.replyMetadata()
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	if _, err := collector.GetVMStats(context.Background(), []string{"noble_default"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	calls := fake.callsTo(procConnectGetAllDomainStats)
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 connectGetAllDomainStats call, got %d", len(calls))
+	}
+	d := &xdrDecoder{buf: calls[0].body}
+	if n := d.count(); n != 1 {
+		t.Fatalf("Expected 1 domain in the stats call, got %d", n)
+	}
+	if ref := d.domain(); ref.Name != "noble_default" {
+		t.Errorf("Expected domain 'noble_default', got '%s'", ref.Name)
+	}
+}
+
+func TestLibvirtCollectorError(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	fake.errors[procConnectGetAllDomainStats] = &LibvirtError{Code: 38, Message: "operation failed"}
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	_, err := collector.GetVMStats(context.Background(), nil)
+	var lerr *LibvirtError
+	if !errors.As(err, &lerr) {
+		t.Fatalf("Expected a LibvirtError, got %v", err)
+	}
+	if lerr.Code != 38 || lerr.Message != "operation failed" {
+		t.Errorf("Expected code 38 'operation failed', got %d '%s'", lerr.Code, lerr.Message)
+	}
+}
+
+func TestLibvirtCollectorStatsGroupRejected(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	fake.replies[procConnectGetAllDomainStats] = domainStatsReply()
+	var requested []uint32
+	fake.rejects[procConnectGetAllDomainStats] = func(args []byte) *LibvirtError {
+		d := &xdrDecoder{buf: args}
+		for n := d.count(); n > 0; n-- {
+			d.domain()
+		}
+		groups := d.uint32()
+		requested = append(requested, groups)
+		switch {
+		case len(requested) == 1:
+			return &LibvirtError{Code: 38, Message: "operation failed"}
+		case groups&domainStatsPerf != 0:
+			return &LibvirtError{Code: 74, Message: "Stats types bits 0x40 are not supported by this daemon"}
+		}
+		return nil
+	}
+
+	collector := NewLibvirtCollector("", fake.socket)
+	collector.Perf = true
+	defer func() { _ = collector.Close() }()
+
+	// A failure unrelated to the groups asked for disables none of them
+	if _, err := collector.GetVMStats(context.Background(), nil); err == nil {
+		t.Fatal("Expected the first refresh to fail")
+	}
+	allStats, err := collector.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected perf to be skipped, got %v", err)
+	}
+	if len(allStats) != 1 || allStats[0].Perf.Collected {
+		t.Errorf("Expected 1 domain without perf stats, got %+v", allStats)
+	}
+	if _, err := collector.GetVMStats(context.Background(), nil); err != nil {
+		t.Fatalf("Expected no error on third refresh, got %v", err)
+	}
+
+	last := requested[len(requested)-1]
+	if last&domainStatsPerf != 0 || last&domainStatsIOThread == 0 {
+		t.Errorf("Expected only perf to be dropped, got groups %#x", last)
+	}
+	var perfAttempts int
+	for _, groups := range requested {
+		if groups&domainStatsPerf != 0 {
+			perfAttempts++
+		}
+	}
+	// The failed refresh, the rejected one and the probe on its own
+	if perfAttempts != 3 {
+		t.Errorf("Expected perf to be requested 3 times, got %d in %#x", perfAttempts, requested)
+	}
+}
+
+func TestDecodeDomainStatsTruncated(t *testing.T) {
+	body := domainStatsReply()
+	if _, _, err := decodeDomainStats(body[:len(body)-3]); err == nil {
+		t.Error("Expected an error for truncated stats reply")
+	}
+}
+
+func TestLibvirtCollectorConnectURI(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	fake.replies[procConnectGetAllDomainStats] = domainStatsReply()
+
+	collector := NewLibvirtCollector("qemu:///session", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	if _, err := collector.GetVMStats(context.Background(), nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	calls := fake.callsTo(procConnectOpen)
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 connectOpen call, got %d", len(calls))
+	}
+	d := &xdrDecoder{buf: calls[0].body}
+	if name := d.optionalString(); name == nil || *name != "qemu:///session" {
+		t.Errorf("Expected connectOpen for 'qemu:///session', got %v", name)
+	}
+}
+
+func TestLibvirtSocketForURI(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+
+	tests := []struct {
+		uri     string
+		socket  string
+		wantErr bool
+	}{
+		{uri: "", socket: DefaultLibvirtSocket},
+		{uri: "qemu:///system", socket: DefaultLibvirtSocket},
+		{uri: "qemu+unix:///system", socket: DefaultLibvirtSocket},
+		{uri: "qemu:///session", socket: "/run/user/1000/libvirt/libvirt-sock"},
+		{uri: "qemu+unix:///system?socket=/tmp/sock", socket: "/tmp/sock"},
+		{uri: "qemu+ssh://root@kvm1/system", wantErr: true},
+		{uri: "qemu+tcp://kvm1/system", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		socket, err := LibvirtSocketForURI(tt.uri)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expected error for %q, got socket %q", tt.uri, socket)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected no error for %q, got %v", tt.uri, err)
+			continue
+		}
+		if socket != tt.socket {
+			t.Errorf("Expected socket %q for %q, got %q", tt.socket, tt.uri, socket)
+		}
+	}
+}
+
+func TestHostFromURI(t *testing.T) {
+	tests := map[string]string{
+		"":                                 "localhost",
+		"qemu:///system":                   "localhost",
+		"qemu:///session":                  "localhost (session)",
+		"qemu+ssh://root@kvm1/system":      "kvm1",
+		"qemu+tcp://kvm2.lan:16509/system": "kvm2.lan",
+	}
+	for uri, expected := range tests {
+		if host := HostFromURI(uri); host != expected {
+			t.Errorf("Expected host %q for %q, got %q", expected, uri, host)
+		}
+	}
+}
+
+func TestLibvirtCollectorCancelled(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	fake.hang[procConnectGetAllDomainStats] = true
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := collector.GetVMStats(ctx, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the hung call to be abandoned, took %s", elapsed)
+	}
+
+	// The abandoned stream is dropped and the next refresh reconnects
+	fake.mu.Lock()
+	fake.hang[procConnectGetAllDomainStats] = false
+	fake.replies[procConnectGetAllDomainStats] = domainStatsReply()
+	fake.mu.Unlock()
+
+	if _, err := collector.GetVMStats(context.Background(), nil); err != nil {
+		t.Fatalf("Expected no error after reconnecting, got %v", err)
+	}
+	if n := len(fake.callsTo(procConnectOpen)); n != 2 {
+		t.Errorf("Expected 2 connectOpen calls, got %d", n)
+	}
+
+	// A cancelled refresh finishing late must not drop the connection a
+	// newer one opened
+	collector.dropOnIOError(ctx, ctx.Err())
+	if !collector.client.connected() {
+		t.Error("Expected a context error to keep the connection open")
+	}
+}
+
+func TestLibvirtCollectorIPSourceFallback(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	fake.replies[procConnectGetAllDomainStats] = domainStatsReply()
+	fake.replyMetadata()
+
+	// No leases; the guest agent reports guest-side names only
+	fake.handlers[procDomainInterfaceAddresses] = func(args []byte) []byte {
+		d := &xdrDecoder{buf: args}
+		d.domain()
+		var enc xdrEncoder
+		if d.uint32() != 1 { // not agent
+			enc.uint32(0)
+			return enc.buf.Bytes()
+		}
+		mac := "52:54:00:12:34:56"
+		enc.uint32(1)
+		enc.string("enp1s0")
+		enc.optionalString(&mac)
+		enc.uint32(2)
+		enc.int32(0)
+		enc.string("10.0.0.5")
+		enc.uint32(24)
+		enc.int32(ipAddrTypeIPv6)
+		enc.string("fd00::5")
+		enc.uint32(64)
+		return enc.buf.Bytes()
+	}
+	var xmlDesc xdrEncoder
+	xmlDesc.string(`<domain><devices><interface type='network'><mac address='52:54:00:12:34:56'/><target dev='vnet0'/></interface></devices></domain>`)
+	fake.replies[procDomainGetXMLDesc] = xmlDesc.buf.Bytes()
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	allStats, err := collector.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	iface := allStats[0].InterfaceStats[0]
+	if iface.MAC != "52:54:00:12:34:56" {
+		t.Errorf("Expected MAC from domain XML, got '%s'", iface.MAC)
+	}
+	expected := []IPAddress{
+		{Address: "10.0.0.5", Prefix: 24, Family: "ipv4", Source: IPSourceAgent},
+		{Address: "fd00::5", Prefix: 64, Family: "ipv6", Source: IPSourceAgent},
+	}
+	if len(iface.IPs) != len(expected) {
+		t.Fatalf("Expected %d IPs, got %+v", len(expected), iface.IPs)
+	}
+	for i := range expected {
+		if iface.IPs[i] != expected[i] {
+			t.Errorf("Expected IP %+v, got %+v", expected[i], iface.IPs[i])
+		}
+	}
+
+	// lease came up empty, agent answered, so arp is never asked
+	if n := len(fake.callsTo(procDomainInterfaceAddresses)); n != 2 {
+		t.Errorf("Expected 2 domainInterfaceAddresses calls, got %d", n)
+	}
+}
+
+func TestLibvirtCollectorGetHostStats(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+
+	var nodeInfo xdrEncoder
+	for i := 0; i < 32; i++ {
+		nodeInfo.int32(0)
+	}
+	nodeInfo.uint64(16314568)
+	for _, v := range []int32{8, 2400, 1, 1, 4, 2} {
+		nodeInfo.int32(v)
+	}
+	fake.replies[procNodeGetInfo] = nodeInfo.buf.Bytes()
+
+	// Reply with no fields to the nparams query, as libvirtd does
+	nodeStats := func(nparamsAt int, fields []string, values []uint64) func([]byte) []byte {
+		return func(args []byte) []byte {
+			d := &xdrDecoder{buf: args}
+			for i := 0; i < nparamsAt; i++ {
+				d.int32()
+			}
+			var enc xdrEncoder
+			if d.int32() == 0 {
+				enc.uint32(0)
+			} else {
+				enc.uint32(uint32(len(fields)))
+				for i, f := range fields {
+					enc.string(f)
+					enc.uint64(values[i])
+				}
+			}
+			enc.int32(int32(len(fields)))
+			return enc.buf.Bytes()
+		}
+	}
+	fake.handlers[procNodeGetCPUStats] = nodeStats(1,
+		[]string{"kernel", "user", "idle", "iowait"},
+		[]uint64{745070000000, 2289030000000, 27966330000000, 12590000000})
+	fake.handlers[procNodeGetMemoryStats] = nodeStats(0,
+		[]string{"total", "free", "buffers", "cached"},
+		[]uint64{16314568, 4507116, 291236, 7183468})
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	host, err := collector.GetHostStats(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if host.Node.CPUs != 8 || host.Node.Memory != 16314568 {
+		t.Errorf("Unexpected node info: %+v", host.Node)
+	}
+	if host.CPU.Kernel != 745070000000 || host.CPU.IOWait != 12590000000 {
+		t.Errorf("Unexpected CPU stats: %+v", host.CPU)
+	}
+	if host.Memory.Free != 4507116 || host.Memory.Cached != 7183468 {
+		t.Errorf("Unexpected memory stats: %+v", host.Memory)
+	}
+	if host.LastUpdate == 0 {
+		t.Error("Expected LastUpdate to be set")
+	}
+	if n := len(fake.callsTo(procNodeGetCPUStats)); n != 2 {
+		t.Errorf("Expected an nparams query and a fetch, got %d calls", n)
+	}
+}
+
+func TestLibvirtCollectorGetStoragePools(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	pool := objectRef{Name: "default", UUID: [16]byte{1, 2, 3}}
+
+	var list, info, xmlDesc xdrEncoder
+	list.uint32(1)
+	list.object(pool)
+	list.uint32(1) // ret
+	info.uint32(2) // running
+	info.uint64(107321753600)
+	info.uint64(64424509440)
+	info.uint64(42897244160)
+	xmlDesc.string("<pool type='dir'><target><path>/var/lib/libvirt/images</path></target></pool>")
+	fake.replies[procConnectListAllPools] = list.buf.Bytes()
+	fake.replies[procStoragePoolGetInfo] = info.buf.Bytes()
+	fake.replies[procStoragePoolGetXMLDesc] = xmlDesc.buf.Bytes()
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	pools, err := collector.GetStoragePools(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := StoragePool{
+		Name:       "default",
+		Type:       "dir",
+		State:      "running",
+		Path:       "/var/lib/libvirt/images",
+		Capacity:   107321753600,
+		Allocation: 64424509440,
+		Available:  42897244160,
+	}
+	if len(pools) != 1 || pools[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, pools)
+	}
+
+	calls := fake.callsTo(procStoragePoolGetInfo)
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 storagePoolGetInfo call, got %d", len(calls))
+	}
+	d := &xdrDecoder{buf: calls[0].body}
+	if ref := d.object(); ref != pool {
+		t.Errorf("Expected pool ref %+v, got %+v", pool, ref)
+	}
+}
+
+func TestLibvirtCollectorGetNetworks(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	defaultNet := objectRef{Name: "default", UUID: [16]byte{1}}
+	private := objectRef{Name: "private", UUID: [16]byte{2}}
+
+	fake.handlers[procConnectListAllNetworks] = func(args []byte) []byte {
+		d := &xdrDecoder{buf: args}
+		d.int32() // need_results
+		refs := []objectRef{defaultNet, private}
+		if d.uint32()&listNetworksActive != 0 {
+			refs = refs[:1]
+		}
+		var enc xdrEncoder
+		enc.uint32(uint32(len(refs)))
+		for _, ref := range refs {
+			enc.object(ref)
+		}
+		enc.uint32(uint32(len(refs)))
+		return enc.buf.Bytes()
+	}
+	fake.handlers[procNetworkGetXMLDesc] = func(args []byte) []byte {
+		d := &xdrDecoder{buf: args}
+		var enc xdrEncoder
+		if d.object() == defaultNet {
+			enc.string("<network><forward mode='nat'/><bridge name='virbr0'/></network>")
+		} else {
+			enc.string("<network><bridge name='virbr1'/></network>")
+		}
+		return enc.buf.Bytes()
+	}
+
+	mac, hostname := "52:54:00:12:34:56", "noble"
+	var leases xdrEncoder
+	leases.uint32(1)
+	leases.string("virbr0")
+	leases.uint64(1714566600)
+	leases.int32(0) // ipv4
+	leases.optionalString(&mac)
+	leases.optionalString(nil)
+	leases.string("192.168.122.238")
+	leases.uint32(24)
+	leases.optionalString(&hostname)
+	leases.optionalString(nil)
+	leases.uint32(1)
+	fake.replies[procNetworkGetDHCPLeases] = leases.buf.Bytes()
+	// Like libvirtd, refuse arguments that are not net, mac, need_results,
+	// flags
+	fake.rejects[procNetworkGetDHCPLeases] = func(args []byte) *LibvirtError {
+		d := &xdrDecoder{buf: args}
+		d.object()
+		d.optionalString()
+		needResults := d.int32()
+		d.uint32()
+		if d.err != nil || len(d.buf) != 0 || needResults != 1 {
+			return &LibvirtError{Code: 8, Message: "malformed network leases call"}
+		}
+		return nil
+	}
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+
+	networks, err := collector.GetNetworks(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(networks) != 2 {
+		t.Fatalf("Expected 2 networks, got %+v", networks)
+	}
+
+	def := networks[0]
+	if !def.Active || def.Bridge != "virbr0" || def.ForwardMode != "nat" {
+		t.Errorf("Unexpected default network: %+v", def)
+	}
+	expected := DHCPLease{
+		MAC:      mac,
+		IP:       IPAddress{Address: "192.168.122.238", Prefix: 24, Family: "ipv4", Source: IPSourceLease},
+		Hostname: hostname,
+		Expiry:   time.Unix(1714566600, 0),
+	}
+	if len(def.Leases) != 1 || def.Leases[0] != expected {
+		t.Errorf("Expected lease %+v, got %+v", expected, def.Leases)
+	}
+
+	if priv := networks[1]; priv.Active || priv.ForwardMode != "isolated" || len(priv.Leases) != 0 {
+		t.Errorf("Expected an inactive isolated network without leases, got %+v", priv)
+	}
+	if n := len(fake.callsTo(procNetworkGetDHCPLeases)); n != 1 {
+		t.Errorf("Expected leases to be read for the active network only, got %d calls", n)
+	}
+}
+
+func TestLibvirtCollectorWatchEvents(t *testing.T) {
+	fake := newFakeLibvirtd(t)
+	fake.replies[procConnectDomainEventCallbackRegisterAny] = []byte{0, 0, 0, 1} // callbackID
+
+	var started, stopped xdrEncoder
+	started.int32(1)
+	started.domain(testDomain)
+	started.int32(2) // started
+	started.int32(0) // booted
+	stopped.int32(1)
+	stopped.domain(testDomain)
+	stopped.int32(5) // stopped
+	stopped.int32(1) // destroyed
+	event := func(body []byte) packet {
+		return packet{program: remoteProgram, version: remoteProtocolVersion, procedure: procDomainEventCallbackLifecycle, kind: packetTypeMessage, body: body}
+	}
+	fake.after[procConnectDomainEventCallbackRegisterAny] = []packet{event(started.buf.Bytes()), event(stopped.buf.Bytes())}
+
+	collector := NewLibvirtCollector("", fake.socket)
+	defer func() { _ = collector.Close() }()
+	collector.metadata.store("noble_default", DomainMetadata{UUID: "cached"}, time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := collector.WatchEvents(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []DomainEvent{
+		{Domain: "noble_default", Type: "Started", Detail: "Booted"},
+		{Domain: "noble_default", Type: "Stopped", Detail: "Destroyed"},
+	}
+	for _, want := range expected {
+		select {
+		case ev := <-events:
+			if ev.Time.IsZero() {
+				t.Error("Expected the event to be timestamped")
+			}
+			ev.Time = time.Time{}
+			if ev != want {
+				t.Errorf("Expected %+v, got %+v", want, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %+v", want)
+		}
+	}
+	if _, ok := collector.metadata.lookup("noble_default", time.Now(), time.Hour); ok {
+		t.Error("Expected the event to invalidate cached metadata")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected no more events after cancelling")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the event channel to close after cancelling")
+	}
+}
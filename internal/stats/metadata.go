@@ -0,0 +1,180 @@
+package stats
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetadataTTL is how long cached domain metadata is trusted when no
+// lifecycle change is seen
+const DefaultMetadataTTL = 10 * time.Minute
+
+type cachedMetadata struct {
+	metadata  DomainMetadata
+	fetchedAt time.Time
+	// config is parsed from the domain's live XML, nil until first read
+	config *DomainConfig
+}
+
+// metadataCache keeps static domain metadata across refreshes, keyed by
+// domain UUID. Entries expire after the TTL, when the domain's state changes
+// or when invalidated explicitly.
+type metadataCache struct {
+	mu     sync.Mutex
+	byUUID map[string]cachedMetadata
+	// byName resolves a domain name to its UUID for callers that only know
+	// the name until metadata has been fetched once
+	byName map[string]string
+	states map[string]int
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{
+		byUUID: make(map[string]cachedMetadata),
+		byName: make(map[string]string),
+		states: make(map[string]int),
+	}
+}
+
+// lookup returns metadata for a domain by name if it is younger than ttl; a
+// zero ttl never expires entries
+func (c *metadataCache) lookup(name string, now time.Time, ttl time.Duration) (DomainMetadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uuid, ok := c.byName[name]
+	if !ok {
+		return DomainMetadata{}, false
+	}
+	return c.lookupUUIDLocked(uuid, now, ttl)
+}
+
+// lookupUUID is lookup for callers that already know the domain UUID
+func (c *metadataCache) lookupUUID(uuid string, now time.Time, ttl time.Duration) (DomainMetadata, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookupUUIDLocked(uuid, now, ttl)
+}
+
+func (c *metadataCache) lookupUUIDLocked(uuid string, now time.Time, ttl time.Duration) (DomainMetadata, bool) {
+	entry, ok := c.byUUID[uuid]
+	if !ok || (ttl > 0 && now.Sub(entry.fetchedAt) > ttl) {
+		return DomainMetadata{}, false
+	}
+	return entry.metadata, true
+}
+
+func (c *metadataCache) store(name string, md DomainMetadata, now time.Time) {
+	if md.UUID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byName[name] = md.UUID
+	c.byUUID[md.UUID] = cachedMetadata{metadata: md, fetchedAt: now}
+}
+
+// lookupConfig returns the cached domain configuration, which shares the
+// lifetime of the domain's metadata entry
+func (c *metadataCache) lookupConfig(name string, now time.Time, ttl time.Duration) (DomainConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uuid, ok := c.byName[name]
+	if !ok {
+		return DomainConfig{}, false
+	}
+	entry, ok := c.byUUID[uuid]
+	if !ok || entry.config == nil || (ttl > 0 && now.Sub(entry.fetchedAt) > ttl) {
+		return DomainConfig{}, false
+	}
+	return *entry.config, true
+}
+
+// storeConfig caches the domain configuration. It is only kept once the
+// domain has a metadata entry, so it is dropped whenever that entry is.
+func (c *metadataCache) storeConfig(name string, cfg DomainConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	uuid, ok := c.byName[name]
+	if !ok {
+		return
+	}
+	if entry, ok := c.byUUID[uuid]; ok {
+		entry.config = &cfg
+		c.byUUID[uuid] = entry
+	}
+}
+
+// invalidate drops the cached metadata for a domain
+func (c *metadataCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if uuid, ok := c.byName[name]; ok {
+		delete(c.byUUID, uuid)
+	}
+	delete(c.byName, name)
+}
+
+// observe records the current state of every listed domain, invalidating
+// domains whose state changed since the last refresh and forgetting domains
+// that are no longer listed
+func (c *metadataCache) observe(vms []VMStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	present := make(map[string]bool, len(vms))
+	for _, vm := range vms {
+		present[vm.DomainName] = true
+		if last, ok := c.states[vm.DomainName]; ok && last != vm.State {
+			if uuid, ok := c.byName[vm.DomainName]; ok {
+				delete(c.byUUID, uuid)
+			}
+		}
+		c.states[vm.DomainName] = vm.State
+	}
+
+	for name := range c.states {
+		if present[name] {
+			continue
+		}
+		if uuid, ok := c.byName[name]; ok {
+			delete(c.byUUID, uuid)
+		}
+		delete(c.byName, name)
+		delete(c.states, name)
+	}
+}
+
+// parseDomInfo parses `virsh dominfo` output into domain metadata
+func parseDomInfo(output string) DomainMetadata {
+	var md DomainMetadata
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "UUID":
+			md.UUID = value
+		case "OS Type":
+			md.OSType = value
+		case "CPU(s)":
+			md.VCPUs, _ = strconv.Atoi(value)
+		case "Max memory":
+			md.MaxMemory, _ = strconv.ParseInt(strings.TrimSuffix(value, " KiB"), 10, 64)
+		case "Persistent":
+			md.Persistent = value == "yes"
+		case "Autostart":
+			md.Autostart = value == "enable"
+		}
+	}
+	return md
+}
@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDomInfo(t *testing.T) {
+	output := `Id:             3
+Name:           noble_default
+UUID:           6f3c1a52-8d7e-4e39-9a0b-2f1d5c7e8a90
+OS Type:        hvm
+State:          running
+CPU(s):         4
+CPU time:       79.7s
+Max memory:     16777216 KiB
+Used memory:    16777216 KiB
+Persistent:     yes
+Autostart:      enable
+Managed save:   no
+Security model: apparmor
+Security DOI:   0
+`
+
+	md := parseDomInfo(output)
+	expected := DomainMetadata{
+		UUID:       "6f3c1a52-8d7e-4e39-9a0b-2f1d5c7e8a90",
+		OSType:     "hvm",
+		Autostart:  true,
+		Persistent: true,
+		MaxMemory:  16777216,
+		VCPUs:      4,
+	}
+	if md != expected {
+		t.Errorf("Expected %+v, got %+v", expected, md)
+	}
+}
+
+func TestMetadataCacheTTL(t *testing.T) {
+	cache := newMetadataCache()
+	now := time.Now()
+	cache.store("vm1", DomainMetadata{UUID: "u1", OSType: "hvm"}, now)
+
+	if md, ok := cache.lookup("vm1", now.Add(time.Minute), 10*time.Minute); !ok || md.OSType != "hvm" {
+		t.Errorf("Expected cached metadata within TTL, got %+v (ok=%v)", md, ok)
+	}
+	if _, ok := cache.lookupUUID("u1", now.Add(time.Minute), 10*time.Minute); !ok {
+		t.Error("Expected cached metadata by UUID within TTL")
+	}
+	if _, ok := cache.lookup("vm1", now.Add(11*time.Minute), 10*time.Minute); ok {
+		t.Error("Expected metadata to expire after TTL")
+	}
+	if _, ok := cache.lookup("vm1", now.Add(24*time.Hour), 0); !ok {
+		t.Error("Expected a zero TTL to never expire")
+	}
+}
+
+func TestMetadataCacheLifecycle(t *testing.T) {
+	cache := newMetadataCache()
+	now := time.Now()
+	cache.observe([]VMStats{{DomainName: "vm1", State: 5}, {DomainName: "vm2", State: 1}})
+	cache.store("vm1", DomainMetadata{UUID: "u1"}, now)
+	cache.store("vm2", DomainMetadata{UUID: "u2"}, now)
+
+	// vm1 started: its metadata is re-read; vm2 is unchanged
+	cache.observe([]VMStats{{DomainName: "vm1", State: 1}, {DomainName: "vm2", State: 1}})
+	if _, ok := cache.lookup("vm1", now, 0); ok {
+		t.Error("Expected state change to invalidate vm1")
+	}
+	if _, ok := cache.lookup("vm2", now, 0); !ok {
+		t.Error("Expected vm2 to stay cached")
+	}
+
+	// vm2 undefined: it is forgotten
+	cache.observe([]VMStats{{DomainName: "vm1", State: 1}})
+	if _, ok := cache.lookup("vm2", now, 0); ok {
+		t.Error("Expected removed domain to be forgotten")
+	}
+
+	cache.store("vm1", DomainMetadata{UUID: "u1"}, now)
+	cache.invalidate("vm1")
+	if _, ok := cache.lookup("vm1", now, 0); ok {
+		t.Error("Expected explicit invalidation to drop vm1")
+	}
+}
+
+func TestMetadataCacheConfig(t *testing.T) {
+	cache := newMetadataCache()
+	now := time.Now()
+
+	// Config is only cached once the domain has a metadata entry
+	cache.storeConfig("vm1", DomainConfig{VCPUs: 2})
+	if _, ok := cache.lookupConfig("vm1", now, time.Minute); ok {
+		t.Error("Expected no config before metadata is stored")
+	}
+
+	cache.store("vm1", DomainMetadata{UUID: "u1"}, now)
+	cache.storeConfig("vm1", DomainConfig{VCPUs: 2})
+	if cfg, ok := cache.lookupConfig("vm1", now, time.Minute); !ok || cfg.VCPUs != 2 {
+		t.Errorf("Expected cached config, got %+v (ok=%v)", cfg, ok)
+	}
+	if _, ok := cache.lookupConfig("vm1", now.Add(2*time.Minute), time.Minute); ok {
+		t.Error("Expected config to expire with the metadata TTL")
+	}
+
+	// A state change drops it together with the metadata
+	cache.observe([]VMStats{{DomainName: "vm1", State: 1}})
+	cache.observe([]VMStats{{DomainName: "vm1", State: 5}})
+	if _, ok := cache.lookupConfig("vm1", now, time.Minute); ok {
+		t.Error("Expected config to be dropped after a state change")
+	}
+}
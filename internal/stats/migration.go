@@ -0,0 +1,176 @@
+package stats
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMigrationBandwidth is the assumed migration link speed in MiB/s,
+// roughly what a 1 Gbit/s link sustains
+const DefaultMigrationBandwidth = 110.0
+
+// dirtyRateWindow is the measurement window, in seconds, of each dirty page
+// rate calculation
+const dirtyRateWindow = 1
+
+// Dirty rate calculation states (virDomainDirtyRateStatus)
+const (
+	DirtyRateUnstarted = 0
+	DirtyRateMeasuring = 1
+	DirtyRateMeasured  = 2
+)
+
+// DirtyRateStats holds the result of the last dirty page rate calculation
+type DirtyRateStats struct {
+	// Collected is set when dirty rate collection is enabled
+	Collected bool
+	Status    int
+	StartTime int64 // Unix seconds
+	Period    int64 // seconds
+	MiBPerSec int64
+	Mode      string
+}
+
+// MemoryBandwidthMonitor holds the memory bandwidth counters of one resctrl
+// monitor, which covers a set of vCPUs
+type MemoryBandwidthMonitor struct {
+	Name  string
+	VCPUs string
+	Nodes []MemoryBandwidthNode
+}
+
+// MemoryBandwidthNode holds memory bandwidth counters for one NUMA node
+type MemoryBandwidthNode struct {
+	ID         int
+	BytesLocal int64
+	BytesTotal int64
+
+	// Rates over the last sample interval
+	LocalRate float64 // bytes/s
+	TotalRate float64 // bytes/s
+}
+
+// parseDirtyRateStat parses dirtyrate.* fields
+func parseDirtyRateStat(key, value string, stats *VMStats) {
+	val, _ := strconv.ParseInt(value, 10, 64)
+	switch key {
+	case "dirtyrate.calc_status":
+		stats.DirtyRate.Status = int(val)
+	case "dirtyrate.calc_start_time":
+		stats.DirtyRate.StartTime = val
+	case "dirtyrate.calc_period":
+		stats.DirtyRate.Period = val
+	case "dirtyrate.megabytes_per_second":
+		stats.DirtyRate.MiBPerSec = val
+	case "dirtyrate.calc_mode":
+		stats.DirtyRate.Mode = value
+	}
+}
+
+// parseMemoryBandwidthStat parses memory.bandwidth.monitor.* fields, e.g.
+// memory.bandwidth.monitor.0.node.1.bytes.total
+func parseMemoryBandwidthStat(key, value string, stats *VMStats) {
+	parts := strings.Split(strings.TrimPrefix(key, "memory.bandwidth.monitor."), ".")
+	if len(parts) < 2 {
+		return
+	}
+
+	monID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return
+	}
+	for len(stats.MemoryBandwidth) <= monID {
+		stats.MemoryBandwidth = append(stats.MemoryBandwidth, MemoryBandwidthMonitor{})
+	}
+	mon := &stats.MemoryBandwidth[monID]
+
+	switch parts[1] {
+	case "name":
+		mon.Name = value
+	case "vcpus":
+		mon.VCPUs = value
+	case "node":
+		if len(parts) < 4 {
+			return
+		}
+		nodeID, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return
+		}
+		for len(mon.Nodes) <= nodeID {
+			mon.Nodes = append(mon.Nodes, MemoryBandwidthNode{})
+		}
+		node := &mon.Nodes[nodeID]
+		val, _ := strconv.ParseInt(value, 10, 64)
+
+		switch strings.Join(parts[3:], ".") {
+		case "id":
+			node.ID = int(val)
+		case "bytes.local":
+			node.BytesLocal = val
+		case "bytes.total":
+			node.BytesTotal = val
+		}
+	}
+}
+
+// MigrationEstimate is a rough forecast of a pre-copy live migration
+type MigrationEstimate struct {
+	// Difficulty is "easy", "moderate", "hard" or "won't converge"
+	Difficulty string
+	// Ratio is the dirty rate as a fraction of the migration bandwidth
+	Ratio float64
+	// Converges reports whether pre-copy can catch up without
+	// auto-converge or post-copy
+	Converges bool
+	// Duration is the expected time to copy memory, 0 if it never converges
+	Duration time.Duration
+}
+
+// EstimateMigration forecasts a pre-copy migration of memoryKiB of guest RAM
+// dirtied at dirtyMiBps over a link of bandwidthMiBps. Each pre-copy pass
+// resends what was dirtied during the previous one, so the total transfer
+// is the geometric series M/B * 1/(1 - D/B).
+func EstimateMigration(memoryKiB int64, dirtyMiBps, bandwidthMiBps float64) MigrationEstimate {
+	if bandwidthMiBps <= 0 {
+		return MigrationEstimate{}
+	}
+
+	est := MigrationEstimate{Ratio: dirtyMiBps / bandwidthMiBps}
+	switch {
+	case est.Ratio < 0.1:
+		est.Difficulty = "easy"
+	case est.Ratio < 0.5:
+		est.Difficulty = "moderate"
+	case est.Ratio < 1:
+		est.Difficulty = "hard"
+	default:
+		est.Difficulty = "won't converge"
+		return est
+	}
+
+	est.Converges = true
+	firstPass := float64(memoryKiB) / 1024 / bandwidthMiBps
+	est.Duration = time.Duration(firstPass / (1 - est.Ratio) * float64(time.Second))
+	return est
+}
+
+// dirtyRateSchedule spaces out dirty rate calculations, which each occupy a
+// measurement window in the guest
+type dirtyRateSchedule struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// due reports whether a new calculation should start, and if so records it
+func (s *dirtyRateSchedule) due(now time.Time, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.last.IsZero() && now.Sub(s.last) < interval {
+		return false
+	}
+	s.last = now
+	return true
+}
@@ -0,0 +1,98 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDirtyRateAndMemoryBandwidth(t *testing.T) {
+	output := `Domain: 'db'
+  dirtyrate.calc_status=2
+  dirtyrate.calc_start_time=1700000000
+  dirtyrate.calc_period=1
+  dirtyrate.megabytes_per_second=412
+  dirtyrate.calc_mode=page-sampling
+  memory.bandwidth.monitor.count=1
+  memory.bandwidth.monitor.0.name=vcpus_0-3
+  memory.bandwidth.monitor.0.vcpus=0-3
+  memory.bandwidth.monitor.0.node.count=2
+  memory.bandwidth.monitor.0.node.0.id=0
+  memory.bandwidth.monitor.0.node.0.bytes.local=1000
+  memory.bandwidth.monitor.0.node.0.bytes.total=1500
+  memory.bandwidth.monitor.0.node.1.id=1
+  memory.bandwidth.monitor.0.node.1.bytes.local=2000
+  memory.bandwidth.monitor.0.node.1.bytes.total=2500
+`
+
+	allStats, err := parseVirshOutput(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	vm := allStats[0]
+
+	expected := DirtyRateStats{
+		Status:    DirtyRateMeasured,
+		StartTime: 1700000000,
+		Period:    1,
+		MiBPerSec: 412,
+		Mode:      "page-sampling",
+	}
+	if vm.DirtyRate != expected {
+		t.Errorf("Expected dirty rate %+v, got %+v", expected, vm.DirtyRate)
+	}
+
+	if len(vm.MemoryBandwidth) != 1 {
+		t.Fatalf("Expected 1 memory bandwidth monitor, got %d", len(vm.MemoryBandwidth))
+	}
+	mon := vm.MemoryBandwidth[0]
+	if mon.Name != "vcpus_0-3" || mon.VCPUs != "0-3" || len(mon.Nodes) != 2 {
+		t.Fatalf("Unexpected monitor: %+v", mon)
+	}
+	if mon.Nodes[1].ID != 1 || mon.Nodes[1].BytesLocal != 2000 || mon.Nodes[1].BytesTotal != 2500 {
+		t.Errorf("Unexpected node 1: %+v", mon.Nodes[1])
+	}
+}
+
+func TestEstimateMigration(t *testing.T) {
+	tests := []struct {
+		dirty      float64
+		difficulty string
+		converges  bool
+	}{
+		{5, "easy", true},
+		{40, "moderate", true},
+		{80, "hard", true},
+		{150, "won't converge", false},
+	}
+
+	// 8 GiB over a 100 MiB/s link
+	for _, tt := range tests {
+		est := EstimateMigration(8*1024*1024, tt.dirty, 100)
+		if est.Difficulty != tt.difficulty || est.Converges != tt.converges {
+			t.Errorf("dirty %.0f MiB/s: expected %s (converges=%v), got %+v", tt.dirty, tt.difficulty, tt.converges, est)
+		}
+	}
+
+	// Half the bandwidth lost to re-dirtied pages doubles the first pass
+	if est := EstimateMigration(8*1024*1024, 50, 100); est.Duration != 2*81920*time.Millisecond {
+		t.Errorf("Expected %s, got %s", 2*81920*time.Millisecond, est.Duration)
+	}
+	if est := EstimateMigration(1024, 1, 0); est.Difficulty != "" {
+		t.Errorf("Expected no estimate without bandwidth, got %+v", est)
+	}
+}
+
+func TestDirtyRateSchedule(t *testing.T) {
+	var s dirtyRateSchedule
+	now := time.Now()
+
+	if !s.due(now, 30*time.Second) {
+		t.Error("Expected the first calculation to be due")
+	}
+	if s.due(now.Add(10*time.Second), 30*time.Second) {
+		t.Error("Expected no calculation within the interval")
+	}
+	if !s.due(now.Add(31*time.Second), 30*time.Second) {
+		t.Error("Expected a calculation after the interval")
+	}
+}
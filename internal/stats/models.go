@@ -1,26 +1,199 @@
 package stats
 
+import "time"
+
 // VMStats holds all the statistics for a domain
 type VMStats struct {
-	DomainName     string
-	OSType         string
-	BalloonStats   BalloonStats
-	VCPUStats      []VCPUStats
-	BlockStats     []BlockStats
-	InterfaceStats []InterfaceStats
-	State          int
-	StateReason    int
-	LastUpdate     int64
-}
-
-// BalloonStats holds memory statistics
+	DomainName      string
+	Host            string
+	OSType          string
+	Metadata        DomainMetadata
+	Config          DomainConfig
+	BalloonStats    BalloonStats
+	CPU             CPUStats
+	Perf            PerfStats
+	DirtyRate       DirtyRateStats
+	MemoryBandwidth []MemoryBandwidthMonitor
+	Cgroup          CgroupStats
+	VCPUStats       []VCPUStats
+	IOThreadStats   []IOThreadStats
+	BlockStats      []BlockStats
+	InterfaceStats  []InterfaceStats
+	State           int
+	StateReason     int
+	LastUpdate      int64
+
+	// ID is libvirt's domain ID, which changes every time the domain
+	// starts; 0 if unknown, as with the qmp collector, -1 while inactive
+	ID int
+	// PID is the QEMU process, 0 if unknown. Only the qmp collector
+	// reads it.
+	PID int
+	// Restarted is set when the domain was started again since the
+	// previous sample, so no rates could be derived
+	Restarted bool
+
+	// HostCPUs is the number of CPUs on the hypervisor, 0 if unknown
+	HostCPUs int
+}
+
+// DomainMetadata holds static domain properties that only change when the
+// domain is redefined
+type DomainMetadata struct {
+	UUID       string
+	OSType     string
+	Autostart  bool
+	Persistent bool
+	MaxMemory  int64 // KiB
+	VCPUs      int
+}
+
+// DomainConfig holds the domain's configuration as read from its live XML
+type DomainConfig struct {
+	VCPUs    int // currently enabled
+	MaxVCPUs int
+	CPUMode  string // host-passthrough, host-model, custom
+	CPUModel string
+	Sockets  int
+	Dies     int
+	Cores    int
+	Threads  int
+
+	HugePages    bool
+	HugePageSize int64 // KiB, 0 for the host default
+
+	Disks      []DiskConfig
+	Interfaces []InterfaceConfig
+	Graphics   []GraphicsConfig
+}
+
+// DiskConfig describes a configured disk
+type DiskConfig struct {
+	Target   string // vda, sda, ...
+	Device   string // disk, cdrom, floppy, lun
+	Bus      string
+	Format   string // raw, qcow2, ...
+	Cache    string
+	IOThread int
+	Source   string // file, block device, pool/volume or network name
+}
+
+// InterfaceConfig describes a configured network interface
+type InterfaceConfig struct {
+	Target  string // host-side device, empty while shut off
+	Type    string // network, bridge, direct, ...
+	MAC     string
+	Model   string
+	Bridge  string // bridge, or parent device for direct interfaces
+	Network string
+}
+
+// GraphicsConfig describes a graphical console
+type GraphicsConfig struct {
+	Type     string // vnc, spice
+	Port     int    // -1 until assigned
+	TLSPort  int
+	AutoPort bool
+	Listen   string
+}
+
+// BalloonStats holds memory statistics. Sizes are in KiB. The guest-reported
+// fields (swap, faults, caches) are only present when the balloon driver has a
+// stats period set, in which case LastUpdate is non-zero.
 type BalloonStats struct {
-	Current   int64
-	Maximum   int64
-	Unused    int64
-	Available int64
-	Usable    int64
-	RSS       int64
+	Current        int64
+	Maximum        int64
+	Unused         int64
+	Available      int64
+	Usable         int64
+	RSS            int64
+	SwapIn         int64
+	SwapOut        int64
+	MajorFault     int64
+	MinorFault     int64
+	DiskCaches     int64
+	HugetlbPgAlloc int64
+	HugetlbPgFail  int64
+	LastUpdate     int64 // Unix seconds of the guest's last report
+
+	// Rates over the last sample interval
+	SwapInRate     float64 // KiB/s
+	SwapOutRate    float64 // KiB/s
+	MajorFaultRate float64 // faults/s
+}
+
+// Used returns the guest memory in use (KiB). Page cache is reclaimable, so
+// it is not counted as used.
+func (b BalloonStats) Used() int64 {
+	return max(b.Current-b.Unused-b.DiskCaches, 0)
+}
+
+// CPUStats holds whole-domain CPU time, which covers the vCPUs as well as
+// the QEMU emulator and I/O threads
+type CPUStats struct {
+	Time   int64 // ns
+	User   int64 // ns
+	System int64 // ns
+
+	// Usage is the domain's share of all host CPUs, in %
+	Usage float64
+	// OverheadUsage is CPU time spent outside the vCPUs, in % of one host CPU
+	OverheadUsage float64
+}
+
+// CPUOverhead returns the CPU time (ns) the domain spent outside its vCPUs,
+// i.e. cpu.time minus the sum of vCPU time
+func (s *VMStats) CPUOverhead() int64 {
+	overhead := s.CPU.Time
+	for _, vcpu := range s.VCPUStats {
+		overhead -= vcpu.Time
+	}
+	if overhead < 0 {
+		return 0
+	}
+	return overhead
+}
+
+// MemoryUsage returns the share of memory in use, in %: the guest's own
+// view when it reports balloon stats, else the cgroup's charge against its
+// limit. ok is false when neither is known.
+func (s *VMStats) MemoryUsage() (percent float64, ok bool) {
+	switch {
+	case s.BalloonStats.LastUpdate > 0 && s.BalloonStats.Current > 0:
+		return float64(s.BalloonStats.Used()) / float64(s.BalloonStats.Current) * 100, true
+	case s.Cgroup.MemoryMax > 0:
+		return float64(s.Cgroup.MemoryCurrent) / float64(s.Cgroup.MemoryMax) * 100, true
+	}
+	return 0, false
+}
+
+// PerfStats holds the domain's perf event counters. Only events enabled on
+// the domain (virsh perf --enable) are reported; the rest stay zero.
+type PerfStats struct {
+	// Collected is set when the perf group was requested and the host
+	// supports it, even if the domain has no events enabled
+	Collected          bool
+	CPUCycles          int64
+	Instructions       int64
+	CacheReferences    int64
+	CacheMisses        int64
+	BranchInstructions int64
+	BranchMisses       int64
+	ContextSwitches    int64
+	CPUMigrations      int64
+
+	// Rates over the last sample interval
+	IPC               float64 // instructions per cycle
+	CacheMissRate     float64 // % of cache references
+	ContextSwitchRate float64 // per second
+}
+
+// IOThreadStats holds the adaptive polling settings of one IOThread
+type IOThreadStats struct {
+	ID         int
+	PollMaxNs  int64
+	PollGrow   int64
+	PollShrink int64
 }
 
 // VCPUStats holds stats for a single virtual CPU
@@ -28,11 +201,19 @@ type VCPUStats struct {
 	ID        int
 	State     int
 	Time      int64
+	Wait      int64 // ns the vCPU wanted to run but something else ran
+	Delay     int64 // ns spent queued instead of running (guest steal time)
 	Exits     int64
 	HaltExits int64
 	IRQExits  int64
 	IOExits   int64
 	Usage     float64
+	WaitUsage float64 // % of wall time, from Wait
+	Steal     float64 // % of wall time, from Delay
+	ExitRate  float64 // exits/s
+	// Reset is set when the counters went backwards since the previous
+	// sample, leaving the rates at zero
+	Reset bool
 }
 
 // BlockStats holds stats for a block device
@@ -41,16 +222,40 @@ type BlockStats struct {
 	Path       string
 	ReadReqs   int64
 	ReadBytes  int64
+	ReadTime   int64 // ns spent on reads
 	WriteReqs  int64
 	WriteBytes int64
+	WriteTime  int64 // ns spent on writes
+	FlushReqs  int64
+	FlushTime  int64 // ns spent on flushes
 	Allocation int64
 	Capacity   int64
 	Physical   int64
+	// Configuration joined from the domain XML
+	Bus    string
+	Format string
+	Cache  string
+	// IOThread is the IOThread serving the disk, 0 for the main loop
+	IOThread int
+
+	// Rates over the last sample interval
+	ReadIOPS       float64
+	WriteIOPS      float64
+	FlushIOPS      float64
+	ReadBytesRate  float64 // bytes/s
+	WriteBytesRate float64 // bytes/s
+	ReadLatency    time.Duration
+	WriteLatency   time.Duration
+	FlushLatency   time.Duration
+	// Reset is set when the counters went backwards since the previous
+	// sample, leaving the rates at zero
+	Reset bool
 }
 
 // InterfaceStats holds stats for a network interface
 type InterfaceStats struct {
 	Name      string
+	MAC       string
 	RxBytes   int64
 	RxPackets int64
 	TxBytes   int64
@@ -59,5 +264,31 @@ type InterfaceStats struct {
 	RxErrs    int64
 	TxDrop    int64
 	TxErrs    int64
-	IPs       []string
+	IPs       []IPAddress
+
+	// Configuration joined from the domain XML
+	Model   string
+	Bridge  string
+	Network string
+
+	// Rates over the last sample interval
+	RxBytesRate   float64 // bytes/s
+	TxBytesRate   float64 // bytes/s
+	RxPacketsRate float64 // packets/s
+	TxPacketsRate float64 // packets/s
+	RxErrsRate    float64 // errors/s
+	TxErrsRate    float64 // errors/s
+	RxDropRate    float64 // drops/s
+	TxDropRate    float64 // drops/s
+	// Reset is set when the counters went backwards since the previous
+	// sample, leaving the rates at zero
+	Reset bool
+}
+
+// IPAddress is a guest address discovered on an interface
+type IPAddress struct {
+	Address string
+	Prefix  int
+	Family  string // "ipv4" or "ipv6"
+	Source  string // IPSourceLease, IPSourceAgent or IPSourceARP
 }
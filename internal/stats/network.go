@@ -0,0 +1,148 @@
+package stats
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultNetworkInterval is how often virtual networks and their DHCP
+// leases are listed for the networks view
+const DefaultNetworkInterval = 30 * time.Second
+
+// NetworkCollector is implemented by collectors that can list the host's
+// libvirt virtual networks
+type NetworkCollector interface {
+	GetNetworks(ctx context.Context) ([]Network, error)
+}
+
+// Network is a libvirt virtual network and its DHCP leases
+type Network struct {
+	Name        string
+	Active      bool
+	Bridge      string
+	ForwardMode string // nat, route, bridge, open, ... or isolated
+	Leases      []DHCPLease
+}
+
+// DHCPLease is an address handed out by a network's DHCP server
+type DHCPLease struct {
+	MAC      string
+	IP       IPAddress
+	Hostname string
+	Expiry   time.Time
+}
+
+// networkXML is the subset of the network XML that vmstats reads
+type networkXML struct {
+	Forward *struct {
+		Mode string `xml:"mode,attr"`
+	} `xml:"forward"`
+	Bridge struct {
+		Name string `xml:"name,attr"`
+	} `xml:"bridge"`
+}
+
+// parseNetworkXML fills in a network's bridge and forward mode. A network
+// without a forward element is isolated; one without a mode is NAT.
+func parseNetworkXML(xmlDesc string, network *Network) error {
+	var doc networkXML
+	if err := xml.Unmarshal([]byte(xmlDesc), &doc); err != nil {
+		return fmt.Errorf("failed to parse network XML: %w", err)
+	}
+	network.Bridge = doc.Bridge.Name
+	switch {
+	case doc.Forward == nil:
+		network.ForwardMode = "isolated"
+	case doc.Forward.Mode == "":
+		network.ForwardMode = "nat"
+	default:
+		network.ForwardMode = doc.Forward.Mode
+	}
+	return nil
+}
+
+// parseNetInfo reads whether a network is active from `virsh net-info`
+func parseNetInfo(output string, network *Network) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) == "Active" {
+			network.Active = strings.TrimSpace(value) == "yes"
+		}
+	}
+}
+
+// parseDHCPLeases parses `virsh net-dhcp-leases` output
+func parseDHCPLeases(output string) []DHCPLease {
+	var leases []DHCPLease
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "Expiry") || strings.HasPrefix(line, "-") {
+			continue
+		}
+
+		// Expected format: date time MAC protocol address hostname [client ID]
+		// 2024-05-01 12:30:00  52:54:00:12:34:56  ipv4  192.168.122.238/24  noble  ff:...
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			continue
+		}
+
+		expiry, _ := time.ParseInLocation("2006-01-02 15:04:05", fields[0]+" "+fields[1], time.Local)
+		address, prefix := fields[4], 0
+		if idx := strings.Index(address, "/"); idx != -1 {
+			prefix, _ = strconv.Atoi(address[idx+1:])
+			address = address[:idx]
+		}
+		hostname := fields[5]
+		if hostname == "-" {
+			hostname = ""
+		}
+
+		leases = append(leases, DHCPLease{
+			MAC:      fields[2],
+			IP:       IPAddress{Address: address, Prefix: prefix, Family: fields[3], Source: IPSourceLease},
+			Hostname: hostname,
+			Expiry:   expiry,
+		})
+	}
+	return leases
+}
+
+// leaseTable indexes DHCP leases from every network by MAC so domain IPs can
+// be resolved without asking libvirt once per domain. A nil table means the
+// leases could not be listed.
+type leaseTable map[string][]discoveredAddr
+
+func newLeaseTable(leases []DHCPLease) leaseTable {
+	table := leaseTable{}
+	for _, l := range leases {
+		mac := strings.ToLower(l.MAC)
+		table[mac] = append(table[mac], discoveredAddr{mac: l.MAC, addr: l.IP})
+	}
+	return table
+}
+
+// lookup returns the leased addresses of the domain's interfaces
+func (t leaseTable) lookup(vm *VMStats) []discoveredAddr {
+	var addrs []discoveredAddr
+	for _, iface := range vm.InterfaceStats {
+		if iface.MAC != "" {
+			addrs = append(addrs, t[strings.ToLower(iface.MAC)]...)
+		}
+	}
+	return addrs
+}
+
+// wantsLeases reports whether lease addresses are among the IP sources
+func wantsLeases(sources []string) bool {
+	for _, source := range sources {
+		if source == IPSourceLease {
+			return true
+		}
+	}
+	return false
+}
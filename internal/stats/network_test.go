@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDHCPLeases(t *testing.T) {
+	output := ` Expiry Time           MAC address         Protocol   IP address           Hostname   Client ID or DUID
+------------------------------------------------------------------------------------------------------------
+ 2024-05-01 12:30:00   52:54:00:12:34:56   ipv4       192.168.122.238/24   noble      ff:00:12:34:56
+ 2024-05-01 13:00:00   52:54:00:ab:cd:ef   ipv6       fd00::5/64           -          00:04:aa:bb
+`
+
+	leases := parseDHCPLeases(output)
+	if len(leases) != 2 {
+		t.Fatalf("Expected 2 leases, got %+v", leases)
+	}
+
+	expiry := time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)
+	expected := DHCPLease{
+		MAC:      "52:54:00:12:34:56",
+		IP:       IPAddress{Address: "192.168.122.238", Prefix: 24, Family: "ipv4", Source: IPSourceLease},
+		Hostname: "noble",
+		Expiry:   expiry,
+	}
+	if !leases[0].Expiry.Equal(expiry) || leases[0].MAC != expected.MAC || leases[0].IP != expected.IP || leases[0].Hostname != expected.Hostname {
+		t.Errorf("Expected %+v, got %+v", expected, leases[0])
+	}
+	if leases[1].Hostname != "" || leases[1].IP.Family != "ipv6" || leases[1].IP.Prefix != 64 {
+		t.Errorf("Expected an ipv6 lease without hostname, got %+v", leases[1])
+	}
+}
+
+func TestParseNetworkXML(t *testing.T) {
+	tests := []struct {
+		xml     string
+		bridge  string
+		forward string
+	}{
+		{`<network><name>default</name><forward mode='nat'/><bridge name='virbr0'/></network>`, "virbr0", "nat"},
+		{`<network><name>legacy</name><forward/><bridge name='virbr1'/></network>`, "virbr1", "nat"},
+		{`<network><name>private</name><bridge name='virbr2'/></network>`, "virbr2", "isolated"},
+		{`<network><name>host</name><forward mode='bridge'/></network>`, "", "bridge"},
+	}
+	for _, tt := range tests {
+		var n Network
+		if err := parseNetworkXML(tt.xml, &n); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if n.Bridge != tt.bridge || n.ForwardMode != tt.forward {
+			t.Errorf("Expected bridge %q forward %q, got %q %q", tt.bridge, tt.forward, n.Bridge, n.ForwardMode)
+		}
+	}
+
+	var n Network
+	parseNetInfo("Name:           default\nActive:         yes\nBridge:         virbr0\n", &n)
+	if !n.Active {
+		t.Error("Expected the network to be active")
+	}
+}
+
+func TestLeaseTableLookup(t *testing.T) {
+	table := newLeaseTable([]DHCPLease{
+		{MAC: "52:54:00:12:34:56", IP: IPAddress{Address: "192.168.122.238", Prefix: 24, Family: "ipv4", Source: IPSourceLease}},
+		{MAC: "52:54:00:99:99:99", IP: IPAddress{Address: "192.168.122.99", Prefix: 24, Family: "ipv4", Source: IPSourceLease}},
+	})
+
+	vm := VMStats{InterfaceStats: []InterfaceStats{{Name: "vnet0", MAC: "52:54:00:12:34:56"}, {Name: "vnet1"}}}
+	addrs := table.lookup(&vm)
+	if assigned := assignAddresses(&vm, addrs); assigned != 1 {
+		t.Fatalf("Expected 1 address assigned, got %d", assigned)
+	}
+	if ips := vm.InterfaceStats[0].IPs; len(ips) != 1 || ips[0].Address != "192.168.122.238" {
+		t.Errorf("Expected vnet0 to get its lease, got %+v", ips)
+	}
+	if len(vm.InterfaceStats[1].IPs) != 0 {
+		t.Errorf("Expected vnet1 without a MAC to get nothing, got %+v", vm.InterfaceStats[1].IPs)
+	}
+}
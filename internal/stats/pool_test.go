@@ -0,0 +1,53 @@
+package stats
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunParallelBounded(t *testing.T) {
+	var running, peak int32
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+
+	runParallel(context.Background(), 20, 3, func(i int) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+
+		mu.Lock()
+		seen[i] = true
+		mu.Unlock()
+	})
+
+	if len(seen) != 20 {
+		t.Errorf("Expected 20 indexes to run, got %d", len(seen))
+	}
+	if peak > 3 {
+		t.Errorf("Expected at most 3 concurrent workers, got %d", peak)
+	}
+}
+
+func TestRunParallelCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var calls int32
+	runParallel(ctx, 100, 2, func(i int) {
+		atomic.AddInt32(&calls, 1)
+	})
+
+	// Workers may pick up a job that raced with cancellation, but not all
+	if calls > 2 {
+		t.Errorf("Expected cancelled run to skip remaining jobs, got %d calls", calls)
+	}
+}
@@ -0,0 +1,378 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProcRoot is where the QMP collector reads QEMU thread CPU times
+const DefaultProcRoot = "/proc"
+
+// userHZ is the unit of CPU times in /proc/<pid>/stat
+const userHZ = 100
+
+// QMPCollector collects stats from QEMU processes not managed by libvirt by
+// talking to their QMP monitor sockets directly (qemu -qmp unix:PATH,server).
+// Domains are named after the VM's -name, or the socket file without its
+// extension when no name is set.
+type QMPCollector struct {
+	// SocketGlob matches the QMP sockets to query, e.g. /run/qemu/*.qmp
+	SocketGlob string
+	// Timeout bounds the whole exchange with one QEMU
+	Timeout time.Duration
+	// Workers bounds how many sockets are queried concurrently
+	Workers int
+	// ProcRoot is read for vCPU and process CPU times
+	ProcRoot string
+}
+
+// NewQMPCollector creates a collector for the QMP sockets matching glob
+func NewQMPCollector(glob string) *QMPCollector {
+	return &QMPCollector{
+		SocketGlob: glob,
+		Timeout:    DefaultCommandTimeout,
+		Workers:    DefaultWorkers,
+		ProcRoot:   DefaultProcRoot,
+	}
+}
+
+// GetVMStats queries every QEMU whose socket matches the glob. Sockets that
+// cannot be queried, such as ones left behind by an exited QEMU, are
+// skipped; an error is only returned when none could be.
+func (c *QMPCollector) GetVMStats(ctx context.Context, domains []string) ([]VMStats, error) {
+	sockets, err := filepath.Glob(c.SocketGlob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list QMP sockets: %w", err)
+	}
+	if len(sockets) == 0 {
+		return nil, nil
+	}
+
+	results := make([]VMStats, len(sockets))
+	errs := make([]error, len(sockets))
+	runParallel(ctx, len(sockets), c.Workers, func(i int) {
+		results[i], errs[i] = c.queryDomain(ctx, sockets[i])
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		wanted[d] = true
+	}
+
+	var vms []VMStats
+	var firstErr error
+	for i, vm := range results {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		if len(wanted) == 0 || wanted[vm.DomainName] {
+			vms = append(vms, vm)
+		}
+	}
+	if vms == nil && firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(vms, func(i, j int) bool { return vms[i].DomainName < vms[j].DomainName })
+	return vms, nil
+}
+
+// queryDomain runs the QMP queries against one QEMU
+func (c *QMPCollector) queryDomain(ctx context.Context, socket string) (VMStats, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	client, err := dialQMP(ctx, socket)
+	if err != nil {
+		return VMStats{}, err
+	}
+	defer client.Close()
+
+	vm := VMStats{HostCPUs: runtime.NumCPU()}
+
+	var name struct {
+		Name string `json:"name"`
+	}
+	if err := client.execute("query-name", &name); err != nil {
+		return vm, err
+	}
+	vm.DomainName = name.Name
+	if vm.DomainName == "" {
+		vm.DomainName = strings.TrimSuffix(filepath.Base(socket), filepath.Ext(socket))
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := client.execute("query-status", &status); err != nil {
+		return vm, err
+	}
+	vm.State = qmpRunState(status.Status)
+
+	var cpus []struct {
+		CPUIndex int `json:"cpu-index"`
+		ThreadID int `json:"thread-id"`
+	}
+	if err := client.execute("query-cpus-fast", &cpus); err != nil {
+		return vm, err
+	}
+	for _, cpu := range cpus {
+		vcpu := VCPUStats{ID: cpu.CPUIndex, State: 1}
+		if t, ok := c.threadCPUTime(cpu.ThreadID); ok {
+			vcpu.Time = t
+		}
+		vm.VCPUStats = append(vm.VCPUStats, vcpu)
+	}
+
+	// The QEMU process owns the vCPU threads
+	if len(cpus) > 0 {
+		if pid, ok := c.threadGroup(cpus[0].ThreadID); ok {
+			vm.PID = pid
+			if t, ok := c.threadCPUTime(pid); ok {
+				vm.CPU.Time = t
+			}
+		}
+	}
+
+	var blocks []qmpBlockStats
+	if err := client.execute("query-blockstats", &blocks); err != nil {
+		return vm, err
+	}
+	for _, b := range blocks {
+		if name := b.name(); name != "" {
+			vm.BlockStats = append(vm.BlockStats, b.toBlockStats(name))
+		}
+	}
+
+	// Without a balloon device QEMU answers DeviceNotActive
+	var balloon struct {
+		Actual int64 `json:"actual"`
+	}
+	if err := client.execute("query-balloon", &balloon); err == nil {
+		vm.BalloonStats.Current = balloon.Actual / 1024
+	} else if !isQMPError(err, "DeviceNotActive") {
+		return vm, err
+	}
+
+	vm.LastUpdate = time.Now().UnixNano()
+	return vm, nil
+}
+
+// qmpRunState maps a QEMU run state to the libvirt domain state the UI uses
+func qmpRunState(status string) int {
+	switch status {
+	case "running":
+		return 1
+	case "shutdown":
+		return 5
+	case "internal-error", "guest-panicked":
+		return 6
+	case "suspended":
+		return 7
+	default:
+		// paused, prelaunch, inmigrate, io-error, watchdog, ...
+		return 3
+	}
+}
+
+// qmpBlockStats is one entry of query-blockstats
+type qmpBlockStats struct {
+	Device   string `json:"device"`
+	QDev     string `json:"qdev"`
+	NodeName string `json:"node-name"`
+	Stats    struct {
+		RdBytes          int64 `json:"rd_bytes"`
+		WrBytes          int64 `json:"wr_bytes"`
+		RdOperations     int64 `json:"rd_operations"`
+		WrOperations     int64 `json:"wr_operations"`
+		FlushOperations  int64 `json:"flush_operations"`
+		RdTotalTimeNs    int64 `json:"rd_total_time_ns"`
+		WrTotalTimeNs    int64 `json:"wr_total_time_ns"`
+		FlushTotalTimeNs int64 `json:"flush_total_time_ns"`
+	} `json:"stats"`
+}
+
+// name picks the most readable name QEMU gives a block backend: the -drive
+// id, else the guest device id from its QOM path, else the node name
+func (b qmpBlockStats) name() string {
+	if b.Device != "" {
+		return b.Device
+	}
+	if b.QDev != "" {
+		qdev := strings.TrimSuffix(b.QDev, "/virtio-backend")
+		return qdev[strings.LastIndex(qdev, "/")+1:]
+	}
+	return b.NodeName
+}
+
+func (b qmpBlockStats) toBlockStats(name string) BlockStats {
+	return BlockStats{
+		Name:       name,
+		ReadReqs:   b.Stats.RdOperations,
+		ReadBytes:  b.Stats.RdBytes,
+		ReadTime:   b.Stats.RdTotalTimeNs,
+		WriteReqs:  b.Stats.WrOperations,
+		WriteBytes: b.Stats.WrBytes,
+		WriteTime:  b.Stats.WrTotalTimeNs,
+		FlushReqs:  b.Stats.FlushOperations,
+		FlushTime:  b.Stats.FlushTotalTimeNs,
+	}
+}
+
+// threadCPUTime reads the CPU time (ns) of a process or thread from
+// /proc/<id>/stat
+func (c *QMPCollector) threadCPUTime(id int) (int64, bool) {
+	if id <= 0 {
+		return 0, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.ProcRoot, strconv.Itoa(id), "stat"))
+	if err != nil {
+		return 0, false
+	}
+
+	// The command name may contain spaces, so fields are counted from the
+	// closing parenthesis: state is field 3, utime 14 and stime 15
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 13 {
+		return 0, false
+	}
+	utime, err1 := strconv.ParseInt(fields[11], 10, 64)
+	stime, err2 := strconv.ParseInt(fields[12], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	return (utime + stime) * int64(time.Second/userHZ), true
+}
+
+// threadGroup returns the process a thread belongs to
+func (c *QMPCollector) threadGroup(tid int) (int, bool) {
+	data, err := os.ReadFile(filepath.Join(c.ProcRoot, strconv.Itoa(tid), "status"))
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "Tgid:"); ok {
+			pid, err := strconv.Atoi(strings.TrimSpace(value))
+			return pid, err == nil
+		}
+	}
+	return 0, false
+}
+
+// qmpError is an error returned by a QMP command
+type qmpError struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+func (e *qmpError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Class, e.Desc)
+}
+
+// isQMPError reports whether err is a QMP error of the given class
+func isQMPError(err error, class string) bool {
+	var qerr *qmpError
+	return errors.As(err, &qerr) && qerr.Class == class
+}
+
+// qmpClient is a QMP connection past capability negotiation
+type qmpClient struct {
+	conn net.Conn
+	dec  *json.Decoder
+	enc  *json.Encoder
+
+	stop func() bool
+	once sync.Once
+}
+
+// dialQMP connects to a QMP socket and leaves capability negotiation mode.
+// The connection is closed when ctx is done.
+func dialQMP(ctx context.Context, socket string) (*qmpClient, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to QMP socket %s: %w", socket, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c := &qmpClient{conn: conn, dec: json.NewDecoder(conn), enc: json.NewEncoder(conn)}
+	c.stop = context.AfterFunc(ctx, func() { _ = conn.Close() })
+
+	var greeting struct {
+		QMP *json.RawMessage `json:"QMP"`
+	}
+	if err := c.dec.Decode(&greeting); err != nil || greeting.QMP == nil {
+		c.Close()
+		if err == nil {
+			err = errors.New("no QMP greeting")
+		}
+		return nil, fmt.Errorf("failed to read QMP greeting from %s: %w", socket, err)
+	}
+	if err := c.execute("qmp_capabilities", nil); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// execute runs a command and decodes its return value into result, which
+// may be nil. Asynchronous events received meanwhile are skipped.
+func (c *qmpClient) execute(command string, result any) error {
+	if err := c.enc.Encode(map[string]string{"execute": command}); err != nil {
+		return fmt.Errorf("failed to send %s: %w", command, err)
+	}
+
+	for {
+		var resp struct {
+			Return json.RawMessage `json:"return"`
+			Error  *qmpError       `json:"error"`
+			Event  string          `json:"event"`
+		}
+		if err := c.dec.Decode(&resp); err != nil {
+			return fmt.Errorf("failed to read %s reply: %w", command, err)
+		}
+		switch {
+		case resp.Event != "":
+			continue
+		case resp.Error != nil:
+			return fmt.Errorf("%s failed: %w", command, resp.Error)
+		case result == nil:
+			return nil
+		}
+		if err := json.Unmarshal(resp.Return, result); err != nil {
+			return fmt.Errorf("failed to decode %s reply: %w", command, err)
+		}
+		return nil
+	}
+}
+
+// Close closes the connection
+func (c *qmpClient) Close() {
+	c.once.Do(func() {
+		c.stop()
+		_ = c.conn.Close()
+	})
+}
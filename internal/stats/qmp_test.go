@@ -0,0 +1,229 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeQMP serves canned replies on a QMP socket, one client at a time like
+// QEMU does
+type fakeQMP struct {
+	replies map[string]string
+}
+
+// serveQMP starts a fake QEMU monitor on socket
+func serveQMP(t *testing.T, socket string, replies map[string]string) *fakeQMP {
+	t.Helper()
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	f := &fakeQMP{replies: replies}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeQMP) serve(conn net.Conn) {
+	defer conn.Close()
+	_, _ = conn.Write([]byte(`{"QMP": {"version": {"qemu": {"micro": 0, "minor": 2, "major": 8}}, "capabilities": []}}` + "\n"))
+
+	dec := json.NewDecoder(conn)
+	for {
+		var cmd struct {
+			Execute string `json:"execute"`
+		}
+		if err := dec.Decode(&cmd); err != nil {
+			return
+		}
+
+		reply, ok := f.replies[cmd.Execute]
+		switch {
+		case cmd.Execute == "qmp_capabilities":
+			reply = `{"return": {}}`
+		case !ok:
+			reply = `{"error": {"class": "CommandNotFound", "desc": "The command ` + cmd.Execute + ` has not been found"}}`
+		}
+		// An event may arrive before any reply
+		_, _ = conn.Write([]byte(`{"event": "NIC_RX_FILTER_CHANGED", "data": {}, "timestamp": {"seconds": 1, "microseconds": 0}}` + "\n" + reply + "\n"))
+	}
+}
+
+func TestQMPCollectorGetVMStats(t *testing.T) {
+	dir := t.TempDir()
+	serveQMP(t, filepath.Join(dir, "web.qmp"), map[string]string{
+		"query-name":      `{"return": {"name": "web1"}}`,
+		"query-status":    `{"return": {"status": "running", "singlestep": false, "running": true}}`,
+		"query-cpus-fast": `{"return": [{"cpu-index": 0, "thread-id": 4243, "target": "x86_64"}, {"cpu-index": 1, "thread-id": 4244, "target": "x86_64"}]}`,
+		"query-blockstats": `{"return": [
+			{"device": "", "qdev": "/machine/peripheral/virtio-disk0/virtio-backend", "node-name": "#block123", "stats": {"rd_bytes": 4096, "wr_bytes": 8192, "rd_operations": 1, "wr_operations": 2, "flush_operations": 3, "rd_total_time_ns": 1000, "wr_total_time_ns": 2000, "flush_total_time_ns": 3000}},
+			{"device": "ide1-cd0", "stats": {"rd_bytes": 10}},
+			{"device": "", "stats": {}}
+		]}`,
+		"query-balloon": `{"return": {"actual": 2147483648}}`,
+	})
+	serveQMP(t, filepath.Join(dir, "scratch.qmp"), map[string]string{
+		"query-name":       `{"return": {}}`,
+		"query-status":     `{"return": {"status": "paused", "running": false}}`,
+		"query-cpus-fast":  `{"return": []}`,
+		"query-blockstats": `{"return": []}`,
+		"query-balloon":    `{"error": {"class": "DeviceNotActive", "desc": "No balloon device has been activated"}}`,
+	})
+
+	// vCPU threads and their QEMU process
+	proc := filepath.Join(dir, "proc")
+	writeTree(t, proc, map[string]string{
+		"4243/status": "Name:\tCPU 0/KVM\nTgid:\t4200\nPid:\t4243\n",
+		"4243/stat":   "4243 (CPU 0/KVM) S 1 4200 4200 0 -1 4194560 0 0 0 0 150 50 0 0 20 0 1 0",
+		"4244/stat":   "4244 (CPU 1/KVM) S 1 4200 4200 0 -1 4194560 0 0 0 0 100 0 0 0 20 0 1 0",
+		"4200/stat":   "4200 (qemu-system-x86) S 1 4200 4200 0 -1 4194560 0 0 0 0 400 100 0 0 20 0 5 0",
+	})
+
+	c := NewQMPCollector(filepath.Join(dir, "*.qmp"))
+	c.ProcRoot = proc
+	vms, err := c.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("GetVMStats: %v", err)
+	}
+	if len(vms) != 2 {
+		t.Fatalf("expected 2 domains, got %+v", vms)
+	}
+
+	// A QEMU without -name is named after its socket
+	scratch, web := vms[0], vms[1]
+	if scratch.DomainName != "scratch" || scratch.State != 3 || scratch.BalloonStats.Current != 0 {
+		t.Errorf("unexpected unnamed domain %+v", scratch)
+	}
+
+	if web.DomainName != "web1" || web.State != 1 || web.PID != 4200 {
+		t.Errorf("unexpected domain %q state %d pid %d", web.DomainName, web.State, web.PID)
+	}
+	if web.BalloonStats.Current != 2<<20 {
+		t.Errorf("balloon = %d KiB; expected 2 GiB", web.BalloonStats.Current)
+	}
+	if web.CPU.Time != 5e9 {
+		t.Errorf("CPU time = %d; expected 5s from the process", web.CPU.Time)
+	}
+	if len(web.VCPUStats) != 2 || web.VCPUStats[0].Time != 2e9 || web.VCPUStats[1].ID != 1 || web.VCPUStats[1].Time != 1e9 {
+		t.Errorf("unexpected vCPU stats %+v", web.VCPUStats)
+	}
+
+	if len(web.BlockStats) != 2 {
+		t.Fatalf("expected 2 named block devices, got %+v", web.BlockStats)
+	}
+	expected := BlockStats{Name: "virtio-disk0", ReadReqs: 1, ReadBytes: 4096, ReadTime: 1000, WriteReqs: 2, WriteBytes: 8192, WriteTime: 2000, FlushReqs: 3, FlushTime: 3000}
+	if web.BlockStats[0] != expected {
+		t.Errorf("block stats = %+v; expected %+v", web.BlockStats[0], expected)
+	}
+	if web.BlockStats[1].Name != "ide1-cd0" {
+		t.Errorf("expected the -drive id to name the device, got %q", web.BlockStats[1].Name)
+	}
+}
+
+func TestQMPCollectorStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+	serveQMP(t, filepath.Join(dir, "up.qmp"), map[string]string{
+		"query-name":       `{"return": {"name": "up"}}`,
+		"query-status":     `{"return": {"status": "running"}}`,
+		"query-cpus-fast":  `{"return": []}`,
+		"query-blockstats": `{"return": []}`,
+		"query-balloon":    `{"return": {"actual": 1024}}`,
+	})
+
+	// A socket left behind by a QEMU that exited
+	l, err := net.Listen("unix", filepath.Join(dir, "gone.qmp"))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+
+	c := NewQMPCollector(filepath.Join(dir, "*.qmp"))
+	vms, err := c.GetVMStats(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be skipped, got %v", err)
+	}
+	if len(vms) != 1 || vms[0].DomainName != "up" {
+		t.Errorf("expected only the live domain, got %+v", vms)
+	}
+
+	// With nothing reachable the error is reported
+	c.SocketGlob = filepath.Join(dir, "gone.qmp")
+	if _, err := c.GetVMStats(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "gone.qmp") {
+		t.Errorf("expected a connection error naming the socket, got %v", err)
+	}
+}
+
+func TestQMPCollectorCommandError(t *testing.T) {
+	dir := t.TempDir()
+	serveQMP(t, filepath.Join(dir, "old.qmp"), map[string]string{
+		"query-name":   `{"return": {"name": "old"}}`,
+		"query-status": `{"return": {"status": "running"}}`,
+		// query-cpus-fast is missing, as on QEMU before 2.12
+	})
+
+	c := NewQMPCollector(filepath.Join(dir, "*.qmp"))
+	_, err := c.GetVMStats(context.Background(), nil)
+	if err == nil || !isQMPError(err, "CommandNotFound") {
+		t.Errorf("expected CommandNotFound, got %v", err)
+	}
+}
+
+func TestQMPCollectorTimeout(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "busy.qmp")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	// Accept but never greet, like a monitor another client is holding
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	c := NewQMPCollector(socket)
+	c.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	if _, err := c.GetVMStats(context.Background(), nil); err == nil {
+		t.Error("expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("timeout took %v", elapsed)
+	}
+}
+
+func TestQMPRunState(t *testing.T) {
+	tests := map[string]int{
+		"running":        1,
+		"paused":         3,
+		"inmigrate":      3,
+		"shutdown":       5,
+		"guest-panicked": 6,
+		"suspended":      7,
+	}
+	for status, expected := range tests {
+		if state := qmpRunState(status); state != expected {
+			t.Errorf("qmpRunState(%q) = %d; expected %d", status, state, expected)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package stats
+
+import "time"
+
+// CalculateRates fills in the rate fields of current from the counters of
+// the previous sample of the same domains: CPU and vCPU usage, vCPU exits,
+// swap and faults, perf counters, memory bandwidth, disk and network
+// throughput. Domains without a previous sample keep zero rates.
+//
+// Devices are matched by name (vCPUs by ID), so hot-plugged or reordered
+// devices are compared with themselves. A domain that restarted in between
+// is marked Restarted and a device whose counters went backwards is marked
+// Reset; neither gets rates for that sample.
+func CalculateRates(current, previous []VMStats) {
+	// Create map for fast lookup of old stats
+	oldMap := make(map[string]VMStats)
+	for _, vm := range previous {
+		oldMap[vm.DomainName] = vm
+	}
+
+	for i := range current {
+		vm := &current[i]
+		oldVM, ok := oldMap[vm.DomainName]
+		if !ok {
+			continue
+		}
+
+		// Time passed between updates in nanoseconds
+		interval := vm.LastUpdate - oldVM.LastUpdate
+		if interval == 0 {
+			// The same sample again, as when a replay is polled faster
+			// than it was recorded; keep the rates already derived
+			carryRates(vm, oldVM)
+			continue
+		}
+		if interval < 0 {
+			continue
+		}
+
+		// Every counter started over with the new QEMU process
+		if restarted(*vm, oldVM) {
+			vm.Restarted = true
+			continue
+		}
+
+		domainCPURates(vm, oldVM, interval)
+
+		oldVCPUs := make(map[int]VCPUStats, len(oldVM.VCPUStats))
+		for _, vcpu := range oldVM.VCPUStats {
+			oldVCPUs[vcpu.ID] = vcpu
+		}
+		for j := range vm.VCPUStats {
+			if old, ok := oldVCPUs[vm.VCPUStats[j].ID]; ok {
+				vcpuRates(&vm.VCPUStats[j], old, interval)
+			}
+		}
+
+		balloonRates(&vm.BalloonStats, oldVM.BalloonStats)
+		perfRates(&vm.Perf, oldVM.Perf, interval)
+		memoryBandwidthRates(vm.MemoryBandwidth, oldVM.MemoryBandwidth, interval)
+
+		oldDisks := make(map[string]BlockStats, len(oldVM.BlockStats))
+		for _, disk := range oldVM.BlockStats {
+			oldDisks[disk.Name] = disk
+		}
+		for j := range vm.BlockStats {
+			if old, ok := oldDisks[vm.BlockStats[j].Name]; ok {
+				diskRates(&vm.BlockStats[j], old, interval)
+			}
+		}
+
+		oldIfaces := make(map[string]InterfaceStats, len(oldVM.InterfaceStats))
+		for _, iface := range oldVM.InterfaceStats {
+			oldIfaces[iface.Name] = iface
+		}
+		for j := range vm.InterfaceStats {
+			if old, ok := oldIfaces[vm.InterfaceStats[j].Name]; ok {
+				interfaceRates(&vm.InterfaceStats[j], old, interval)
+			}
+		}
+	}
+}
+
+// carryRates copies the rates derived for old onto vm, a repeat of the same
+// sample. Only derived fields are copied; everything else in vm is as
+// fresh as the collector made it.
+func carryRates(vm *VMStats, old VMStats) {
+	vm.Restarted = old.Restarted
+	vm.CPU.Usage, vm.CPU.OverheadUsage = old.CPU.Usage, old.CPU.OverheadUsage
+	b, ob := &vm.BalloonStats, old.BalloonStats
+	b.SwapInRate, b.SwapOutRate, b.MajorFaultRate = ob.SwapInRate, ob.SwapOutRate, ob.MajorFaultRate
+	vm.Perf.IPC, vm.Perf.CacheMissRate, vm.Perf.ContextSwitchRate = old.Perf.IPC, old.Perf.CacheMissRate, old.Perf.ContextSwitchRate
+
+	for i := range vm.VCPUStats {
+		vcpu := &vm.VCPUStats[i]
+		for _, o := range old.VCPUStats {
+			if o.ID == vcpu.ID {
+				vcpu.Usage, vcpu.WaitUsage, vcpu.Steal, vcpu.ExitRate, vcpu.Reset = o.Usage, o.WaitUsage, o.Steal, o.ExitRate, o.Reset
+			}
+		}
+	}
+	for i := range vm.MemoryBandwidth {
+		mon := &vm.MemoryBandwidth[i]
+		for _, o := range old.MemoryBandwidth {
+			if o.Name != mon.Name {
+				continue
+			}
+			for j := range mon.Nodes {
+				if j < len(o.Nodes) {
+					mon.Nodes[j].LocalRate, mon.Nodes[j].TotalRate = o.Nodes[j].LocalRate, o.Nodes[j].TotalRate
+				}
+			}
+		}
+	}
+	for i := range vm.BlockStats {
+		disk := &vm.BlockStats[i]
+		for _, o := range old.BlockStats {
+			if o.Name == disk.Name {
+				disk.ReadIOPS, disk.WriteIOPS, disk.FlushIOPS = o.ReadIOPS, o.WriteIOPS, o.FlushIOPS
+				disk.ReadBytesRate, disk.WriteBytesRate = o.ReadBytesRate, o.WriteBytesRate
+				disk.ReadLatency, disk.WriteLatency, disk.FlushLatency = o.ReadLatency, o.WriteLatency, o.FlushLatency
+				disk.Reset = o.Reset
+			}
+		}
+	}
+	for i := range vm.InterfaceStats {
+		iface := &vm.InterfaceStats[i]
+		for _, o := range old.InterfaceStats {
+			if o.Name == iface.Name {
+				iface.RxBytesRate, iface.TxBytesRate = o.RxBytesRate, o.TxBytesRate
+				iface.RxPacketsRate, iface.TxPacketsRate = o.RxPacketsRate, o.TxPacketsRate
+				iface.RxErrsRate, iface.TxErrsRate = o.RxErrsRate, o.TxErrsRate
+				iface.RxDropRate, iface.TxDropRate = o.RxDropRate, o.TxDropRate
+				iface.Reset = o.Reset
+			}
+		}
+	}
+}
+
+// restarted reports whether a domain was started again between two samples:
+// its libvirt ID or QEMU PID changed, it came back from being inactive, or
+// its CPU time went backwards while it kept running. Collectors that know
+// neither ID nor PID leave them 0, and then only the last two checks apply.
+// A domain that shut off reports no CPU time, which is not a restart.
+func restarted(vm, old VMStats) bool {
+	switch {
+	case vm.ID > 0 && old.ID > 0 && vm.ID != old.ID:
+		return true
+	case vm.PID > 0 && old.PID > 0 && vm.PID != old.PID:
+		return true
+	case !domainActive(old.State) && domainActive(vm.State):
+		return true
+	}
+	return domainActive(vm.State) && domainActive(old.State) && vm.CPU.Time < old.CPU.Time
+}
+
+// domainActive reports whether a domain state means QEMU is running
+func domainActive(state int) bool {
+	switch state {
+	case domainStateShutoff, domainStateCrashed, domainStateNoState:
+		return false
+	}
+	return true
+}
+
+// CalculateHostRates sets the host's CPU usage from the busy share of the
+// CPU time that passed since the previous snapshot
+func CalculateHostRates(current *HostStats, previous HostStats) {
+	total := current.CPU.Total() - previous.CPU.Total()
+	if total <= 0 {
+		return
+	}
+	current.CPUUsage = percentOf(current.CPU.Busy()-previous.CPU.Busy(), total)
+}
+
+// domainCPURates derives whole-domain CPU usage, normalized to the host's
+// CPUs, and the share of a host CPU spent outside the vCPUs (emulator and
+// I/O threads)
+func domainCPURates(vm *VMStats, oldVM VMStats, interval int64) {
+	deltaCPUTime := vm.CPU.Time - oldVM.CPU.Time
+	if deltaCPUTime < 0 || oldVM.CPU.Time == 0 {
+		return
+	}
+
+	// Without the host CPU count, fall back to the domain's own vCPUs
+	cpus := int64(vm.HostCPUs)
+	if cpus <= 0 {
+		cpus = int64(max(len(vm.VCPUStats), 1))
+	}
+	vm.CPU.Usage = percentOf(deltaCPUTime, interval*cpus)
+
+	// Emulator and I/O threads can together use more than one host CPU,
+	// so overhead is not capped
+	deltaOverhead := vm.CPUOverhead() - oldVM.CPUOverhead()
+	if deltaOverhead > 0 {
+		vm.CPU.OverheadUsage = float64(deltaOverhead) / float64(interval) * 100
+	}
+}
+
+// vcpuRates derives a vCPU's usage, wait and steal shares and exit rate
+func vcpuRates(vcpu *VCPUStats, old VCPUStats, interval int64) {
+	// CPU time is in nanoseconds
+	deltaCPUTime := vcpu.Time - old.Time
+	if deltaCPUTime < 0 || vcpu.Exits < old.Exits {
+		vcpu.Reset = true
+		return
+	}
+
+	// Usage % = (delta CPU time / delta Wall time) * 100
+	vcpu.Usage = percentOf(deltaCPUTime, interval)
+	vcpu.WaitUsage = percentOf(vcpu.Wait-old.Wait, interval)
+	vcpu.Steal = percentOf(vcpu.Delay-old.Delay, interval)
+	vcpu.ExitRate = perSecond(vcpu.Exits-old.Exits, interval)
+}
+
+// balloonRates derives swap and major-fault rates. The guest only refreshes
+// these counters once per balloon stats period, so rates are taken over the
+// guest's own report interval and carried over between reports.
+func balloonRates(b *BalloonStats, old BalloonStats) {
+	if b.LastUpdate == 0 || old.LastUpdate == 0 {
+		return
+	}
+	if b.LastUpdate == old.LastUpdate {
+		b.SwapInRate, b.SwapOutRate, b.MajorFaultRate = old.SwapInRate, old.SwapOutRate, old.MajorFaultRate
+		return
+	}
+	seconds := float64(b.LastUpdate - old.LastUpdate)
+	if seconds < 0 || b.SwapIn < old.SwapIn || b.SwapOut < old.SwapOut || b.MajorFault < old.MajorFault {
+		return
+	}
+
+	b.SwapInRate = float64(b.SwapIn-old.SwapIn) / seconds
+	b.SwapOutRate = float64(b.SwapOut-old.SwapOut) / seconds
+	b.MajorFaultRate = float64(b.MajorFault-old.MajorFault) / seconds
+}
+
+// perfRates derives IPC and cache-miss rate from perf counter deltas
+func perfRates(p *PerfStats, old PerfStats, interval int64) {
+	if cycles := p.CPUCycles - old.CPUCycles; cycles > 0 && p.Instructions >= old.Instructions {
+		p.IPC = float64(p.Instructions-old.Instructions) / float64(cycles)
+	}
+	if refs := p.CacheReferences - old.CacheReferences; refs > 0 && p.CacheMisses >= old.CacheMisses {
+		p.CacheMissRate = float64(p.CacheMisses-old.CacheMisses) / float64(refs) * 100
+	}
+	p.ContextSwitchRate = perSecond(p.ContextSwitches-old.ContextSwitches, interval)
+}
+
+// memoryBandwidthRates derives per-node memory bandwidth, matching monitors
+// by name since their order is not guaranteed
+func memoryBandwidthRates(monitors, oldMonitors []MemoryBandwidthMonitor, interval int64) {
+	seconds := float64(interval) / float64(time.Second)
+	for i := range monitors {
+		mon := &monitors[i]
+		for _, old := range oldMonitors {
+			if old.Name != mon.Name {
+				continue
+			}
+			for j := range mon.Nodes {
+				if j >= len(old.Nodes) {
+					break
+				}
+				node, oldNode := &mon.Nodes[j], old.Nodes[j]
+				if node.BytesLocal >= oldNode.BytesLocal && node.BytesTotal >= oldNode.BytesTotal {
+					node.LocalRate = float64(node.BytesLocal-oldNode.BytesLocal) / seconds
+					node.TotalRate = float64(node.BytesTotal-oldNode.BytesTotal) / seconds
+				}
+			}
+		}
+	}
+}
+
+// diskRates derives IOPS, throughput and average per-request latency for a
+// disk from two samples taken interval nanoseconds apart
+func diskRates(disk *BlockStats, old BlockStats, interval int64) {
+	// A shrinking counter means the device was reset; skip this sample
+	if disk.ReadReqs < old.ReadReqs || disk.WriteReqs < old.WriteReqs || disk.FlushReqs < old.FlushReqs ||
+		disk.ReadBytes < old.ReadBytes || disk.WriteBytes < old.WriteBytes {
+		disk.Reset = true
+		return
+	}
+
+	readReqs := disk.ReadReqs - old.ReadReqs
+	writeReqs := disk.WriteReqs - old.WriteReqs
+	flushReqs := disk.FlushReqs - old.FlushReqs
+
+	disk.ReadIOPS = perSecond(readReqs, interval)
+	disk.WriteIOPS = perSecond(writeReqs, interval)
+	disk.FlushIOPS = perSecond(flushReqs, interval)
+	disk.ReadBytesRate = perSecond(disk.ReadBytes-old.ReadBytes, interval)
+	disk.WriteBytesRate = perSecond(disk.WriteBytes-old.WriteBytes, interval)
+	disk.ReadLatency = averageLatency(disk.ReadTime-old.ReadTime, readReqs)
+	disk.WriteLatency = averageLatency(disk.WriteTime-old.WriteTime, writeReqs)
+	disk.FlushLatency = averageLatency(disk.FlushTime-old.FlushTime, flushReqs)
+}
+
+// interfaceRates derives throughput, packet, error and drop rates for a
+// network interface
+func interfaceRates(iface *InterfaceStats, old InterfaceStats, interval int64) {
+	// A shrinking counter means the device was reset; skip this sample
+	if iface.RxBytes < old.RxBytes || iface.TxBytes < old.TxBytes || iface.RxPackets < old.RxPackets || iface.TxPackets < old.TxPackets {
+		iface.Reset = true
+		return
+	}
+
+	iface.RxBytesRate = perSecond(iface.RxBytes-old.RxBytes, interval)
+	iface.TxBytesRate = perSecond(iface.TxBytes-old.TxBytes, interval)
+	iface.RxPacketsRate = perSecond(iface.RxPackets-old.RxPackets, interval)
+	iface.TxPacketsRate = perSecond(iface.TxPackets-old.TxPackets, interval)
+	iface.RxErrsRate = perSecond(iface.RxErrs-old.RxErrs, interval)
+	iface.TxErrsRate = perSecond(iface.TxErrs-old.TxErrs, interval)
+	iface.RxDropRate = perSecond(iface.RxDrop-old.RxDrop, interval)
+	iface.TxDropRate = perSecond(iface.TxDrop-old.TxDrop, interval)
+}
+
+// perSecond turns a counter delta over interval nanoseconds into a rate.
+// Counters going backwards give 0.
+func perSecond(delta, interval int64) float64 {
+	if delta <= 0 || interval <= 0 {
+		return 0
+	}
+	return float64(delta) / (float64(interval) / float64(time.Second))
+}
+
+// averageLatency spreads time spent (ns) over the requests that took it
+func averageLatency(deltaTime, deltaReqs int64) time.Duration {
+	if deltaReqs <= 0 || deltaTime <= 0 {
+		return 0
+	}
+	return time.Duration(deltaTime / deltaReqs)
+}
+
+// percentOf returns delta nanoseconds as a percentage of a wall-clock
+// interval, clamped to 0-100% per core
+func percentOf(delta, interval int64) float64 {
+	if delta <= 0 {
+		return 0
+	}
+	usage := (float64(delta) / float64(interval)) * 100.0
+
+	// Cap at 100% per core
+	if usage > 100.0 {
+		usage = 100.0
+	}
+	return usage
+}
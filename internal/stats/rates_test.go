@@ -0,0 +1,256 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCalculateRates(t *testing.T) {
+	const second = int64(time.Second)
+	previous := []VMStats{{
+		DomainName: "vm1",
+		HostCPUs:   4,
+		LastUpdate: 10 * second,
+		CPU:        CPUStats{Time: 10 * second},
+		VCPUStats:  []VCPUStats{{ID: 0, Time: 4 * second, Exits: 1000, Delay: 0}},
+		BlockStats: []BlockStats{{Name: "vda", ReadReqs: 100, ReadBytes: 1 << 20, ReadTime: 1e6, WriteReqs: 10}},
+		InterfaceStats: []InterfaceStats{{
+			Name: "vnet0", RxBytes: 1000, TxBytes: 2000, RxPackets: 10, TxPackets: 20, RxDrop: 1,
+		}},
+	}, {
+		DomainName: "gone", LastUpdate: 10 * second,
+	}}
+	current := []VMStats{{
+		DomainName: "vm1",
+		HostCPUs:   4,
+		LastUpdate: 12 * second,
+		CPU:        CPUStats{Time: 12 * second},
+		VCPUStats:  []VCPUStats{{ID: 0, Time: 5 * second, Exits: 1600, Delay: second / 10}},
+		BlockStats: []BlockStats{{Name: "vda", ReadReqs: 300, ReadBytes: 5 << 20, ReadTime: 3e6, WriteReqs: 10}},
+		InterfaceStats: []InterfaceStats{{
+			Name: "vnet0", RxBytes: 5000, TxBytes: 2000, RxPackets: 50, TxPackets: 20, RxDrop: 5,
+		}},
+	}, {
+		DomainName: "new", LastUpdate: 12 * second, CPU: CPUStats{Time: second},
+	}}
+
+	CalculateRates(current, previous)
+	vm := current[0]
+
+	// 2s of CPU over 2s on 4 host CPUs, 1s of it on the vCPU
+	if vm.CPU.Usage != 25 {
+		t.Errorf("domain usage = %.1f%%; expected 25%%", vm.CPU.Usage)
+	}
+	if vm.CPU.OverheadUsage != 50 {
+		t.Errorf("overhead = %.1f%%; expected 50%%", vm.CPU.OverheadUsage)
+	}
+
+	vcpu := vm.VCPUStats[0]
+	if vcpu.Usage != 50 || vcpu.Steal != 5 || vcpu.ExitRate != 300 {
+		t.Errorf("vCPU usage %.1f%%, steal %.1f%%, exits %.0f/s; expected 50%%, 5%%, 300/s", vcpu.Usage, vcpu.Steal, vcpu.ExitRate)
+	}
+
+	disk := vm.BlockStats[0]
+	if disk.ReadIOPS != 100 || disk.WriteIOPS != 0 || disk.ReadBytesRate != 2<<20 || disk.ReadLatency != 10*time.Microsecond {
+		t.Errorf("unexpected disk rates %+v", disk)
+	}
+
+	iface := vm.InterfaceStats[0]
+	if iface.RxBytesRate != 2000 || iface.TxBytesRate != 0 || iface.RxPacketsRate != 20 || iface.RxDropRate != 2 || iface.RxErrsRate != 0 {
+		t.Errorf("unexpected interface rates %+v", iface)
+	}
+
+	// Domains without a previous sample have nothing to compare against
+	if current[1].CPU.Usage != 0 {
+		t.Errorf("expected no usage for a new domain, got %.1f%%", current[1].CPU.Usage)
+	}
+}
+
+func TestCalculateRatesCounterReset(t *testing.T) {
+	previous := []VMStats{{
+		DomainName:     "vm1",
+		LastUpdate:     int64(time.Second),
+		VCPUStats:      []VCPUStats{{Time: int64(time.Hour), Exits: 1e6}},
+		BlockStats:     []BlockStats{{ReadReqs: 1e6, ReadBytes: 1e9}},
+		InterfaceStats: []InterfaceStats{{RxBytes: 1e9, RxPackets: 1e6}},
+	}}
+	current := []VMStats{{
+		DomainName:     "vm1",
+		LastUpdate:     int64(2 * time.Second),
+		VCPUStats:      []VCPUStats{{Time: int64(time.Millisecond), Exits: 10}},
+		BlockStats:     []BlockStats{{ReadReqs: 5, ReadBytes: 4096}},
+		InterfaceStats: []InterfaceStats{{RxBytes: 100, RxPackets: 1}},
+	}}
+
+	CalculateRates(current, previous)
+	vm := current[0]
+	if vm.VCPUStats[0].Usage != 0 || vm.VCPUStats[0].ExitRate != 0 {
+		t.Errorf("expected no vCPU rates after a reset, got %+v", vm.VCPUStats[0])
+	}
+	if vm.BlockStats[0].ReadBytesRate != 0 || vm.InterfaceStats[0].RxBytesRate != 0 {
+		t.Error("expected no throughput after a reset")
+	}
+	if !vm.VCPUStats[0].Reset || !vm.BlockStats[0].Reset || !vm.InterfaceStats[0].Reset {
+		t.Error("expected the reset devices to be marked")
+	}
+}
+
+func TestCalculateRatesSameSample(t *testing.T) {
+	previous := []VMStats{{
+		DomainName:     "vm1",
+		State:          1,
+		LastUpdate:     5,
+		CPU:            CPUStats{Time: 100, Usage: 42},
+		InterfaceStats: []InterfaceStats{{Name: "vnet0", RxBytesRate: 10}},
+	}}
+	current := []VMStats{{
+		DomainName:     "vm1",
+		State:          3,
+		LastUpdate:     5,
+		CPU:            CPUStats{Time: 100},
+		InterfaceStats: []InterfaceStats{{Name: "vnet0", MAC: "52:54:00:12:34:56"}},
+	}}
+
+	CalculateRates(current, previous)
+	got := current[0]
+	if got.CPU.Usage != 42 || got.InterfaceStats[0].RxBytesRate != 10 {
+		t.Errorf("expected the previous sample's rates to be kept, got %+v", got)
+	}
+	// Everything else stays as collected
+	if got.State != 3 || got.InterfaceStats[0].MAC != "52:54:00:12:34:56" {
+		t.Errorf("expected the fresh state and MAC to be kept, got %+v", got)
+	}
+}
+
+func TestCalculateHostRates(t *testing.T) {
+	previous := HostStats{CPU: HostCPUStats{User: 100, Kernel: 100, Idle: 800}}
+	current := HostStats{CPU: HostCPUStats{User: 250, Kernel: 150, Idle: 1000, IOWait: 100}}
+
+	CalculateHostRates(&current, previous)
+	// 200 of 500 ns busy, I/O wait counting as idle
+	if math.Abs(current.CPUUsage-40) > 1e-9 {
+		t.Errorf("host CPU = %.1f%%; expected 40%%", current.CPUUsage)
+	}
+}
+
+func TestCalculateRatesRestart(t *testing.T) {
+	const second = int64(time.Second)
+	previous := VMStats{
+		DomainName: "vm1",
+		ID:         3,
+		State:      1,
+		LastUpdate: 10 * second,
+		CPU:        CPUStats{Time: 50 * second},
+		VCPUStats:  []VCPUStats{{ID: 0, Time: 40 * second}},
+		BlockStats: []BlockStats{{Name: "vda", ReadBytes: 1 << 30}},
+	}
+	tests := []struct {
+		name   string
+		before func(vm *VMStats)
+		after  func(vm *VMStats)
+	}{
+		{"new domain ID", func(vm *VMStats) {}, func(vm *VMStats) { vm.ID = 4 }},
+		{"new QEMU PID", func(vm *VMStats) { vm.PID = 100 }, func(vm *VMStats) { vm.PID = 200 }},
+		{"CPU time reset", func(vm *VMStats) {}, func(vm *VMStats) { vm.CPU.Time = second }},
+		{"started from off", func(vm *VMStats) { vm.State, vm.ID = 5, -1 }, func(vm *VMStats) {}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := previous
+			tt.before(&old)
+			vm := previous
+			vm.LastUpdate = 12 * second
+			vm.CPU.Time = 51 * second
+			vm.VCPUStats = []VCPUStats{{ID: 0, Time: 41 * second}}
+			vm.BlockStats = []BlockStats{{Name: "vda", ReadBytes: 2 << 30}}
+			tt.after(&vm)
+
+			current := []VMStats{vm}
+			CalculateRates(current, []VMStats{old})
+			got := current[0]
+			if !got.Restarted {
+				t.Error("expected the domain to be marked restarted")
+			}
+			if got.CPU.Usage != 0 || got.VCPUStats[0].Usage != 0 || got.BlockStats[0].ReadBytesRate != 0 {
+				t.Errorf("expected no rates across a restart, got %+v", got)
+			}
+		})
+	}
+
+	// Shutting off zeroes the CPU time without starting anything
+	off := previous
+	off.State, off.ID = 5, -1
+	off.LastUpdate = 12 * second
+	off.CPU.Time = 0
+	current := []VMStats{off}
+	CalculateRates(current, []VMStats{previous})
+	if current[0].Restarted {
+		t.Error("expected a domain that shut off not to be marked restarted")
+	}
+
+	// The same QEMU keeps going
+	vm := previous
+	vm.LastUpdate = 12 * second
+	vm.CPU.Time = 52 * second
+	current = []VMStats{vm}
+	CalculateRates(current, []VMStats{previous})
+	if current[0].Restarted || current[0].CPU.Usage == 0 {
+		t.Errorf("expected rates for a running domain, got %+v", current[0])
+	}
+}
+
+func TestCalculateRatesDeviceOrder(t *testing.T) {
+	const second = int64(time.Second)
+	previous := []VMStats{{
+		DomainName: "vm1",
+		LastUpdate: second,
+		VCPUStats:  []VCPUStats{{ID: 0, Time: second}, {ID: 1, Time: second}},
+		BlockStats: []BlockStats{{Name: "vda", ReadReqs: 100}, {Name: "vdb", ReadReqs: 5000}},
+		InterfaceStats: []InterfaceStats{
+			{Name: "vnet0", RxBytes: 1000},
+			{Name: "vnet1", RxBytes: 9000},
+		},
+	}}
+	// vdb and vnet1 come first now, vdc and vnet2 were hot-plugged, vCPU 0
+	// was unplugged
+	current := []VMStats{{
+		DomainName: "vm1",
+		LastUpdate: 2 * second,
+		VCPUStats:  []VCPUStats{{ID: 1, Time: second + second/2}},
+		BlockStats: []BlockStats{{Name: "vdb", ReadReqs: 5010}, {Name: "vdc", ReadReqs: 7}, {Name: "vda", ReadReqs: 150}},
+		InterfaceStats: []InterfaceStats{
+			{Name: "vnet1", RxBytes: 9500},
+			{Name: "vnet2", RxBytes: 64},
+			{Name: "vnet0", RxBytes: 1100},
+		},
+	}}
+
+	CalculateRates(current, previous)
+	vm := current[0]
+	if vm.VCPUStats[0].Usage != 50 {
+		t.Errorf("vCPU 1 usage = %.1f%%; expected 50%%", vm.VCPUStats[0].Usage)
+	}
+
+	disks := map[string]float64{}
+	for _, d := range vm.BlockStats {
+		if d.Reset {
+			t.Errorf("disk %s wrongly marked reset", d.Name)
+		}
+		disks[d.Name] = d.ReadIOPS
+	}
+	if disks["vda"] != 50 || disks["vdb"] != 10 || disks["vdc"] != 0 {
+		t.Errorf("unexpected disk IOPS %v", disks)
+	}
+
+	ifaces := map[string]float64{}
+	for _, iface := range vm.InterfaceStats {
+		if iface.Reset {
+			t.Errorf("interface %s wrongly marked reset", iface.Name)
+		}
+		ifaces[iface.Name] = iface.RxBytesRate
+	}
+	if ifaces["vnet0"] != 100 || ifaces["vnet1"] != 500 || ifaces["vnet2"] != 0 {
+		t.Errorf("unexpected interface rates %v", ifaces)
+	}
+}
@@ -0,0 +1,492 @@
+package stats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Kinds of recorded frames
+const (
+	FrameVMs      = "vms"
+	FrameHost     = "host"
+	FramePools    = "pools"
+	FrameNetworks = "networks"
+	FrameEvent    = "event"
+)
+
+// Frame is one collector result as written by a Recorder. A recording is a
+// file of frames, one JSON object per line, in the order they were
+// collected.
+type Frame struct {
+	Time     time.Time     `json:"time"`
+	Host     string        `json:"host"`
+	Kind     string        `json:"kind"`
+	VMs      []VMStats     `json:"vms,omitempty"`
+	Node     *HostStats    `json:"node,omitempty"`
+	Pools    []StoragePool `json:"pools,omitempty"`
+	Networks []Network     `json:"networks,omitempty"`
+	Event    *DomainEvent  `json:"event,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	// raw is the frame as read back, decoded afresh for every caller
+	raw json.RawMessage
+}
+
+// Recorder writes collector results to a recording. It is safe for
+// concurrent use, so collectors of several hosts can share one file.
+type Recorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder creates a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	bw := bufio.NewWriter(w)
+	return &Recorder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+// Record appends a frame, stamping it with the current time if unset. Once
+// a write fails the recorder stops and the error is returned by Flush.
+func (r *Recorder) Record(f Frame) {
+	if f.Time.IsZero() {
+		f.Time = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if err := r.enc.Encode(f); err != nil {
+		r.err = fmt.Errorf("failed to write recording: %w", err)
+		return
+	}
+	// Flush every frame so a recording survives the process being killed
+	if err := r.w.Flush(); err != nil {
+		r.err = fmt.Errorf("failed to write recording: %w", err)
+	}
+}
+
+// Flush writes any buffered frames and reports the first write error
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if err := r.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return nil
+}
+
+// Wrap returns a collector that records every result of c under the given
+// host name
+func (r *Recorder) Wrap(host string, c StatsCollector) *RecordingCollector {
+	return &RecordingCollector{Inner: c, Host: host, recorder: r}
+}
+
+// RecordingCollector passes calls through to another collector and records
+// their results. Optional interfaces the inner collector lacks fail with an
+// error, and Supports reports them as missing.
+type RecordingCollector struct {
+	Inner StatsCollector
+	Host  string
+
+	recorder *Recorder
+}
+
+// Supports reports whether the inner collector has the capability
+func (c *RecordingCollector) Supports(capability Capability) bool {
+	return Supports(c.Inner, capability)
+}
+
+func (c *RecordingCollector) record(f Frame, err error) {
+	f.Host = c.Host
+	if err != nil {
+		f.Error = err.Error()
+	}
+	c.recorder.Record(f)
+}
+
+// GetVMStats collects and records domain stats
+func (c *RecordingCollector) GetVMStats(ctx context.Context, domains []string) ([]VMStats, error) {
+	vms, err := c.Inner.GetVMStats(ctx, domains)
+	c.record(Frame{Kind: FrameVMs, VMs: vms}, err)
+	return vms, err
+}
+
+// GetHostStats collects and records host stats
+func (c *RecordingCollector) GetHostStats(ctx context.Context) (HostStats, error) {
+	hc, ok := c.Inner.(HostCollector)
+	if !ok {
+		return HostStats{}, errors.New("collector does not report host stats")
+	}
+	node, err := hc.GetHostStats(ctx)
+	c.record(Frame{Kind: FrameHost, Node: &node}, err)
+	return node, err
+}
+
+// GetStoragePools lists and records storage pools
+func (c *RecordingCollector) GetStoragePools(ctx context.Context) ([]StoragePool, error) {
+	pc, ok := c.Inner.(PoolCollector)
+	if !ok {
+		return nil, errors.New("collector cannot list storage pools")
+	}
+	pools, err := pc.GetStoragePools(ctx)
+	c.record(Frame{Kind: FramePools, Pools: pools}, err)
+	return pools, err
+}
+
+// GetNetworks lists and records virtual networks
+func (c *RecordingCollector) GetNetworks(ctx context.Context) ([]Network, error) {
+	nc, ok := c.Inner.(NetworkCollector)
+	if !ok {
+		return nil, errors.New("collector cannot list networks")
+	}
+	networks, err := nc.GetNetworks(ctx)
+	c.record(Frame{Kind: FrameNetworks, Networks: networks}, err)
+	return networks, err
+}
+
+// WatchEvents streams and records domain events
+func (c *RecordingCollector) WatchEvents(ctx context.Context) (<-chan DomainEvent, error) {
+	source, ok := c.Inner.(EventSource)
+	if !ok {
+		return nil, errors.New("collector cannot watch events")
+	}
+	in, err := source.WatchEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan DomainEvent)
+	go func() {
+		defer close(out)
+		for ev := range in {
+			c.record(Frame{Time: ev.Time, Kind: FrameEvent, Event: &ev}, nil)
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Recording is a recording read back into memory
+type Recording struct {
+	frames []Frame
+	hosts  []string
+}
+
+// ReadRecording reads a recording written by a Recorder
+func ReadRecording(r io.Reader) (*Recording, error) {
+	rec := &Recording{}
+	seen := make(map[string]bool)
+
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read recording frame %d: %w", len(rec.frames)+1, err)
+		}
+		var f Frame
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, fmt.Errorf("failed to read recording frame %d: %w", len(rec.frames)+1, err)
+		}
+		f.raw = raw
+		if !seen[f.Host] {
+			seen[f.Host] = true
+			rec.hosts = append(rec.hosts, f.Host)
+		}
+		rec.frames = append(rec.frames, f)
+	}
+
+	if len(rec.frames) == 0 {
+		return nil, errors.New("recording is empty")
+	}
+	return rec, nil
+}
+
+// Hosts returns the recorded hosts in the order they first appear
+func (r *Recording) Hosts() []string {
+	return r.hosts
+}
+
+// Replay creates one collector per recorded host, all playing back on a
+// shared clock that starts now. Speed scales playback (2 plays twice as
+// fast); with loop the recording starts over once it ends, otherwise the
+// last results are kept.
+func (r *Recording) Replay(speed float64, loop bool) []*ReplayCollector {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	first, last := r.frames[0].Time, r.frames[0].Time
+	var samples []time.Time
+	for _, f := range r.frames {
+		if f.Time.Before(first) {
+			first = f.Time
+		}
+		if f.Time.After(last) {
+			last = f.Time
+		}
+		if f.Kind == FrameVMs {
+			samples = append(samples, f.Time)
+		}
+	}
+
+	// Leave one average sampling interval between the end and the next loop
+	span := last.Sub(first)
+	if n := len(samples); n > 1 {
+		span += samples[n-1].Sub(samples[0]) / time.Duration(n-1)
+	}
+
+	clock := &replayClock{
+		start:   time.Now(),
+		first:   first,
+		span:    span,
+		speed:   speed,
+		loop:    loop && span > 0,
+		now:     time.Now,
+		advance: time.After,
+	}
+
+	collectors := make([]*ReplayCollector, 0, len(r.hosts))
+	for _, host := range r.hosts {
+		c := &ReplayCollector{Host: host, clock: clock}
+		for _, f := range r.frames {
+			if f.Host == host {
+				c.frames = append(c.frames, f)
+			}
+		}
+		collectors = append(collectors, c)
+	}
+	return collectors
+}
+
+// replayClock maps wall time onto the recording's timeline
+type replayClock struct {
+	start time.Time
+	first time.Time
+	span  time.Duration
+	speed float64
+	loop  bool
+
+	now     func() time.Time
+	advance func(time.Duration) <-chan time.Time
+}
+
+// position returns the point of the recording being played and how many
+// times it has looped
+func (c *replayClock) position() (time.Time, int) {
+	elapsed := time.Duration(float64(c.now().Sub(c.start)) * c.speed)
+	if !c.loop {
+		return c.first.Add(elapsed), 0
+	}
+	return c.first.Add(elapsed % c.span), int(elapsed / c.span)
+}
+
+// until returns the wall time left before playback reaches t, where t may
+// lie beyond the end of the recording on a later loop
+func (c *replayClock) until(t time.Time) time.Duration {
+	elapsed := c.now().Sub(c.start)
+	return time.Duration(float64(t.Sub(c.first))/c.speed) - elapsed
+}
+
+// ReplayCollector plays back one host of a recording. Each call returns the
+// latest recorded result of its kind at the current playback position.
+// Results of a kind never recorded fail with an error, and Supports reports
+// them as missing.
+type ReplayCollector struct {
+	Host string
+
+	frames []Frame
+	clock  *replayClock
+}
+
+// Supports reports whether the recording has data for the capability
+func (c *ReplayCollector) Supports(capability Capability) bool {
+	kind, ok := map[Capability]string{
+		CapHostStats: FrameHost,
+		CapPools:     FramePools,
+		CapNetworks:  FrameNetworks,
+		CapEvents:    FrameEvent,
+	}[capability]
+	if !ok {
+		return false
+	}
+	for _, f := range c.frames {
+		if f.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the latest frame of a kind at the playback position,
+// falling back to the first one before it has been reached. The frame is
+// decoded again so callers may modify it, and its timestamps are shifted
+// forward on every loop so rates stay positive.
+func (c *ReplayCollector) current(kind string) (Frame, bool) {
+	pos, loops := c.clock.position()
+
+	var found, earliest *Frame
+	for i := range c.frames {
+		f := &c.frames[i]
+		if f.Kind != kind {
+			continue
+		}
+		if earliest == nil {
+			earliest = f
+		}
+		if f.Time.After(pos) {
+			break
+		}
+		found = f
+	}
+	if found == nil {
+		found = earliest
+	}
+	if found == nil {
+		return Frame{}, false
+	}
+
+	var f Frame
+	if err := json.Unmarshal(found.raw, &f); err != nil {
+		// Already decoded once in ReadRecording
+		return Frame{}, false
+	}
+	if loops > 0 {
+		shift := time.Duration(loops) * c.clock.span
+		f.Time = f.Time.Add(shift)
+		for i := range f.VMs {
+			f.VMs[i].LastUpdate += int64(shift)
+		}
+	}
+	return f, true
+}
+
+// frameError turns a recorded error back into an error
+func frameError(f Frame) error {
+	if f.Error == "" {
+		return nil
+	}
+	return errors.New(f.Error)
+}
+
+// GetVMStats returns the recorded domain stats. The domain filter is
+// applied to what was recorded.
+func (c *ReplayCollector) GetVMStats(ctx context.Context, domains []string) ([]VMStats, error) {
+	f, ok := c.current(FrameVMs)
+	if !ok {
+		return nil, fmt.Errorf("no domain stats recorded for %s", c.Host)
+	}
+	if err := frameError(f); err != nil {
+		return nil, err
+	}
+	if len(domains) == 0 {
+		return f.VMs, nil
+	}
+
+	wanted := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		wanted[d] = true
+	}
+	var vms []VMStats
+	for _, vm := range f.VMs {
+		if wanted[vm.DomainName] {
+			vms = append(vms, vm)
+		}
+	}
+	return vms, nil
+}
+
+// GetHostStats returns the recorded host stats
+func (c *ReplayCollector) GetHostStats(ctx context.Context) (HostStats, error) {
+	f, ok := c.current(FrameHost)
+	if !ok {
+		return HostStats{}, fmt.Errorf("no host stats recorded for %s", c.Host)
+	}
+	if err := frameError(f); err != nil {
+		return HostStats{}, err
+	}
+	if f.Node == nil {
+		return HostStats{}, fmt.Errorf("no host stats recorded for %s", c.Host)
+	}
+	return *f.Node, nil
+}
+
+// GetStoragePools returns the recorded storage pools
+func (c *ReplayCollector) GetStoragePools(ctx context.Context) ([]StoragePool, error) {
+	f, ok := c.current(FramePools)
+	if !ok {
+		return nil, fmt.Errorf("no storage pools recorded for %s", c.Host)
+	}
+	return f.Pools, frameError(f)
+}
+
+// GetNetworks returns the recorded virtual networks
+func (c *ReplayCollector) GetNetworks(ctx context.Context) ([]Network, error) {
+	f, ok := c.current(FrameNetworks)
+	if !ok {
+		return nil, fmt.Errorf("no networks recorded for %s", c.Host)
+	}
+	return f.Networks, frameError(f)
+}
+
+// WatchEvents plays recorded events back as the playback position reaches
+// them
+func (c *ReplayCollector) WatchEvents(ctx context.Context) (<-chan DomainEvent, error) {
+	var events []Frame
+	for _, f := range c.frames {
+		if f.Kind == FrameEvent && f.Event != nil {
+			events = append(events, f)
+		}
+	}
+
+	out := make(chan DomainEvent)
+	go func() {
+		defer close(out)
+		if len(events) == 0 {
+			<-ctx.Done()
+			return
+		}
+
+		for loop := 0; ; loop++ {
+			shift := time.Duration(loop) * c.clock.span
+			for _, f := range events {
+				at := f.Time.Add(shift)
+				select {
+				case <-c.clock.advance(c.clock.until(at)):
+				case <-ctx.Done():
+					return
+				}
+
+				ev := *f.Event
+				ev.Time = at
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !c.clock.loop {
+				<-ctx.Done()
+				return
+			}
+		}
+	}()
+	return out, nil
+}
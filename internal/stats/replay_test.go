@@ -0,0 +1,238 @@
+package stats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubCollector returns canned results, one per call
+type stubCollector struct {
+	results [][]VMStats
+	err     error
+}
+
+func (s *stubCollector) GetVMStats(ctx context.Context, domains []string) ([]VMStats, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	vms := s.results[0]
+	s.results = s.results[1:]
+	return vms, nil
+}
+
+func TestRecordingCollector(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+
+	ok := recorder.Wrap("kvm1", &stubCollector{results: [][]VMStats{{{DomainName: "vm1", LastUpdate: 1}}}})
+	failing := recorder.Wrap("kvm2", &stubCollector{err: errors.New("connection refused")})
+
+	if _, err := ok.GetVMStats(context.Background(), nil); err != nil {
+		t.Fatalf("GetVMStats: %v", err)
+	}
+	if _, err := failing.GetVMStats(context.Background(), nil); err == nil {
+		t.Fatal("expected the inner error to be passed through")
+	}
+	// The stub has no host stats, which must not be recorded as empty ones
+	// nor offered
+	if _, err := ok.GetHostStats(context.Background()); err == nil {
+		t.Error("expected an error for a collector without host stats")
+	}
+	if Supports(ok, CapHostStats) {
+		t.Error("expected no host stats from a collector without them")
+	}
+	if !Supports(recorder.Wrap("kvm3", NewVirshCollector("")), CapPools) {
+		t.Error("expected the pools of a collector that lists them")
+	}
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	rec, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecording: %v", err)
+	}
+	if got := strings.Join(rec.Hosts(), ","); got != "kvm1,kvm2" {
+		t.Errorf("hosts = %s; expected kvm1,kvm2", got)
+	}
+	if len(rec.frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(rec.frames))
+	}
+	if f := rec.frames[0]; f.Kind != FrameVMs || len(f.VMs) != 1 || f.VMs[0].DomainName != "vm1" || f.Time.IsZero() {
+		t.Errorf("unexpected first frame: %+v", f)
+	}
+	if f := rec.frames[1]; f.Host != "kvm2" || f.Error != "connection refused" {
+		t.Errorf("unexpected second frame: %+v", f)
+	}
+}
+
+func TestReadRecordingInvalid(t *testing.T) {
+	if _, err := ReadRecording(strings.NewReader("")); err == nil {
+		t.Error("expected an error for an empty recording")
+	}
+	if _, err := ReadRecording(strings.NewReader("{\"kind\":\"vms\"}\nnot json\n")); err == nil || !strings.Contains(err.Error(), "frame 2") {
+		t.Errorf("expected an error naming frame 2, got %v", err)
+	}
+}
+
+// testRecording builds a recording of one host sampled every 2s from t0
+func testRecording(t *testing.T, t0 time.Time) *Recording {
+	t.Helper()
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	for i := 0; i < 3; i++ {
+		at := t0.Add(time.Duration(i) * 2 * time.Second)
+		recorder.Record(Frame{Time: at, Host: "kvm1", Kind: FrameVMs, VMs: []VMStats{
+			{DomainName: "vm1", CPU: CPUStats{Time: int64(i) * 1e9}, LastUpdate: at.UnixNano()},
+			{DomainName: "vm2", LastUpdate: at.UnixNano()},
+		}})
+	}
+	recorder.Record(Frame{Time: t0.Add(3 * time.Second), Host: "kvm1", Kind: FrameEvent, Event: &DomainEvent{Domain: "vm2", Type: "Stopped", Detail: "Destroyed"}})
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	rec, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecording: %v", err)
+	}
+	return rec
+}
+
+func TestReplayCollector(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rec := testRecording(t, t0)
+
+	tests := []struct {
+		name    string
+		speed   float64
+		loop    bool
+		elapsed time.Duration
+		cpu     int64
+		shift   time.Duration
+	}{
+		{"start", 1, false, 0, 0, 0},
+		{"between frames", 1, false, 3 * time.Second, 1e9, 0},
+		{"accelerated", 2, false, 2 * time.Second, 2e9, 0},
+		{"past the end", 1, false, time.Minute, 2e9, 0},
+		// Samples every 2s up to 4s give a 6s loop
+		{"looped", 1, true, 7 * time.Second, 0, 6 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := rec.Replay(tt.speed, tt.loop)[0]
+			start := time.Now()
+			c.clock.start = start
+			c.clock.now = func() time.Time { return start.Add(tt.elapsed) }
+
+			vms, err := c.GetVMStats(context.Background(), []string{"vm1"})
+			if err != nil {
+				t.Fatalf("GetVMStats: %v", err)
+			}
+			if len(vms) != 1 || vms[0].DomainName != "vm1" {
+				t.Fatalf("expected only vm1, got %+v", vms)
+			}
+			if vms[0].CPU.Time != tt.cpu {
+				t.Errorf("CPU time = %d; expected %d", vms[0].CPU.Time, tt.cpu)
+			}
+			if expected := t0.Add(tt.shift).UnixNano() + tt.cpu*2; vms[0].LastUpdate != expected {
+				t.Errorf("LastUpdate = %d; expected %d", vms[0].LastUpdate, expected)
+			}
+
+			// Callers get their own copy to compute rates into
+			vms[0].CPU.Usage = 50
+			again, _ := c.GetVMStats(context.Background(), []string{"vm1"})
+			if again[0].CPU.Usage != 0 {
+				t.Error("modifying a replayed result changed the recording")
+			}
+		})
+	}
+
+	// Only domain stats and events were recorded
+	c := rec.Replay(1, false)[0]
+	if _, err := c.GetStoragePools(context.Background()); err == nil {
+		t.Error("expected an error for pools missing from the recording")
+	}
+	if _, err := c.GetHostStats(context.Background()); err == nil {
+		t.Error("expected an error for host stats missing from the recording")
+	}
+	if Supports(c, CapPools) {
+		t.Error("expected no pools from a recording without them")
+	}
+	if !Supports(c, CapEvents) {
+		t.Error("expected the recorded events to be offered")
+	}
+}
+
+func TestReplayCollectorHostStatsMissing(t *testing.T) {
+	// Recorded from a collector without host stats
+	rec, err := ReadRecording(strings.NewReader(`{"host":"kvm1","kind":"host","error":""}` + "\n"))
+	if err != nil {
+		t.Fatalf("ReadRecording: %v", err)
+	}
+	if _, err := rec.Replay(1, false)[0].GetHostStats(context.Background()); err == nil {
+		t.Error("expected an error for a host frame without stats")
+	}
+}
+
+func TestReplayCollectorError(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+	recorder.Record(Frame{Host: "kvm1", Kind: FrameVMs, Error: "connection refused"})
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	rec, err := ReadRecording(&buf)
+	if err != nil {
+		t.Fatalf("ReadRecording: %v", err)
+	}
+
+	c := rec.Replay(1, true)[0]
+	if _, err := c.GetVMStats(context.Background(), nil); err == nil || err.Error() != "connection refused" {
+		t.Errorf("expected the recorded error, got %v", err)
+	}
+}
+
+func TestReplayCollectorEvents(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := testRecording(t, t0).Replay(2, true)[0]
+
+	// Record the waits instead of sleeping
+	start := time.Now()
+	var waits []time.Duration
+	c.clock.start = start
+	c.clock.now = func() time.Time { return start }
+	c.clock.advance = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		ch := make(chan time.Time, 1)
+		ch <- start
+		return ch
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.WatchEvents(ctx)
+	if err != nil {
+		t.Fatalf("WatchEvents: %v", err)
+	}
+
+	first, second := <-events, <-events
+	if first.Domain != "vm2" || first.Type != "Stopped" || !first.Time.Equal(t0.Add(3*time.Second)) {
+		t.Errorf("unexpected first event: %+v", first)
+	}
+	// The second loop replays the event one 6s loop later
+	if !second.Time.Equal(t0.Add(9 * time.Second)) {
+		t.Errorf("second event at %v; expected %v", second.Time, t0.Add(9*time.Second))
+	}
+	cancel()
+	for range events {
+	}
+
+	if len(waits) < 2 || waits[0] != 1500*time.Millisecond || waits[1] != 4500*time.Millisecond {
+		t.Errorf("waits = %v; expected [1.5s 4.5s ...] at double speed", waits)
+	}
+}
@@ -0,0 +1,95 @@
+package stats
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPoolInterval is how often storage pools are listed; pool usage
+// changes slowly and listing them is comparatively expensive
+const DefaultPoolInterval = 30 * time.Second
+
+// PoolCollector is implemented by collectors that can list the host's
+// storage pools
+type PoolCollector interface {
+	GetStoragePools(ctx context.Context) ([]StoragePool, error)
+}
+
+// StoragePool is a libvirt storage pool and its usage in bytes
+type StoragePool struct {
+	Name       string
+	Type       string // dir, logical, netfs, ...
+	State      string // running, inactive, building, degraded, inaccessible
+	Path       string // target path volumes live under
+	Capacity   int64
+	Allocation int64
+	Available  int64
+}
+
+// Contains reports whether a disk path lives in the pool, judged by the
+// pool's target path. Pools without a local path (rbd, gluster) match
+// nothing.
+func (p StoragePool) Contains(path string) bool {
+	if p.Path == "" || path == "" {
+		return false
+	}
+	dir := filepath.Clean(p.Path)
+	return strings.HasPrefix(filepath.Clean(path), dir+"/")
+}
+
+// Storage pool states (virStoragePoolState)
+var poolStates = []string{"inactive", "building", "running", "degraded", "inaccessible"}
+
+func poolStateName(state uint32) string {
+	if int(state) < len(poolStates) {
+		return poolStates[state]
+	}
+	return "unknown"
+}
+
+// poolXML is the subset of the storage pool XML that vmstats reads
+type poolXML struct {
+	Type   string `xml:"type,attr"`
+	Target struct {
+		Path string `xml:"path"`
+	} `xml:"target"`
+}
+
+// parsePoolXML returns a pool's type and target path
+func parsePoolXML(xmlDesc string) (poolType, path string, err error) {
+	var doc poolXML
+	if err := xml.Unmarshal([]byte(xmlDesc), &doc); err != nil {
+		return "", "", fmt.Errorf("failed to parse pool XML: %w", err)
+	}
+	return doc.Type, doc.Target.Path, nil
+}
+
+// parsePoolInfo parses `virsh pool-info --bytes` output into pool
+func parsePoolInfo(output string, pool *StoragePool) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		n, _ := strconv.ParseInt(value, 10, 64)
+
+		switch strings.TrimSpace(key) {
+		case "Name":
+			pool.Name = value
+		case "State":
+			pool.State = value
+		case "Capacity":
+			pool.Capacity = n
+		case "Allocation":
+			pool.Allocation = n
+		case "Available":
+			pool.Available = n
+		}
+	}
+}
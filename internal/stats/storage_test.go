@@ -0,0 +1,71 @@
+package stats
+
+import "testing"
+
+func TestParsePoolInfo(t *testing.T) {
+	output := `Name:           default
+UUID:           3f7b4a1e-0c2d-4e5f-8a9b-1c2d3e4f5a6b
+State:          running
+Persistent:     yes
+Autostart:      yes
+Capacity:       107321753600
+Allocation:     64424509440
+Available:      42897244160
+`
+
+	var pool StoragePool
+	parsePoolInfo(output, &pool)
+	expected := StoragePool{
+		Name:       "default",
+		State:      "running",
+		Capacity:   107321753600,
+		Allocation: 64424509440,
+		Available:  42897244160,
+	}
+	if pool != expected {
+		t.Errorf("Expected %+v, got %+v", expected, pool)
+	}
+}
+
+func TestParsePoolXML(t *testing.T) {
+	poolType, path, err := parsePoolXML(`<pool type='dir'>
+  <name>default</name>
+  <target>
+    <path>/var/lib/libvirt/images</path>
+  </target>
+</pool>`)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if poolType != "dir" || path != "/var/lib/libvirt/images" {
+		t.Errorf("Expected dir pool at /var/lib/libvirt/images, got %s at %s", poolType, path)
+	}
+
+	if _, _, err := parsePoolXML("<pool>"); err == nil {
+		t.Error("Expected an error for malformed XML")
+	}
+}
+
+func TestStoragePoolContains(t *testing.T) {
+	pool := StoragePool{Path: "/var/lib/libvirt/images/"}
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"/var/lib/libvirt/images/db.qcow2", true},
+		{"/var/lib/libvirt/images/sub/web.qcow2", true},
+		{"/var/lib/libvirt/images-old/db.qcow2", false},
+		{"/var/lib/libvirt/images", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := pool.Contains(tt.path); got != tt.expected {
+			t.Errorf("Contains(%q) = %v, expected %v", tt.path, got, tt.expected)
+		}
+	}
+
+	if (StoragePool{}).Contains("/var/lib/libvirt/images/db.qcow2") {
+		t.Error("Expected a pool without a target path to contain nothing")
+	}
+}
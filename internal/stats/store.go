@@ -0,0 +1,622 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store resolutions: every sample, and rollups of them
+const (
+	ResolutionRaw    time.Duration = 0
+	ResolutionMinute               = time.Minute
+	ResolutionHour                 = time.Hour
+)
+
+// Store defaults
+const (
+	DefaultRawRetention    = 24 * time.Hour
+	DefaultMinuteRetention = 7 * 24 * time.Hour
+	DefaultHourRetention   = 365 * 24 * time.Hour
+	DefaultStoreMaxBytes   = 256 << 20
+)
+
+// storeQueueLength is how many appends may wait for the disk before new
+// ones are dropped
+const storeQueueLength = 64
+
+// storeResolution describes where one resolution is kept
+type storeResolution struct {
+	step time.Duration
+	dir  string
+	// span is the time covered by one segment file, which is also the
+	// granularity of age-based retention
+	span time.Duration
+}
+
+// storeResolutions are ordered finest first, which is also the order they
+// are given up in when the store outgrows MaxBytes
+var storeResolutions = []storeResolution{
+	{ResolutionRaw, "raw", time.Hour},
+	{ResolutionMinute, "1m", 24 * time.Hour},
+	{ResolutionHour, "1h", 30 * 24 * time.Hour},
+}
+
+// storeRecord is one line of a segment: every series of a domain at one
+// time. Series are named by metric, then "/device" for device metrics.
+// Rollups hold the mean in Values, with the extremes and sample count.
+type storeRecord struct {
+	Time   int64              `json:"t"`
+	Host   string             `json:"h,omitempty"`
+	Domain string             `json:"d"`
+	Values map[string]float64 `json:"v"`
+	Min    map[string]float64 `json:"min,omitempty"`
+	Max    map[string]float64 `json:"max,omitempty"`
+	Count  map[string]int     `json:"n,omitempty"`
+}
+
+// SeriesPoint is one point of a named series
+type SeriesPoint struct {
+	SeriesKey
+	Point
+}
+
+// Store persists metric history in a directory of append-only segment
+// files, one directory per resolution, so vmstats can show past hours right
+// after a restart. Samples are rolled up into 1-minute and 1-hour means as
+// they arrive. Segments older than their resolution's retention are
+// deleted, and when the store outgrows MaxBytes the oldest segments go
+// first, finest resolution first.
+type Store struct {
+	// Dir holds the segments
+	Dir string
+	// RawRetention, MinuteRetention and HourRetention bound how long each
+	// resolution is kept
+	RawRetention    time.Duration
+	MinuteRetention time.Duration
+	HourRetention   time.Duration
+	// MaxBytes bounds the size of all segments together; 0 for no bound
+	MaxBytes int64
+
+	// Appends are written in order by a goroutine of their own, started
+	// on the first one, so a slow disk does not hold up the caller
+	start   sync.Once
+	queue   chan []SeriesPoint
+	drained chan struct{}
+
+	mu      sync.Mutex
+	size    int64
+	writers map[time.Duration]*segmentWriter
+	// Rollups being accumulated per domain, by resolution
+	rollups map[time.Duration]map[SeriesKey]*rollup
+	err     error
+}
+
+// segmentWriter is the segment file currently appended to
+type segmentWriter struct {
+	path  string
+	start int64
+	file  *os.File
+}
+
+// rollup accumulates a domain's series over one bucket
+type rollup struct {
+	start  int64
+	series map[string]*aggregate
+}
+
+// aggregate summarizes the samples of one series in a bucket
+type aggregate struct {
+	sum, min, max float64
+	count         int
+}
+
+func (a *aggregate) add(mean, lo, hi float64, count int) {
+	if a.count == 0 || lo < a.min {
+		a.min = lo
+	}
+	if a.count == 0 || hi > a.max {
+		a.max = hi
+	}
+	a.sum += mean * float64(count)
+	a.count += count
+}
+
+// NewStore creates a store in dir with the default retention
+func NewStore(dir string) *Store {
+	return &Store{
+		Dir:             dir,
+		RawRetention:    DefaultRawRetention,
+		MinuteRetention: DefaultMinuteRetention,
+		HourRetention:   DefaultHourRetention,
+		MaxBytes:        DefaultStoreMaxBytes,
+		writers:         make(map[time.Duration]*segmentWriter),
+		rollups:         make(map[time.Duration]map[SeriesKey]*rollup),
+	}
+}
+
+// retention returns how long a resolution is kept
+func (s *Store) retention(step time.Duration) time.Duration {
+	switch step {
+	case ResolutionRaw:
+		return s.RawRetention
+	case ResolutionMinute:
+		return s.MinuteRetention
+	}
+	return s.HourRetention
+}
+
+// Append queues points to be written as raw samples and folded into the
+// rollups, without waiting for the disk. If the disk falls too far behind
+// the points are dropped. Write errors do not stop later appends; the first
+// one is returned by Close. Append must not be called after Close.
+func (s *Store) Append(points []SeriesPoint) {
+	if len(points) == 0 {
+		return
+	}
+	s.start.Do(func() {
+		s.queue = make(chan []SeriesPoint, storeQueueLength)
+		s.drained = make(chan struct{})
+		go s.writeQueued()
+	})
+
+	select {
+	case s.queue <- points:
+	default:
+		s.mu.Lock()
+		s.keep(fmt.Errorf("failed to keep up with appends, dropped %d points", len(points)))
+		s.mu.Unlock()
+	}
+}
+
+// writeQueued writes queued appends until the queue is closed
+func (s *Store) writeQueued() {
+	defer close(s.drained)
+	for points := range s.queue {
+		s.append(points)
+	}
+}
+
+// append writes points as raw samples and folds them into the rollups
+func (s *Store) append(points []SeriesPoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Points of a domain from one sample share a record
+	type sample struct {
+		host, domain string
+		time         int64
+	}
+	records := make(map[sample]*storeRecord)
+	var order []sample
+	var newest int64
+	for _, p := range points {
+		key := sample{p.Host, p.Domain, p.Time}
+		rec, ok := records[key]
+		if !ok {
+			rec = &storeRecord{Time: p.Time, Host: p.Host, Domain: p.Domain, Values: make(map[string]float64)}
+			records[key] = rec
+			order = append(order, key)
+		}
+		rec.Values[seriesName(p.Metric, p.Device)] = p.Value
+		newest = max(newest, p.Time)
+	}
+	for _, key := range order {
+		rec := records[key]
+		s.keep(s.write(ResolutionRaw, rec))
+		s.rollUp(ResolutionMinute, rec)
+	}
+
+	// Buckets that ended, also those of domains no longer sampled
+	s.flushRollups(ResolutionMinute, newest)
+	s.flushRollups(ResolutionHour, newest)
+
+	if s.MaxBytes > 0 && s.size > s.MaxBytes {
+		s.keep(s.enforceRetention())
+	}
+}
+
+// rollUp folds a record into its bucket at step, writing out the bucket it
+// replaces
+func (s *Store) rollUp(step time.Duration, rec *storeRecord) {
+	domain := SeriesKey{Host: rec.Host, Domain: rec.Domain}
+	start := rec.Time - rec.Time%int64(step)
+
+	buckets := s.rollups[step]
+	if buckets == nil {
+		buckets = make(map[SeriesKey]*rollup)
+		s.rollups[step] = buckets
+	}
+	r := buckets[domain]
+	if r != nil && r.start != start {
+		s.flushRollup(step, domain, r)
+		r = nil
+	}
+	if r == nil {
+		r = &rollup{start: start, series: make(map[string]*aggregate)}
+		buckets[domain] = r
+	}
+
+	for name, value := range rec.Values {
+		a := r.series[name]
+		if a == nil {
+			a = &aggregate{}
+			r.series[name] = a
+		}
+		lo, hi, count := value, value, 1
+		if rec.Count != nil {
+			lo, hi, count = rec.Min[name], rec.Max[name], rec.Count[name]
+		}
+		a.add(value, lo, hi, count)
+	}
+}
+
+// flushRollups writes out the buckets that ended by now (Unix ns)
+func (s *Store) flushRollups(step time.Duration, now int64) {
+	for domain, r := range s.rollups[step] {
+		if r.start+int64(step) <= now {
+			s.flushRollup(step, domain, r)
+		}
+	}
+}
+
+// flushRollup writes a bucket and feeds it to the next coarser resolution
+func (s *Store) flushRollup(step time.Duration, domain SeriesKey, r *rollup) {
+	delete(s.rollups[step], domain)
+
+	rec := &storeRecord{
+		Time:   r.start,
+		Host:   domain.Host,
+		Domain: domain.Domain,
+		Values: make(map[string]float64, len(r.series)),
+		Min:    make(map[string]float64, len(r.series)),
+		Max:    make(map[string]float64, len(r.series)),
+		Count:  make(map[string]int, len(r.series)),
+	}
+	for name, a := range r.series {
+		rec.Values[name] = a.sum / float64(a.count)
+		rec.Min[name], rec.Max[name], rec.Count[name] = a.min, a.max, a.count
+	}
+	s.keep(s.write(step, rec))
+
+	if step == ResolutionMinute {
+		s.rollUp(ResolutionHour, rec)
+	}
+}
+
+// write appends a record to the segment its time falls in
+func (s *Store) write(step time.Duration, rec *storeRecord) error {
+	res := resolutionOf(step)
+	start := rec.Time - rec.Time%int64(res.span)
+
+	w := s.writers[step]
+	if w == nil || w.start != start {
+		if w != nil {
+			if err := w.file.Close(); err != nil {
+				s.keep(fmt.Errorf("failed to close segment: %w", err))
+			}
+			delete(s.writers, step)
+		}
+		dir := filepath.Join(s.Dir, res.dir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create store directory: %w", err)
+		}
+		path := filepath.Join(dir, segmentName(start))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open segment: %w", err)
+		}
+		w = &segmentWriter{path: path, start: start, file: f}
+		s.writers[step] = w
+
+		// Segments age out while vmstats runs; check whenever one is
+		// started, which is also the first write after opening the store
+		s.keep(s.enforceRetention())
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	n, err := w.file.Write(append(line, '\n'))
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write segment %s: %w", w.path, err)
+	}
+	return nil
+}
+
+// keep remembers the first error for Close
+func (s *Store) keep(err error) {
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// storeSegment is a segment file found on disk
+type storeSegment struct {
+	res   storeResolution
+	path  string
+	start int64
+	size  int64
+}
+
+// segments lists the segment files of every resolution, oldest first
+func (s *Store) segments() ([]storeSegment, error) {
+	var segs []storeSegment
+	for _, res := range storeResolutions {
+		entries, err := os.ReadDir(filepath.Join(s.Dir, res.dir))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to list segments: %w", err)
+		}
+		for _, e := range entries {
+			start, ok := parseSegmentName(e.Name())
+			if !ok || e.IsDir() {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue // Removed meanwhile
+			}
+			segs = append(segs, storeSegment{res: res, path: filepath.Join(s.Dir, res.dir, e.Name()), start: start, size: info.Size()})
+		}
+	}
+	sort.SliceStable(segs, func(i, j int) bool { return segs[i].start < segs[j].start })
+	return segs, nil
+}
+
+// enforceRetention deletes segments past their resolution's retention, then
+// the oldest segments until the store fits in MaxBytes, finest resolution
+// first. Segments being written are kept.
+func (s *Store) enforceRetention() error {
+	segs, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	writing := make(map[string]bool, len(s.writers))
+	for _, w := range s.writers {
+		writing[w.path] = true
+	}
+
+	var firstErr error
+	remove := func(seg storeSegment) {
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = fmt.Errorf("failed to remove segment: %w", err)
+		}
+	}
+
+	var kept []storeSegment
+	s.size = 0
+	for _, seg := range segs {
+		end := seg.start + int64(seg.res.span)
+		if !writing[seg.path] && end <= now-int64(s.retention(seg.res.step)) {
+			remove(seg)
+			continue
+		}
+		kept = append(kept, seg)
+		s.size += seg.size
+	}
+
+	if s.MaxBytes <= 0 {
+		return firstErr
+	}
+	for _, res := range storeResolutions {
+		for _, seg := range kept {
+			if s.size <= s.MaxBytes {
+				return firstErr
+			}
+			if seg.res.step == res.step && !writing[seg.path] {
+				remove(seg)
+				s.size -= seg.size
+			}
+		}
+	}
+	return firstErr
+}
+
+// Load reads the points of every series at a resolution from since on,
+// oldest first. Points written more than once for the same time, as
+// rollups are when vmstats restarts within a bucket, are merged.
+func (s *Store) Load(step time.Duration, since time.Time) (map[SeriesKey][]Point, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	from := since.UnixNano()
+	merged := make(map[SeriesKey]map[int64]*aggregate)
+	for _, seg := range segs {
+		if seg.res.step != step || seg.start+int64(seg.res.span) <= from {
+			continue
+		}
+		if err := readSegment(seg.path, from, merged); err != nil {
+			return nil, err
+		}
+	}
+
+	series := make(map[SeriesKey][]Point, len(merged))
+	for key, byTime := range merged {
+		points := make([]Point, 0, len(byTime))
+		for t, a := range byTime {
+			points = append(points, Point{Time: t, Value: a.sum / float64(a.count)})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].Time < points[j].Time })
+		series[key] = points
+	}
+	return series, nil
+}
+
+// readSegment adds the records of a segment from from on to merged. A line
+// cut short by a crash is skipped.
+func readSegment(path string, from int64, merged map[SeriesKey]map[int64]*aggregate) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open segment: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var rec storeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Time < from {
+			continue
+		}
+		for name, value := range rec.Values {
+			metric, device := splitSeriesName(name)
+			key := SeriesKey{Host: rec.Host, Domain: rec.Domain, Metric: metric, Device: device}
+			byTime := merged[key]
+			if byTime == nil {
+				byTime = make(map[int64]*aggregate)
+				merged[key] = byTime
+			}
+			a := byTime[rec.Time]
+			if a == nil {
+				a = &aggregate{}
+				byTime[rec.Time] = a
+			}
+			count := max(rec.Count[name], 1)
+			a.add(value, value, value, count)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read segment %s: %w", path, err)
+	}
+	return nil
+}
+
+// Prefill loads the stored history of the last h.Length() refreshes into h,
+// so the charts are filled from the start. Points are resampled to one per
+// interval, the refresh interval the live points in h arrive at, so stored
+// and live points share a time scale. The coarsest resolution that is still
+// at least as fine as interval is read.
+func (s *Store) Prefill(h *History, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	step := ResolutionRaw
+	for _, res := range storeResolutions {
+		if res.step <= interval {
+			step = res.step
+		}
+	}
+
+	since := time.Now().Add(-time.Duration(h.Length()) * interval)
+	// Include the bucket already in progress at since
+	series, err := s.Load(step, since.Add(-max(step, interval)))
+	if err != nil {
+		return err
+	}
+	for key, points := range series {
+		points = resample(points, interval, since.Add(-interval).UnixNano())
+		for _, p := range points[max(len(points)-h.Length(), 0):] {
+			h.Add(key, p)
+		}
+	}
+	return nil
+}
+
+// resample averages points, oldest first, into one point per interval,
+// stamped with the start of its interval. Intervals without points are
+// left out, and so are those that start at or before after.
+func resample(points []Point, interval time.Duration, after int64) []Point {
+	var out []Point
+	var count int
+	for _, p := range points {
+		start := p.Time - p.Time%int64(interval)
+		if start <= after {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Time == start {
+			out[n-1].Value += (p.Value - out[n-1].Value) / float64(count+1)
+			count++
+			continue
+		}
+		out = append(out, Point{Time: start, Value: p.Value})
+		count = 1
+	}
+	return out
+}
+
+// Close waits for queued appends, writes out the rollups of the current
+// buckets and closes the segments. It returns the first error met since the
+// store was created.
+func (s *Store) Close() error {
+	if s.queue != nil {
+		close(s.queue)
+		<-s.drained
+		s.queue = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Partial buckets are merged with the rest of their bucket when read
+	for domain, r := range s.rollups[ResolutionMinute] {
+		s.flushRollup(ResolutionMinute, domain, r)
+	}
+	for domain, r := range s.rollups[ResolutionHour] {
+		s.flushRollup(ResolutionHour, domain, r)
+	}
+	for step, w := range s.writers {
+		if err := w.file.Close(); err != nil {
+			s.keep(fmt.Errorf("failed to close segment: %w", err))
+		}
+		delete(s.writers, step)
+	}
+	return s.err
+}
+
+// resolutionOf returns the layout of a resolution
+func resolutionOf(step time.Duration) storeResolution {
+	for _, res := range storeResolutions {
+		if res.step == step {
+			return res
+		}
+	}
+	return storeResolutions[0]
+}
+
+// seriesName names a series within a record
+func seriesName(metric, device string) string {
+	if device == "" {
+		return metric
+	}
+	return metric + "/" + device
+}
+
+// splitSeriesName reverses seriesName
+func splitSeriesName(name string) (metric, device string) {
+	metric, device, _ = strings.Cut(name, "/")
+	return metric, device
+}
+
+// segmentName names a segment after the Unix second it starts at
+func segmentName(start int64) string {
+	return strconv.FormatInt(start/int64(time.Second), 10) + ".jsonl"
+}
+
+// parseSegmentName reverses segmentName
+func parseSegmentName(name string) (int64, bool) {
+	sec, err := strconv.ParseInt(strings.TrimSuffix(name, ".jsonl"), 10, 64)
+	if err != nil || !strings.HasSuffix(name, ".jsonl") {
+		return 0, false
+	}
+	return sec * int64(time.Second), true
+}
@@ -0,0 +1,242 @@
+package stats
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storePoints makes a CPU and a disk point for vm1 every interval from
+// start, with the CPU value counting up from 1
+func storePoints(start time.Time, n int, interval time.Duration) []SeriesPoint {
+	var points []SeriesPoint
+	for i := 0; i < n; i++ {
+		at := start.Add(time.Duration(i) * interval).UnixNano()
+		points = append(points,
+			SeriesPoint{SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}, Point{at, float64(i + 1)}},
+			SeriesPoint{SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricDiskRead, Device: "vda"}, Point{at, 100}},
+		)
+	}
+	return points
+}
+
+// appendEach writes points one sample at a time, as the UI appends them,
+// without the queue so the segments can be checked right away
+func appendEach(s *Store, points []SeriesPoint) {
+	for i := 0; i < len(points); i += 2 {
+		s.append(points[i : i+2])
+	}
+}
+
+func TestStoreRollups(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+
+	// Three minutes of samples every 30s, then one in the next hour
+	s := NewStore(dir)
+	appendEach(s, storePoints(start, 6, 30*time.Second))
+	appendEach(s, []SeriesPoint{
+		{SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}, Point{start.Add(time.Hour).UnixNano(), 50}},
+		{SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricDiskRead, Device: "vda"}, Point{start.Add(time.Hour).UnixNano(), 100}},
+	})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	cpu := SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}
+	disk := SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricDiskRead, Device: "vda"}
+	tests := []struct {
+		step     time.Duration
+		expected []float64
+	}{
+		{ResolutionRaw, []float64{1, 2, 3, 4, 5, 6, 50}},
+		{ResolutionMinute, []float64{1.5, 3.5, 5.5, 50}},
+		{ResolutionHour, []float64{3.5, 50}},
+	}
+	for _, tt := range tests {
+		series, err := NewStore(dir).Load(tt.step, start)
+		if err != nil {
+			t.Fatalf("Load(%v): %v", tt.step, err)
+		}
+		points := series[cpu]
+		if len(points) != len(tt.expected) {
+			t.Fatalf("resolution %v: got %+v; expected values %v", tt.step, points, tt.expected)
+		}
+		for i, p := range points {
+			if math.Abs(p.Value-tt.expected[i]) > 1e-9 {
+				t.Errorf("resolution %v point %d = %v; expected %v", tt.step, i, p.Value, tt.expected[i])
+			}
+		}
+		if tt.step == ResolutionMinute && points[1].Time != start.Add(time.Minute).UnixNano() {
+			t.Errorf("expected minute buckets to start on the minute, got %v", time.Unix(0, points[1].Time))
+		}
+		if len(series[disk]) != len(tt.expected) || series[disk][0].Value != 100 {
+			t.Errorf("resolution %v: unexpected disk series %+v", tt.step, series[disk])
+		}
+	}
+}
+
+func TestStoreQueuedAppend(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	points := storePoints(start, 3, time.Second)
+
+	s := NewStore(dir)
+	for i := 0; i < len(points); i += 2 {
+		s.Append(points[i : i+2])
+	}
+	// Close waits for the queue
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	series, err := NewStore(dir).Load(ResolutionRaw, start.Add(-time.Second))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cpu := series[SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}]; len(cpu) != 3 || cpu[2].Value != 3 {
+		t.Errorf("expected the queued samples in order, got %+v", cpu)
+	}
+}
+
+func TestStoreRestartWithinBucket(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	points := storePoints(start, 4, 10*time.Second)
+
+	// vmstats restarts halfway through the minute; each run writes a
+	// partial bucket
+	for _, half := range [][]SeriesPoint{points[:2], points[2:]} {
+		s := NewStore(dir)
+		appendEach(s, half)
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	series, err := NewStore(dir).Load(ResolutionMinute, start)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	merged := series[SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}]
+	if len(merged) != 1 || merged[0].Value != 2.5 {
+		t.Errorf("expected the partial buckets to merge into a mean of 2.5, got %+v", merged)
+	}
+}
+
+func TestStoreTruncatedLine(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	s := NewStore(dir)
+	appendEach(s, storePoints(start, 2, time.Second))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A crash mid-write leaves half a line
+	segs, _ := filepath.Glob(filepath.Join(dir, "raw", "*.jsonl"))
+	if len(segs) != 1 {
+		t.Fatalf("expected one raw segment, got %v", segs)
+	}
+	f, err := os.OpenFile(segs[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"t":1,"d":"vm1","v":{"cp`)
+	_ = f.Close()
+
+	series, err := NewStore(dir).Load(ResolutionRaw, start.Add(-time.Second))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if points := series[SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}]; len(points) != 2 {
+		t.Errorf("expected the complete records to load, got %+v", points)
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-3 * time.Hour)
+
+	// Raw samples are kept for an hour, rollups longer. Segments that aged
+	// out go as soon as the next one starts, without a restart.
+	s := NewStore(dir)
+	s.RawRetention = time.Hour
+	appendEach(s, storePoints(old, 2, time.Second))
+	appendEach(s, storePoints(time.Now(), 1, time.Second))
+	raw, _ := NewStore(dir).Load(ResolutionRaw, old.Add(-time.Hour))
+	if points := raw[SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}]; len(points) != 1 {
+		t.Errorf("expected only the new raw sample to survive, got %+v", points)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	minute, _ := NewStore(dir).Load(ResolutionMinute, old.Add(-time.Hour))
+	if points := minute[SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU}]; len(points) < 2 {
+		t.Errorf("expected the old rollup to be kept, got %+v", points)
+	}
+
+	// Over the size bound, the oldest raw segments go first and the
+	// segments being written stay
+	s = NewStore(dir)
+	s.MaxBytes = 1
+	appendEach(s, storePoints(time.Now().Add(-2*time.Hour), 1, time.Second))
+	appendEach(s, storePoints(time.Now(), 1, time.Second))
+	segs, _ := filepath.Glob(filepath.Join(dir, "raw", "*.jsonl"))
+	current := segmentName(time.Now().Truncate(time.Hour).UnixNano())
+	if len(segs) != 1 || filepath.Base(segs[0]) != current {
+		t.Errorf("expected only the raw segment being written to remain, got %v", segs)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestStorePrefill(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Truncate(time.Minute).Add(-19 * time.Minute)
+
+	s := NewStore(dir)
+	appendEach(s, storePoints(start, 20, time.Minute))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	h := NewHistory(5)
+	if err := NewStore(dir).Prefill(h, time.Minute); err != nil {
+		t.Fatalf("Prefill: %v", err)
+	}
+	points := h.Points(SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU})
+	if len(points) != 5 || points[0].Value != 16 || points[4].Value != 20 {
+		t.Errorf("expected the 5 newest minutes, got %+v", points)
+	}
+}
+
+func TestStorePrefillResample(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Truncate(2 * time.Second).Add(-10 * time.Second)
+
+	// A previous run refreshed every second; this one every 2s
+	s := NewStore(dir)
+	appendEach(s, storePoints(start, 10, time.Second))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	h := NewHistory(5)
+	if err := NewStore(dir).Prefill(h, 2*time.Second); err != nil {
+		t.Fatalf("Prefill: %v", err)
+	}
+	points := h.Points(SeriesKey{Host: "h1", Domain: "vm1", Metric: MetricCPU})
+	expected := []float64{1.5, 3.5, 5.5, 7.5, 9.5}
+	if len(points) != len(expected) {
+		t.Fatalf("expected %d points 2s apart, got %+v", len(expected), points)
+	}
+	for i, p := range points {
+		if p.Value != expected[i] || p.Time != start.Add(time.Duration(i)*2*time.Second).UnixNano() {
+			t.Errorf("point %d = %+v; expected %v at %v", i, p, expected[i], start.Add(time.Duration(i)*2*time.Second))
+		}
+	}
+}
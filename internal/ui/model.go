@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"context"
 	"sort"
 	"time"
 
@@ -12,9 +13,179 @@ import (
 
 type tickMsg time.Time
 
+// hostStatsMsg carries the result of one host's collection
+type hostStatsMsg struct {
+	host  int
+	fetch int
+	stats []stats.VMStats
+	// node is the hypervisor's own usage, nil if the collector cannot
+	// report it
+	node *stats.HostStats
+	err  error
+}
+
+// hostPoolsMsg carries one host's storage pools
+type hostPoolsMsg struct {
+	host  int
+	pools []stats.StoragePool
+	err   error
+}
+
+// hostNetworksMsg carries one host's virtual networks
+type hostNetworksMsg struct {
+	host     int
+	networks []stats.Network
+	err      error
+}
+
+// hostEventsMsg hands over a host's event stream once the watch started
+type hostEventsMsg struct {
+	host   int
+	events <-chan stats.DomainEvent
+}
+
+// domainEventMsg carries one event from a host's event stream
+type domainEventMsg struct {
+	host   int
+	events <-chan stats.DomainEvent
+	event  stats.DomainEvent
+}
+
+// eventRefreshMsg refreshes a host after a burst of domain events
+type eventRefreshMsg struct {
+	host int
+}
+
+// maxEvents bounds how many recent domain events are kept
+const maxEvents = 100
+
+// eventDebounce gathers a burst of domain events, such as a migration or
+// many VMs starting, into one refresh
+const eventDebounce = 500 * time.Millisecond
+
+// Host is a hypervisor monitored by the UI
+type Host struct {
+	// Name labels the host in the sidebar and detail view
+	Name string
+	// URI is the libvirt connection URI, only used for display
+	URI       string
+	Collector stats.StatsCollector
+	// MigrationBandwidth is the host's migration link speed in MiB/s,
+	// used to estimate how hard its VMs are to live-migrate
+	MigrationBandwidth float64
+	// PoolInterval is how often storage pools are listed, which is slower
+	// than the refresh rate; 0 disables the storage view
+	PoolInterval time.Duration
+	// NetworkInterval is how often virtual networks and their DHCP leases
+	// are listed; 0 disables the networks view
+	NetworkInterval time.Duration
+}
+
+// hostState tracks the latest result from one host. A host whose last
+// collection failed is degraded and keeps showing its previous stats.
+type hostState struct {
+	Host
+	stats      []stats.VMStats
+	node       *stats.HostStats
+	err        error
+	lastUpdate time.Time
+
+	// fetch numbers collections so results from a cancelled one are dropped
+	fetch    int
+	inFlight bool
+	cancel   context.CancelFunc
+
+	// stopEvents ends the host's event watch
+	stopEvents context.CancelFunc
+	// eventRefresh is set while a refresh after domain events is pending;
+	// refreshAfter defers it until the collection in flight is done
+	eventRefresh bool
+	refreshAfter bool
+
+	// Storage pools and networks are listed separately on their own,
+	// slower intervals
+	pools        []stats.StoragePool
+	poolsList    listing
+	networks     []stats.Network
+	networksList listing
+}
+
+// listing tracks a host inventory that is refreshed on a slower interval
+// than domain stats
+type listing struct {
+	loaded   bool
+	err      error
+	fetched  time.Time
+	inFlight bool
+}
+
+// start reports whether a listing is due, either because interval has passed
+// since the last one or because force is set, and marks it in flight
+func (l *listing) start(interval time.Duration, force bool) bool {
+	if interval <= 0 || l.inFlight {
+		return false
+	}
+	if !force && time.Since(l.fetched) < interval {
+		return false
+	}
+	l.inFlight = true
+	l.fetched = time.Now()
+	return true
+}
+
+// done records the outcome of a listing; a failed listing keeps showing the
+// previous result
+func (l *listing) done(err error) {
+	l.inFlight = false
+	l.err = err
+	if err == nil {
+		l.loaded = true
+	}
+}
+
+// sortMode orders the VM list; active VMs always come first
+type sortMode int
+
+const (
+	sortByName sortMode = iota
+	sortByCPU
+)
+
+func (s sortMode) String() string {
+	if s == sortByCPU {
+		return "cpu"
+	}
+	return "name"
+}
+
+// detailTab selects what the detail view shows for the selected VM
+type detailTab int
+
+const (
+	tabStats detailTab = iota
+	tabConfig
+	tabStorage
+	tabNetworks
+	tabCount
+)
+
+func (t detailTab) String() string {
+	switch t {
+	case tabConfig:
+		return "Config"
+	case tabStorage:
+		return "Storage"
+	case tabNetworks:
+		return "Networks"
+	}
+	return "Stats"
+}
+
 type keyMap struct {
 	NextVM      key.Binding
 	PrevVM      key.Binding
+	Tab         key.Binding
+	Sort        key.Binding
 	Refresh     key.Binding
 	TogglePause key.Binding
 	Quit        key.Binding
@@ -22,22 +193,21 @@ type keyMap struct {
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
-	return []key.Binding{k.PrevVM, k.NextVM, k.Refresh, k.TogglePause, k.Quit, k.Help}
+	return []key.Binding{k.PrevVM, k.NextVM, k.Tab, k.Sort, k.Refresh, k.TogglePause, k.Quit, k.Help}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.PrevVM, k.NextVM},
+		{k.PrevVM, k.NextVM, k.Tab, k.Sort},
 		{k.Refresh, k.TogglePause, k.Quit, k.Help},
 	}
 }
 
 type Model struct {
-	collector   stats.StatsCollector
+	hosts       []hostState
 	allStats    []stats.VMStats
 	domains     []string
 	currentVM   int
-	err         error
 	quitting    bool
 	lastUpdate  time.Time
 	keys        keyMap
@@ -48,15 +218,31 @@ type Model struct {
 	width       int
 	height      int
 	paused      bool
+	sortBy      sortMode
+	tab         detailTab
+	// events holds recent domain events from all hosts, oldest first
+	events []stats.DomainEvent
+	// history keeps recent samples for sparklines; shared by model copies
+	history *stats.History
+	// store persists what history records, if enabled
+	store *stats.Store
 }
 
-func InitialModel(domains []string, collector stats.StatsCollector, refreshRate time.Duration) Model {
+// InitialModel creates the UI model for one or more hosts. Samples are
+// added to history for sparklines and, when store is not nil, persisted.
+func InitialModel(domains []string, hosts []Host, refreshRate time.Duration, history *stats.History, store *stats.Store) Model {
+	states := make([]hostState, len(hosts))
+	for i, h := range hosts {
+		states[i] = hostState{Host: h}
+	}
 	return Model{
 		domains:     domains,
-		collector:   collector,
+		hosts:       states,
 		keys:        keys,
 		help:        help.New(),
 		refreshRate: refreshRate,
+		history:     history,
+		store:       store,
 	}
 }
 
@@ -69,6 +255,14 @@ var keys = keyMap{
 		key.WithKeys("up", "k", "shift+tab"),
 		key.WithHelp("↑/k", "prev"),
 	),
+	Tab: key.NewBinding(
+		key.WithKeys("t"),
+		key.WithHelp("t", "next tab"),
+	),
+	Sort: key.NewBinding(
+		key.WithKeys("s"),
+		key.WithHelp("s", "sort name/cpu"),
+	),
 	Refresh: key.NewBinding(
 		key.WithKeys("r"),
 		key.WithHelp("r", "refresh"),
@@ -90,7 +284,8 @@ var keys = keyMap{
 func (m Model) Init() tea.Cmd {
 	return tea.Batch(
 		m.tickCmd(),
-		fetchStats(m.collector, m.domains),
+		m.fetchAll(false),
+		m.watchEvents(),
 	)
 }
 
@@ -100,6 +295,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch {
 		case key.Matches(msg, m.keys.Quit):
 			m.quitting = true
+			m.cancelAll()
 			return m, tea.Quit
 		case key.Matches(msg, m.keys.NextVM):
 			if len(m.allStats) > 0 {
@@ -109,10 +305,18 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if len(m.allStats) > 0 {
 				m.currentVM = (m.currentVM - 1 + len(m.allStats)) % len(m.allStats)
 			}
+		case key.Matches(msg, m.keys.Tab):
+			m.tab = (m.tab + 1) % tabCount
+		case key.Matches(msg, m.keys.Sort):
+			m.sortBy = (m.sortBy + 1) % 2
+			for _, h := range m.hosts {
+				sortVMs(h.stats, m.sortBy)
+			}
+			m.rebuildVMList()
 		case key.Matches(msg, m.keys.Help):
 			m.showHelp = !m.showHelp
 		case key.Matches(msg, m.keys.Refresh):
-			return m, fetchStats(m.collector, m.domains)
+			return m, m.fetchAll(true)
 		case key.Matches(msg, m.keys.TogglePause):
 			m.paused = !m.paused
 			return m, nil
@@ -121,39 +325,121 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tickMsg:
 		var cmd tea.Cmd
 		if !m.paused {
-			cmd = fetchStats(m.collector, m.domains)
+			cmd = m.fetchAll(false)
 		}
 		return m, tea.Batch(
 			m.tickCmd(),
 			cmd,
 		)
 
-	case []stats.VMStats:
+	case hostStatsMsg:
+		host := &m.hosts[msg.host]
+		if msg.fetch != host.fetch {
+			// Superseded by a forced refresh
+			return m, nil
+		}
+		host.inFlight = false
+		host.cancel()
+
+		// Events arrived while this collection was running, which may
+		// have started before them
+		var next tea.Cmd
+		if host.refreshAfter {
+			host.refreshAfter = false
+			if !m.paused {
+				next = m.fetchHost(msg.host, false)
+			}
+		}
+
+		if msg.err != nil {
+			host.err = msg.err
+			return m, next
+		}
+
 		// Calculate CPU usage if we have previous stats
-		if len(m.allStats) > 0 {
-			calculateCPUUsage(msg, m.allStats)
+		if len(host.stats) > 0 {
+			stats.CalculateRates(msg.stats, host.stats)
 		}
 
-		sortVMs(msg)
-		m.allStats = msg
-		m.lastUpdate = time.Now()
-		m.err = nil
+		if msg.node != nil && host.node != nil {
+			stats.CalculateHostRates(msg.node, *host.node)
+		}
+		host.node = msg.node
+
+		for i := range msg.stats {
+			msg.stats[i].Host = host.Name
+		}
+		points := m.history.Record(host.Name, msg.stats)
+		if m.store != nil {
+			m.store.Append(points)
+		}
+		sortVMs(msg.stats, m.sortBy)
+		host.stats = msg.stats
+		host.err = nil
+		host.lastUpdate = time.Now()
+
+		m.lastUpdate = host.lastUpdate
 		m.initialized = true
+		m.rebuildVMList()
+		return m, next
+
+	case hostPoolsMsg:
+		host := &m.hosts[msg.host]
+		host.poolsList.done(msg.err)
+		if msg.err == nil {
+			host.pools = msg.pools
+		}
+		return m, nil
 
-		// Reset index if out of bounds (e.g. if VM list shrank)
-		if m.currentVM >= len(m.allStats) {
-			m.currentVM = 0
+	case hostNetworksMsg:
+		host := &m.hosts[msg.host]
+		host.networksList.done(msg.err)
+		if msg.err == nil {
+			host.networks = msg.networks
 		}
 		return m, nil
 
+	case hostEventsMsg:
+		return m, waitForEvent(msg.host, msg.events)
+
+	case domainEventMsg:
+		host := &m.hosts[msg.host]
+		msg.event.Host = host.Name
+		m.events = append(m.events, msg.event)
+		if len(m.events) > maxEvents {
+			m.events = m.events[len(m.events)-maxEvents:]
+		}
+
+		// Refresh the host soon rather than on the next tick, once for
+		// the whole burst
+		cmds := []tea.Cmd{waitForEvent(msg.host, msg.events)}
+		if !host.eventRefresh {
+			host.eventRefresh = true
+			i := msg.host
+			cmds = append(cmds, tea.Tick(eventDebounce, func(time.Time) tea.Msg {
+				return eventRefreshMsg{host: i}
+			}))
+		}
+		return m, tea.Batch(cmds...)
+
+	case eventRefreshMsg:
+		host := &m.hosts[msg.host]
+		host.eventRefresh = false
+		if m.paused {
+			return m, nil
+		}
+		// A collection in flight is left to finish rather than cancelled
+		if host.inFlight {
+			host.refreshAfter = true
+			return m, nil
+		}
+		return m, m.fetchHost(msg.host, false)
+
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
 		m.help.Width = msg.Width
 
-	case error:
-		m.err = msg
-		return m, nil
 	}
 
 	return m, nil
@@ -173,18 +459,206 @@ func (m Model) tickCmd() tea.Cmd {
 	})
 }
 
-func fetchStats(collector stats.StatsCollector, domains []string) tea.Cmd {
+// fetchAll starts a collection on every host. A host still busy with the
+// previous collection is skipped, unless force is set, in which case that
+// collection is cancelled and replaced. Host bookkeeping lives in the shared
+// hosts slice, so this also works on the Model copy Init is called on.
+func (m Model) fetchAll(force bool) tea.Cmd {
+	var cmds []tea.Cmd
+	for i := range m.hosts {
+		host := &m.hosts[i]
+		if cmd := host.fetchPools(i, force); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+		if cmd := host.fetchNetworks(i, force); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+		cmds = append(cmds, m.fetchHost(i, force))
+	}
+	return tea.Batch(cmds...)
+}
+
+// fetchHost starts a collection on one host, with the same rules as fetchAll
+func (m Model) fetchHost(i int, force bool) tea.Cmd {
+	host := &m.hosts[i]
+	if host.inFlight {
+		if !force {
+			return nil
+		}
+		host.cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	host.fetch++
+	host.inFlight = true
+	host.cancel = cancel
+	return fetchStats(ctx, i, host.fetch, host.Collector, m.domains)
+}
+
+// watchEvents starts watching domain events on every host whose collector
+// can stream them
+func (m Model) watchEvents() tea.Cmd {
+	var cmds []tea.Cmd
+	for i := range m.hosts {
+		host := &m.hosts[i]
+		if !stats.Supports(host.Collector, stats.CapEvents) {
+			continue
+		}
+		source := host.Collector.(stats.EventSource)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		host.stopEvents = cancel
+		cmds = append(cmds, func() tea.Msg {
+			events, err := source.WatchEvents(ctx)
+			if err != nil {
+				return nil
+			}
+			return hostEventsMsg{host: i, events: events}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// waitForEvent waits for the next event on a host's stream; nothing more is
+// waited for once the stream is closed
+func waitForEvent(host int, events <-chan stats.DomainEvent) tea.Cmd {
+	return func() tea.Msg {
+		ev, ok := <-events
+		if !ok {
+			return nil
+		}
+		return domainEventMsg{host: host, events: events, event: ev}
+	}
+}
+
+// fetchPools lists the host's storage pools when PoolInterval has passed
+// since the last listing, or right away if force is set. It returns nil when
+// nothing is due or the collector cannot list pools.
+func (h *hostState) fetchPools(host int, force bool) tea.Cmd {
+	if !stats.Supports(h.Collector, stats.CapPools) || !h.poolsList.start(h.PoolInterval, force) {
+		return nil
+	}
+	collector := h.Collector.(stats.PoolCollector)
+	return func() tea.Msg {
+		pools, err := collector.GetStoragePools(context.Background())
+		return hostPoolsMsg{host: host, pools: pools, err: err}
+	}
+}
+
+// fetchNetworks lists the host's virtual networks when NetworkInterval has
+// passed since the last listing, or right away if force is set
+func (h *hostState) fetchNetworks(host int, force bool) tea.Cmd {
+	if !stats.Supports(h.Collector, stats.CapNetworks) || !h.networksList.start(h.NetworkInterval, force) {
+		return nil
+	}
+	collector := h.Collector.(stats.NetworkCollector)
+	return func() tea.Msg {
+		networks, err := collector.GetNetworks(context.Background())
+		return hostNetworksMsg{host: host, networks: networks, err: err}
+	}
+}
+
+// cancelAll abandons every in-flight collection
+func (m Model) cancelAll() {
+	for _, h := range m.hosts {
+		if h.inFlight {
+			h.cancel()
+		}
+		if h.stopEvents != nil {
+			h.stopEvents()
+		}
+	}
+}
+
+// eventsFor returns the most recent events of a VM, newest first
+func (m Model) eventsFor(vm *stats.VMStats, limit int) []stats.DomainEvent {
+	var events []stats.DomainEvent
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		ev := m.events[i]
+		if ev.Host == vm.Host && ev.Domain == vm.DomainName {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+func fetchStats(ctx context.Context, host, fetch int, collector stats.StatsCollector, domains []string) tea.Cmd {
 	return func() tea.Msg {
-		vmStats, err := collector.GetVMStats(domains)
-		if err != nil {
-			return err
+		vmStats, err := collector.GetVMStats(ctx, domains)
+		msg := hostStatsMsg{host: host, fetch: fetch, stats: vmStats, err: err}
+
+		// Host usage is best effort; the panel is hidden without it
+		if err == nil && stats.Supports(collector, stats.CapHostStats) {
+			if node, err := collector.(stats.HostCollector).GetHostStats(ctx); err == nil {
+				msg.node = &node
+			}
+		}
+		return msg
+	}
+}
+
+// rebuildVMList flattens the per-host stats into allStats, grouped by host in
+// the order hosts were given, keeping the same VM selected
+func (m *Model) rebuildVMList() {
+	var selected *stats.VMStats
+	if m.currentVM < len(m.allStats) {
+		selected = &m.allStats[m.currentVM]
+	}
+	selectedHost, selectedName := "", ""
+	if selected != nil {
+		selectedHost, selectedName = selected.Host, selected.DomainName
+	}
+
+	var all []stats.VMStats
+	for _, h := range m.hosts {
+		all = append(all, h.stats...)
+	}
+	m.allStats = all
+
+	m.currentVM = 0
+	for i, vm := range m.allStats {
+		if vm.Host == selectedHost && vm.DomainName == selectedName {
+			m.currentVM = i
+			break
+		}
+	}
+}
+
+// hostOf returns the host a VM was collected from
+func (m Model) hostOf(vm *stats.VMStats) Host {
+	if h := m.hostStateOf(vm); h != nil {
+		return h.Host
+	}
+	return Host{}
+}
+
+// hostStateOf returns the state of the host a VM was collected from
+func (m Model) hostStateOf(vm *stats.VMStats) *hostState {
+	for i := range m.hosts {
+		if m.hosts[i].Name == vm.Host {
+			return &m.hosts[i]
 		}
-		return vmStats
 	}
+	return nil
 }
 
-func sortVMs(vms []stats.VMStats) {
-	sort.Slice(vms, func(i, j int) bool {
+// hostErr returns the first host error, or nil if every host is healthy
+func (m Model) hostErr() error {
+	for _, h := range m.hosts {
+		if h.err != nil {
+			return h.err
+		}
+	}
+	return nil
+}
+
+// multiHost reports whether more than one host is being monitored
+func (m Model) multiHost() bool {
+	return len(m.hosts) > 1
+}
+
+func sortVMs(vms []stats.VMStats, by sortMode) {
+	sort.SliceStable(vms, func(i, j int) bool {
 		// Define priority: Running/Idle/Paused are "active" (priority 0)
 		// Others like Shutoff are "inactive" (priority 1)
 		p1 := getVMPriority(vms[i].State)
@@ -194,6 +668,10 @@ func sortVMs(vms []stats.VMStats) {
 			return p1 < p2
 		}
 
+		if by == sortByCPU && vms[i].CPU.Usage != vms[j].CPU.Usage {
+			return vms[i].CPU.Usage > vms[j].CPU.Usage
+		}
+
 		// Secondary sort by name
 		return vms[i].DomainName < vms[j].DomainName
 	})
@@ -207,47 +685,3 @@ func getVMPriority(state int) int {
 		return 1
 	}
 }
-
-func calculateCPUUsage(newStats, oldStats []stats.VMStats) {
-	// Create map for fast lookup of old stats
-	oldMap := make(map[string]stats.VMStats)
-	for _, vm := range oldStats {
-		oldMap[vm.DomainName] = vm
-	}
-
-	for i := range newStats {
-		vm := &newStats[i]
-		oldVM, ok := oldMap[vm.DomainName]
-		if !ok {
-			continue
-		}
-
-		// Time passed between updates in nanoseconds
-		deltaFuncTime := vm.LastUpdate - oldVM.LastUpdate
-		if deltaFuncTime <= 0 {
-			continue
-		}
-
-		for j := range vm.VCPUStats {
-			if j >= len(oldVM.VCPUStats) {
-				break
-			}
-
-			// CPU time is in nanoseconds
-			deltaCPUTime := vm.VCPUStats[j].Time - oldVM.VCPUStats[j].Time
-			if deltaCPUTime < 0 {
-				continue
-			}
-
-			// Usage % = (delta CPU time / delta Wall time) * 100
-			usage := (float64(deltaCPUTime) / float64(deltaFuncTime)) * 100.0
-
-			// Cap at 100% per core
-			if usage > 100.0 {
-				usage = 100.0
-			}
-
-			vm.VCPUStats[j].Usage = usage
-		}
-	}
-}
@@ -24,6 +24,12 @@ var (
 			Foreground(ColorDanger).
 			Bold(true)
 
+	warningStyle = lipgloss.NewStyle().
+			Foreground(ColorWarning)
+
+	successStyle = lipgloss.NewStyle().
+			Foreground(ColorSuccess)
+
 	boxStyle = lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
 			BorderForeground(ColorBorder).
@@ -35,10 +41,19 @@ var (
 			Padding(0, 1).
 			Width(30)
 
+	hostStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(ColorText)
+
 	selectedVMStyle = lipgloss.NewStyle().
 			Bold(true).
 			Foreground(ColorPrimary)
 
+	activeTabStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(ColorSecondary).
+			Underline(true)
+
 	offlineMessageStyle = lipgloss.NewStyle().
 				Foreground(ColorTextMuted).
 				Italic(true).
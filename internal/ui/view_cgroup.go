@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/crazyuploader/vmstats/internal/stats"
+)
+
+// renderCgroup shows the host-side accounting of the domain's cgroup: memory
+// charged to QEMU, CPU throttling and pressure stall information
+func renderCgroup(vmStats *stats.VMStats, width, innerWidth int, compact bool) string {
+	var sb strings.Builder
+
+	sb.WriteString(headerStyle.Render("🧱 cgroup") + "\n")
+
+	cg := vmStats.Cgroup
+	limit := "unlimited"
+	if cg.MemoryMax > 0 {
+		limit = formatBytes(cg.MemoryMax)
+	}
+	lines := []string{fmt.Sprintf("Memory: %s of %s │ Anon: %s │ File: %s │ Swap: %s",
+		formatBytes(cg.MemoryCurrent), limit, formatBytes(cg.MemoryAnon), formatBytes(cg.MemoryFile), formatBytes(cg.MemorySwap))}
+
+	if cg.MemoryMax > 0 {
+		barWidth := innerWidth - 20
+		if barWidth < 10 {
+			barWidth = 10
+		}
+		usage := float64(cg.MemoryCurrent) / float64(cg.MemoryMax) * 100
+		lines = append(lines, fmt.Sprintf("Limit: %s %s", renderColorBar(usage, barWidth), formatPercent(usage)))
+	}
+
+	lines = append(lines, fmt.Sprintf("Pressure (10s): CPU %s │ Memory %s / %s full │ I/O %s / %s full",
+		formatPressure(cg.CPUPressure.SomeAvg10),
+		formatPressure(cg.MemoryPressure.SomeAvg10), formatPressure(cg.MemoryPressure.FullAvg10),
+		formatPressure(cg.IOPressure.SomeAvg10), formatPressure(cg.IOPressure.FullAvg10)))
+
+	if cg.ThrottledPeriods > 0 {
+		lines = append(lines, warningStyle.Render(fmt.Sprintf("Throttled: %d periods, %s total",
+			cg.ThrottledPeriods, formatDuration(cg.ThrottledTime))))
+	}
+
+	style := boxStyle.Width(width)
+	if compact {
+		style = style.Padding(0, 1)
+	}
+	sb.WriteString(style.Render(strings.Join(lines, "\n")))
+
+	return sb.String()
+}
+
+// formatPressure colors a PSI average; any sustained stall is worth noticing
+func formatPressure(percent float64) string {
+	s := fmt.Sprintf("%.1f%%", percent)
+	switch {
+	case percent >= 25:
+		return errorStyle.Render(s)
+	case percent >= 5:
+		return warningStyle.Render(s)
+	}
+	return s
+}
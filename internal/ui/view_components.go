@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 )
@@ -42,6 +43,60 @@ func renderColorBar(percent float64, width int) string {
 	return "[" + bar + "]"
 }
 
+// renderStackedBar draws a used segment colored by threshold followed by a
+// muted segment for reclaimable memory, so a guest full of page cache is not
+// shown as under pressure
+func renderStackedBar(usedPercent, cachePercent float64, width int) string {
+	used := int(usedPercent / 100 * float64(width))
+	cache := int((usedPercent + cachePercent) / 100 * float64(width))
+	if used < 0 {
+		used = 0
+	}
+	if cache > width {
+		cache = width
+	}
+
+	var color lipgloss.Color
+	switch {
+	case usedPercent >= ThresholdHigh:
+		color = ColorDanger
+	case usedPercent >= ThresholdLow:
+		color = ColorWarning
+	default:
+		color = ColorSuccess
+	}
+
+	usedStyle := lipgloss.NewStyle().Foreground(color)
+	cacheStyle := lipgloss.NewStyle().Foreground(ColorInfo)
+	emptyStyle := lipgloss.NewStyle().Foreground(ColorBorder)
+
+	bar := ""
+	for i := 0; i < width; i++ {
+		switch {
+		case i < used:
+			bar += usedStyle.Render("█")
+		case i < cache:
+			bar += cacheStyle.Render("▓")
+		default:
+			bar += emptyStyle.Render("░")
+		}
+	}
+
+	return "[" + bar + "]"
+}
+
+// formatPercent formats a CPU percentage, yellow from 50% and red from 90%
+func formatPercent(percent float64) string {
+	s := fmt.Sprintf("%.1f%%", percent)
+	switch {
+	case percent >= 90:
+		return errorStyle.Render(s)
+	case percent >= 50:
+		return warningStyle.Render(s)
+	}
+	return s
+}
+
 // formatBytes converts bytes to human-readable string
 func formatBytes(bytes int64) string {
 	const unit = 1024
@@ -56,6 +111,39 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// formatRate formats a bytes-per-second rate
+func formatRate(bytesPerSec float64) string {
+	return formatBytes(int64(bytesPerSec)) + "/s"
+}
+
+// formatLatency formats a per-request latency with a unit suited to its size
+func formatLatency(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "-"
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
+
+// renderLatency colors a disk latency: green under 10ms, yellow under 50ms
+func renderLatency(d time.Duration) string {
+	style := normalStyle
+	switch {
+	case d >= 50*time.Millisecond:
+		style = errorStyle
+	case d >= 10*time.Millisecond:
+		style = warningStyle
+	case d > 0:
+		style = successStyle
+	}
+	return style.Render(formatLatency(d))
+}
+
 // formatDuration converts nanoseconds to human-readable duration
 func formatDuration(ns int64) string {
 	seconds := ns / 1_000_000_000
@@ -69,3 +157,15 @@ func formatDuration(ns int64) string {
 	hours := minutes / 60
 	return fmt.Sprintf("%dh%dm", hours, minutes%60)
 }
+
+// truncate shortens s to at most limit runes, marking the cut with an ellipsis
+func truncate(s string, limit int) string {
+	r := []rune(s)
+	if len(r) <= limit {
+		return s
+	}
+	if limit <= 1 {
+		return string(r[:limit])
+	}
+	return string(r[:limit-1]) + "…"
+}
@@ -0,0 +1,170 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/crazyuploader/vmstats/internal/stats"
+)
+
+// renderTabs shows the detail view tabs with the active one highlighted
+func renderTabs(active detailTab) string {
+	var tabs []string
+	for t := tabStats; t < tabCount; t++ {
+		if t == active {
+			tabs = append(tabs, activeTabStyle.Render(t.String()))
+		} else {
+			tabs = append(tabs, mutedStyle.Render(t.String()))
+		}
+	}
+	return " " + strings.Join(tabs, mutedStyle.Render(" │ "))
+}
+
+// renderConfig shows the domain's configuration as read from its XML
+func renderConfig(vmStats *stats.VMStats, width, innerWidth int, compact bool) string {
+	cfg := vmStats.Config
+	if cfg.MaxVCPUs == 0 && len(cfg.Disks) == 0 && len(cfg.Interfaces) == 0 {
+		return offlineMessageStyle.Width(width).Render("⏳ Configuration not available yet.")
+	}
+
+	spacing := "\n\n"
+	if compact {
+		spacing = "\n"
+	}
+
+	sections := []string{
+		renderConfigDomain(vmStats, width, compact),
+		renderConfigDisks(cfg.Disks, width, innerWidth, compact),
+		renderConfigInterfaces(cfg.Interfaces, width, compact),
+	}
+	if len(cfg.Graphics) > 0 {
+		sections = append(sections, renderConfigGraphics(cfg.Graphics, width, compact))
+	}
+	return strings.Join(sections, spacing)
+}
+
+func configBox(width int, compact bool) func(...string) string {
+	style := boxStyle.Width(width)
+	if compact {
+		style = style.Padding(0, 1)
+	}
+	return style.Render
+}
+
+func renderConfigDomain(vmStats *stats.VMStats, width int, compact bool) string {
+	cfg := vmStats.Config
+
+	vcpus := fmt.Sprintf("vCPUs: %d", cfg.VCPUs)
+	if cfg.MaxVCPUs > cfg.VCPUs {
+		vcpus += fmt.Sprintf(" of %d", cfg.MaxVCPUs)
+	}
+	if cfg.Sockets > 0 {
+		vcpus += fmt.Sprintf(" │ Topology: %d sockets × %d cores × %d threads", cfg.Sockets, cfg.Cores, cfg.Threads)
+		if cfg.Dies > 1 {
+			vcpus += fmt.Sprintf(" (%d dies)", cfg.Dies)
+		}
+	}
+
+	cpu := "CPU: " + valueOr(cfg.CPUMode, "default")
+	if cfg.CPUModel != "" {
+		cpu += " (" + cfg.CPUModel + ")"
+	}
+
+	memory := fmt.Sprintf("Memory: %s max │ Hugepages: ", formatBytes(vmStats.Metadata.MaxMemory*1024))
+	switch {
+	case !cfg.HugePages:
+		memory += "no"
+	case cfg.HugePageSize > 0:
+		memory += formatBytes(cfg.HugePageSize*1024) + " pages"
+	default:
+		memory += "host default size"
+	}
+
+	return headerStyle.Render("🧩 Domain") + "\n" +
+		configBox(width, compact)(vcpus+"\n"+cpu+"\n"+memory)
+}
+
+func renderConfigDisks(disks []stats.DiskConfig, width, innerWidth int, compact bool) string {
+	header := headerStyle.Render("💿 Disks") + "\n"
+	if len(disks) == 0 {
+		return header + configBox(width, compact)(mutedStyle.Render("No disks configured"))
+	}
+
+	// Whatever width is left goes to the source path
+	sourceWidth := innerWidth - 56
+	if sourceWidth < 10 {
+		sourceWidth = 10
+	}
+
+	info := fmt.Sprintf("%-7s %-7s %-7s %-7s %-12s %-8s %s\n",
+		"Target", "Device", "Bus", "Format", "Cache", "IOThread", "Source")
+	info += mutedStyle.Render(strings.Repeat("─", innerWidth))
+	for _, d := range disks {
+		iothread := "-"
+		if d.IOThread > 0 {
+			iothread = fmt.Sprint(d.IOThread)
+		}
+		info += fmt.Sprintf("\n%-7s %-7s %-7s %-7s %-12s %-8s %s",
+			d.Target, d.Device, valueOr(d.Bus, "-"), valueOr(d.Format, "-"), valueOr(d.Cache, "default"),
+			iothread, truncate(valueOr(d.Source, "-"), sourceWidth))
+	}
+
+	return header + configBox(width, compact)(info)
+}
+
+func renderConfigInterfaces(ifaces []stats.InterfaceConfig, width int, compact bool) string {
+	header := headerStyle.Render("🌐 Interfaces") + "\n"
+	if len(ifaces) == 0 {
+		return header + configBox(width, compact)(mutedStyle.Render("No interfaces configured"))
+	}
+
+	var lines []string
+	for _, i := range ifaces {
+		source := ""
+		switch {
+		case i.Network != "":
+			source = "network " + i.Network
+			if i.Bridge != "" {
+				source += " (" + i.Bridge + ")"
+			}
+		case i.Bridge != "":
+			source = i.Type + " " + i.Bridge
+		default:
+			source = i.Type
+		}
+		lines = append(lines, fmt.Sprintf("%-7s %-17s %-8s %s",
+			valueOr(i.Target, "-"), i.MAC, valueOr(i.Model, "-"), source))
+	}
+
+	return header + configBox(width, compact)(strings.Join(lines, "\n"))
+}
+
+func renderConfigGraphics(graphics []stats.GraphicsConfig, width int, compact bool) string {
+	var lines []string
+	for _, g := range graphics {
+		line := strings.ToUpper(g.Type) + " "
+		if g.Port > 0 {
+			line += fmt.Sprintf("%s:%d", valueOr(g.Listen, "*"), g.Port)
+		} else {
+			line += mutedStyle.Render("port not assigned")
+		}
+		if g.TLSPort > 0 {
+			line += fmt.Sprintf(" │ TLS %d", g.TLSPort)
+		}
+		if g.AutoPort {
+			line += mutedStyle.Render(" (autoport)")
+		}
+		lines = append(lines, line)
+	}
+
+	return headerStyle.Render("🖥️  Graphics") + "\n" +
+		configBox(width, compact)(strings.Join(lines, "\n"))
+}
+
+// valueOr returns s, or fallback when s is empty
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
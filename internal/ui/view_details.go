@@ -3,14 +3,21 @@ package ui
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/crazyuploader/vmstats/internal/stats"
 )
 
-func renderMainContent(currentStats *stats.VMStats, stateInfo VMStateInfo, width int, compact bool) string {
+func renderMainContent(m Model, currentStats *stats.VMStats, width int, compact bool) string {
 	var sb strings.Builder
 
+	stateInfo := GetVMStateInfo(currentStats.State)
+	host := ""
+	if m.multiHost() {
+		host = currentStats.Host
+	}
+
 	spacing := "\n\n"
 	if compact {
 		spacing = "\n"
@@ -21,52 +28,190 @@ func renderMainContent(currentStats *stats.VMStats, stateInfo VMStateInfo, width
 	if currentStats.OSType != "" {
 		osType = fmt.Sprintf("• %s ", currentStats.OSType)
 	}
-	titleRaw := fmt.Sprintf(" %s %s %s• %s ", stateInfo.Icon, stateInfo.Text, osType, currentStats.DomainName)
+	hostStr := ""
+	if host != "" {
+		hostStr = fmt.Sprintf("@ %s ", host)
+	}
+	restarted := ""
+	if currentStats.Restarted {
+		restarted = "↻ restarted "
+	}
+	titleRaw := fmt.Sprintf(" %s %s %s• %s %s%s", stateInfo.Icon, stateInfo.Text, osType, currentStats.DomainName, hostStr, restarted)
 	title := titleStyle.Width(width).Render(titleRaw)
-	sb.WriteString(title + spacing)
+	sb.WriteString(title + "\n")
+	if md := renderMetadata(currentStats.Metadata); md != "" {
+		sb.WriteString(md + "\n")
+	}
+	sb.WriteString(renderTabs(m.tab) + "\n")
+	if !compact {
+		sb.WriteString("\n")
+	}
+
+	// Calculate inner width for boxes
+	// Box padding (2) + Border (2) = 4 overhead
+	innerWidth := width - 4
+
+	// Configuration and host inventory are shown whatever the VM's state
+	switch m.tab {
+	case tabConfig:
+		sb.WriteString(renderConfig(currentStats, width, innerWidth, compact))
+		return sb.String()
+	case tabStorage:
+		sb.WriteString(renderStorage(m.hostStateOf(currentStats), currentStats, width, innerWidth, compact))
+		return sb.String()
+	case tabNetworks:
+		sb.WriteString(renderNetworks(m.hostStateOf(currentStats), currentStats, width, compact))
+		return sb.String()
+	}
+
+	// Recent events explain state changes, so they are shown for stopped
+	// VMs too
+	events := m.eventsFor(currentStats, maxShownEvents)
 
 	// If VM is shutoff, show message instead of metrics
 	if currentStats.State == VMStateShutoff {
 		msg := offlineMessageStyle.Width(width).Render("💤 This VM is currently shut off.\n   Metrics will appear when the VM is running.")
 		sb.WriteString(msg)
+		if len(events) > 0 {
+			sb.WriteString(spacing)
+			sb.WriteString(renderEvents(events, width, compact))
+		}
 		return sb.String()
 	}
 
-	// Calculate inner width for boxes
-	// Box padding (2) + Border (2) = 4 overhead
-	innerWidth := width - 4
+	// Memory section; without libvirt only the cgroup's view is known
+	if !currentStats.Cgroup.Collected || currentStats.BalloonStats.Current > 0 {
+		sb.WriteString(renderMemory(currentStats, m.history, width, innerWidth, compact))
+		sb.WriteString(spacing)
+	}
+	if currentStats.Cgroup.Collected {
+		sb.WriteString(renderCgroup(currentStats, width, innerWidth, compact))
+		sb.WriteString(spacing)
+	}
 
-	// Memory section
-	sb.WriteString(renderMemory(currentStats, width, innerWidth, compact))
-	sb.WriteString(spacing)
+	// Migration section, only when dirty rate collection is enabled
+	if currentStats.DirtyRate.Collected {
+		sb.WriteString(renderMigration(currentStats, m.hostOf(currentStats).MigrationBandwidth, width, compact))
+		sb.WriteString(spacing)
+	}
 
 	// CPU section
-	sb.WriteString(renderCPU(currentStats, width, innerWidth, compact))
+	sb.WriteString(renderCPU(currentStats, m.history, width, innerWidth, compact))
 	sb.WriteString(spacing)
 
+	// Perf section, only when perf counters are being collected
+	if currentStats.Perf.Collected {
+		sb.WriteString(renderPerf(currentStats, width, compact))
+		sb.WriteString(spacing)
+	}
+
 	// Disk section
-	sb.WriteString(renderDisk(currentStats, width, innerWidth, compact))
+	sb.WriteString(renderDisk(currentStats, m.history, width, innerWidth, compact))
 	sb.WriteString(spacing)
 
 	// Network section
-	sb.WriteString(renderNetwork(currentStats, width, compact))
+	sb.WriteString(renderNetwork(currentStats, m.history, width, innerWidth, compact))
+
+	// Events section, only once something happened to the VM
+	if len(events) > 0 {
+		sb.WriteString(spacing)
+		sb.WriteString(renderEvents(events, width, compact))
+	}
+
+	return sb.String()
+}
+
+// maxShownEvents is how many of a VM's recent events the stats tab lists
+const maxShownEvents = 5
+
+// renderEvents lists a VM's recent lifecycle and device events, newest first
+func renderEvents(events []stats.DomainEvent, width int, compact bool) string {
+	var sb strings.Builder
+
+	sb.WriteString(headerStyle.Render("📜 Events") + "\n")
+
+	var lines []string
+	for _, ev := range events {
+		lines = append(lines, mutedStyle.Render(ev.Time.Format("15:04:05"))+" "+formatEvent(ev))
+	}
+
+	sb.WriteString(configBox(width, compact)(strings.Join(lines, "\n")))
 
 	return sb.String()
 }
 
-func renderMemory(vmStats *stats.VMStats, width, innerWidth int, compact bool) string {
+// eventText describes an event as virsh does, e.g. "Stopped (Destroyed)"
+func eventText(ev stats.DomainEvent) string {
+	if ev.Detail == "" {
+		return ev.Type
+	}
+	return ev.Type + " (" + ev.Detail + ")"
+}
+
+// formatEvent colors an event by how much attention it needs
+func formatEvent(ev stats.DomainEvent) string {
+	text := eventText(ev)
+	switch ev.Type {
+	case "Crashed":
+		return errorStyle.Render(text)
+	case "Stopped", "Suspended", "Shutdown", "Undefined":
+		return warningStyle.Render(text)
+	default:
+		return normalStyle.Render(text)
+	}
+}
+
+// formatNetErrors shows an interface's errors and drops, flagged while they
+// are still increasing
+func formatNetErrors(total int64, rate float64) string {
+	s := fmt.Sprintf("❌ %d errs", total)
+	if rate > 0 {
+		return warningStyle.Render(fmt.Sprintf("%s (%.1f/s)", s, rate))
+	}
+	return s
+}
+
+// renderMetadata summarizes the domain's static configuration in one line
+func renderMetadata(md stats.DomainMetadata) string {
+	if md.UUID == "" {
+		return ""
+	}
+
+	persistent := "transient"
+	if md.Persistent {
+		persistent = "persistent"
+	}
+	autostart := "off"
+	if md.Autostart {
+		autostart = "on"
+	}
+
+	return mutedStyle.Render(fmt.Sprintf(" 🪪 %s │ vCPUs: %d │ Max Mem: %s │ %s │ Autostart: %s",
+		md.UUID,
+		md.VCPUs,
+		formatBytes(md.MaxMemory*1024),
+		persistent,
+		autostart,
+	))
+}
+
+func renderMemory(vmStats *stats.VMStats, history *stats.History, width, innerWidth int, compact bool) string {
 	var sb strings.Builder
 
 	sb.WriteString(headerStyle.Render("💾 Memory") + "\n")
 
-	totalBytes := vmStats.BalloonStats.Current * 1024
-	usedBytes := (vmStats.BalloonStats.Current - vmStats.BalloonStats.Unused) * 1024
-	freeBytes := vmStats.BalloonStats.Unused * 1024
-	rssBytes := vmStats.BalloonStats.RSS * 1024
+	balloon := vmStats.BalloonStats
+	totalBytes := balloon.Current * 1024
+	freeBytes := balloon.Unused * 1024
+	cacheBytes := balloon.DiskCaches * 1024
+	rssBytes := balloon.RSS * 1024
+
+	usedBytes := balloon.Used() * 1024
 
-	usagePercent := float64(0)
+	usagePercent, cachePercent := float64(0), float64(0)
 	if totalBytes > 0 {
 		usagePercent = float64(usedBytes) / float64(totalBytes) * 100
+		cachePercent = float64(cacheBytes) / float64(totalBytes) * 100
 	}
 
 	// Dynamic bar width
@@ -76,16 +221,46 @@ func renderMemory(vmStats *stats.VMStats, width, innerWidth int, compact bool) s
 	}
 
 	memInfo := fmt.Sprintf(
-		"Total: %s │ Used: %s │ Free: %s │ RSS: %s\n"+
-			"Usage: %s %.1f%%",
+		"Total: %s │ Used: %s │ Cache: %s │ Free: %s │ RSS: %s\n"+
+			"Usage: %s %.1f%% used, %.1f%% cache",
 		formatBytes(totalBytes),
 		formatBytes(usedBytes),
+		formatBytes(cacheBytes),
 		formatBytes(freeBytes),
 		formatBytes(rssBytes),
-		renderColorBar(usagePercent, barWidth),
+		renderStackedBar(usagePercent, cachePercent, barWidth),
 		usagePercent,
+		cachePercent,
 	)
 
+	trendPoints := newest(historyOf(history, vmStats, stats.MetricMemory, ""), trendWidth(innerWidth-30))
+	if trend := renderTrend(trendPoints, len(trendPoints), memTrendScale, formatPlainPercent); trend != "" {
+		memInfo += "\nTrend: " + trend + formatSpan(trendPoints)
+	}
+
+	if balloon.LastUpdate == 0 {
+		memInfo += "\n" + mutedStyle.Render("Guest stats unavailable (balloon stats period not set)")
+	} else {
+		majorFaults := fmt.Sprintf("%.1f/s", balloon.MajorFaultRate)
+		if balloon.MajorFaultRate >= 100 {
+			majorFaults = errorStyle.Render(majorFaults)
+		} else if balloon.MajorFaultRate >= 10 {
+			majorFaults = warningStyle.Render(majorFaults)
+		}
+
+		swap := fmt.Sprintf("⬇ %s ⬆ %s",
+			formatRate(balloon.SwapInRate*1024), formatRate(balloon.SwapOutRate*1024))
+		if balloon.SwapInRate > 0 || balloon.SwapOutRate > 0 {
+			swap = warningStyle.Render(swap)
+		}
+
+		memInfo += fmt.Sprintf("\nSwap: %s │ Major faults: %s", swap, majorFaults)
+		if !compact {
+			memInfo += mutedStyle.Render(fmt.Sprintf(" │ Swapped: ⬇ %s ⬆ %s",
+				formatBytes(balloon.SwapIn*1024), formatBytes(balloon.SwapOut*1024)))
+		}
+	}
+
 	style := boxStyle.Width(width)
 	if compact {
 		style = style.Padding(0, 1)
@@ -95,7 +270,7 @@ func renderMemory(vmStats *stats.VMStats, width, innerWidth int, compact bool) s
 	return sb.String()
 }
 
-func renderCPU(vmStats *stats.VMStats, width, innerWidth int, compact bool) string {
+func renderCPU(vmStats *stats.VMStats, history *stats.History, width, innerWidth int, compact bool) string {
 	var sb strings.Builder
 
 	sb.WriteString(headerStyle.Render("🖥️  CPU") + "\n")
@@ -110,16 +285,32 @@ func renderCPU(vmStats *stats.VMStats, width, innerWidth int, compact bool) stri
 		return sb.String()
 	}
 
-	cpuInfo := fmt.Sprintf("vCPUs: %d\n\n", len(vmStats.VCPUStats))
+	cpuInfo := fmt.Sprintf("vCPUs: %d │ Domain: %s of host", len(vmStats.VCPUStats), formatPercent(vmStats.CPU.Usage))
+	if vmStats.HostCPUs > 0 {
+		cpuInfo += fmt.Sprintf(" (%d CPUs)", vmStats.HostCPUs)
+	}
+	cpuInfo += fmt.Sprintf(" │ Overhead: %.1f%%", vmStats.CPU.OverheadUsage)
+	if !compact {
+		cpuInfo += mutedStyle.Render(fmt.Sprintf(" │ User: %s Sys: %s Emu/IO: %s",
+			formatDuration(vmStats.CPU.User),
+			formatDuration(vmStats.CPU.System),
+			formatDuration(vmStats.CPUOverhead()),
+		))
+	}
+	trendPoints := newest(historyOf(history, vmStats, stats.MetricCPU, ""), trendWidth(innerWidth-30))
+	if trend := renderTrend(trendPoints, len(trendPoints), cpuTrendScale, formatPlainPercent); trend != "" {
+		cpuInfo += "\nTrend: " + trend + formatSpan(trendPoints)
+	}
+	cpuInfo += "\n\n"
 
 	// Adjust column spacing based on width
 	// In compact mode, we hide "I/O Exits" to save width and potential wraps
 	if compact {
-		cpuInfo += fmt.Sprintf("%-5s %-9s %-8s %-12s %-10s\n",
-			"ID", "State", "Usage", "Time", "Exits")
+		cpuInfo += fmt.Sprintf("%-5s %-9s %-8s %-7s %-12s %-18s\n",
+			"ID", "State", "Usage", "Steal", "Time", "Exits")
 	} else {
-		cpuInfo += fmt.Sprintf("%-5s %-9s %-8s %-12s %-10s %-10s\n",
-			"ID", "State", "Usage", "Time", "Exits", "I/O Exits")
+		cpuInfo += fmt.Sprintf("%-5s %-9s %-8s %-7s %-7s %-12s %-18s %-10s\n",
+			"ID", "State", "Usage", "Steal", "Wait", "Time", "Exits", "I/O Exits")
 	}
 	cpuInfo += mutedStyle.Render(strings.Repeat("─", innerWidth)) + "\n"
 
@@ -137,30 +328,38 @@ func renderCPU(vmStats *stats.VMStats, width, innerWidth int, compact bool) stri
 			stateStr = mutedStyle.Render("offline")
 		}
 
-		// Colorize usage
-		usageStr := fmt.Sprintf("%.1f%%", vcpu.Usage)
+		usageStr := formatPercent(vcpu.Usage)
+
+		// Steal is time the guest was runnable but the host ran something
+		// else; a few percent already points at host contention
+		stealStr := fmt.Sprintf("%.1f%%", vcpu.Steal)
 		switch {
-		case vcpu.Usage >= 90:
-			usageStr = errorStyle.Render(usageStr)
-		case vcpu.Usage >= 50:
-			usageStr = lipgloss.NewStyle().Foreground(ColorWarning).Render(usageStr)
+		case vcpu.Steal >= 20:
+			stealStr = errorStyle.Render(stealStr)
+		case vcpu.Steal >= 5:
+			stealStr = warningStyle.Render(stealStr)
 		}
 
+		exitsStr := fmt.Sprintf("%d (%.0f/s)", vcpu.Exits, vcpu.ExitRate)
+
 		if compact {
-			cpuInfo += fmt.Sprintf("%-5d %-9s %-8s %-12s %-10d\n",
+			cpuInfo += fmt.Sprintf("%-5d %-9s %-8s %-7s %-12s %-18s\n",
 				vcpu.ID,
 				stateStr,
 				usageStr,
+				stealStr,
 				formatDuration(vcpu.Time),
-				vcpu.Exits,
+				exitsStr,
 			)
 		} else {
-			cpuInfo += fmt.Sprintf("%-5d %-9s %-8s %-12s %-10d %-10d\n",
+			cpuInfo += fmt.Sprintf("%-5d %-9s %-8s %-7s %-7s %-12s %-18s %-10d\n",
 				vcpu.ID,
 				stateStr,
 				usageStr,
+				stealStr,
+				fmt.Sprintf("%.1f%%", vcpu.WaitUsage),
 				formatDuration(vcpu.Time),
-				vcpu.Exits,
+				exitsStr,
 				vcpu.IOExits,
 			)
 		}
@@ -170,7 +369,119 @@ func renderCPU(vmStats *stats.VMStats, width, innerWidth int, compact bool) stri
 	return sb.String()
 }
 
-func renderDisk(vmStats *stats.VMStats, width, innerWidth int, compact bool) string {
+// renderMigration shows how fast the guest dirties memory and what that
+// means for a pre-copy live migration over the host's migration link
+func renderMigration(vmStats *stats.VMStats, bandwidth float64, width int, compact bool) string {
+	var sb strings.Builder
+
+	sb.WriteString(headerStyle.Render("🚚 Migration") + "\n")
+
+	style := boxStyle.Width(width)
+	if compact {
+		style = style.Padding(0, 1)
+	}
+
+	dirty := vmStats.DirtyRate
+	var info string
+	switch {
+	case dirty.Status == stats.DirtyRateUnstarted && dirty.StartTime == 0:
+		info = mutedStyle.Render("Dirty rate: waiting for the first measurement")
+	case dirty.Status == stats.DirtyRateMeasuring && dirty.MiBPerSec == 0:
+		info = mutedStyle.Render("Dirty rate: measuring…")
+	default:
+		info = fmt.Sprintf("Dirty rate: %d MiB/s", dirty.MiBPerSec)
+		if !compact {
+			age := time.Since(time.Unix(dirty.StartTime, 0)).Round(time.Second)
+			info += mutedStyle.Render(fmt.Sprintf(" (%s, %ds window, %s ago)", dirty.Mode, dirty.Period, age))
+		}
+
+		est := stats.EstimateMigration(vmStats.BalloonStats.Current, float64(dirty.MiBPerSec), bandwidth)
+		if est.Difficulty != "" {
+			difficulty := est.Difficulty
+			switch {
+			case !est.Converges:
+				difficulty = errorStyle.Render(difficulty)
+			case est.Ratio >= 0.5:
+				difficulty = warningStyle.Render(difficulty)
+			default:
+				difficulty = successStyle.Render(difficulty)
+			}
+
+			info += fmt.Sprintf("\nEstimate @ %.0f MiB/s: %s (dirtying %.0f%% of the link)", bandwidth, difficulty, est.Ratio*100)
+			if est.Converges {
+				info += fmt.Sprintf(" ~%s", est.Duration.Round(time.Second))
+			} else {
+				info += mutedStyle.Render(" needs auto-converge or post-copy")
+			}
+		}
+	}
+
+	var local, total float64
+	for _, mon := range vmStats.MemoryBandwidth {
+		for _, node := range mon.Nodes {
+			local += node.LocalRate
+			total += node.TotalRate
+		}
+	}
+	if len(vmStats.MemoryBandwidth) > 0 {
+		info += fmt.Sprintf("\nMemory bandwidth: %s total │ %s local", formatRate(total), formatRate(local))
+	}
+
+	sb.WriteString(style.Render(info))
+	return sb.String()
+}
+
+// renderPerf shows hardware counter ratios; a low IPC together with a high
+// cache-miss rate usually means the VM is fighting a neighbour for cache
+func renderPerf(vmStats *stats.VMStats, width int, compact bool) string {
+	var sb strings.Builder
+
+	sb.WriteString(headerStyle.Render("📈 Perf") + "\n")
+
+	style := boxStyle.Width(width)
+	if compact {
+		style = style.Padding(0, 1)
+	}
+
+	perf := vmStats.Perf
+	if perf.CPUCycles == 0 && perf.CacheReferences == 0 && perf.ContextSwitches == 0 {
+		sb.WriteString(style.Render(mutedStyle.Render(fmt.Sprintf(
+			"No perf events enabled (virsh perf %s --enable cpu_cycles,instructions,cache_references,cache_misses)",
+			vmStats.DomainName))))
+		return sb.String()
+	}
+
+	ipc := "-"
+	if perf.CPUCycles > 0 {
+		ipc = fmt.Sprintf("%.2f", perf.IPC)
+		if perf.IPC > 0 && perf.IPC < 0.5 {
+			ipc = warningStyle.Render(ipc)
+		}
+	}
+
+	missRate := "-"
+	if perf.CacheReferences > 0 {
+		missRate = fmt.Sprintf("%.1f%%", perf.CacheMissRate)
+		switch {
+		case perf.CacheMissRate >= 30:
+			missRate = errorStyle.Render(missRate)
+		case perf.CacheMissRate >= 10:
+			missRate = warningStyle.Render(missRate)
+		}
+	}
+
+	perfInfo := fmt.Sprintf("IPC: %s │ Cache misses: %s │ Ctx switches: %.0f/s",
+		ipc, missRate, perf.ContextSwitchRate)
+	if !compact && perf.BranchInstructions > 0 {
+		perfInfo += mutedStyle.Render(fmt.Sprintf(" │ Branch misses: %.1f%% total",
+			float64(perf.BranchMisses)/float64(perf.BranchInstructions)*100))
+	}
+
+	sb.WriteString(style.Render(perfInfo))
+	return sb.String()
+}
+
+func renderDisk(vmStats *stats.VMStats, history *stats.History, width, innerWidth int, compact bool) string {
 	var sb strings.Builder
 
 	sb.WriteString(headerStyle.Render("💿 Virtual Disks (Host)") + "\n")
@@ -218,10 +529,13 @@ func renderDisk(vmStats *stats.VMStats, width, innerWidth int, compact bool) str
 		}
 
 		diskInfo += fmt.Sprintf(
-			"📀 %s\n"+
+			"📀 %s%s%s\n"+
 				"   Phys: %s / Max: %s %s %.1f%%\n"+
-				"   I/O:  ⬇ %s (%d ops) │ ⬆ %s (%d ops)\n",
+				"   I/O:  ⬇ %s (%d ops) │ ⬆ %s (%d ops)\n"+
+				"   Now:  ⬇ %s %.0f IOPS %s │ ⬆ %s %.0f IOPS %s\n",
 			disk.Name,
+			renderDiskIOThread(vmStats.IOThreadStats, disk.IOThread),
+			renderCounterReset(disk.Reset),
 			formatBytes(disk.Allocation),
 			formatBytes(disk.Capacity),
 			renderColorBar(usagePercent, barWidth),
@@ -230,14 +544,58 @@ func renderDisk(vmStats *stats.VMStats, width, innerWidth int, compact bool) str
 			disk.ReadReqs,
 			formatBytes(disk.WriteBytes),
 			disk.WriteReqs,
+			formatRate(disk.ReadBytesRate),
+			disk.ReadIOPS,
+			renderLatency(disk.ReadLatency),
+			formatRate(disk.WriteBytesRate),
+			disk.WriteIOPS,
+			renderLatency(disk.WriteLatency),
 		)
+		if !compact {
+			diskInfo += fmt.Sprintf("   Flush: %.0f/s %s (%d total)\n",
+				disk.FlushIOPS, renderLatency(disk.FlushLatency), disk.FlushReqs)
+			diskInfo += renderRateTrends(
+				historyOf(history, vmStats, stats.MetricDiskRead, disk.Name),
+				historyOf(history, vmStats, stats.MetricDiskWrite, disk.Name),
+				innerWidth)
+		}
+	}
+
+	// IOThreads that serve none of the disks are worth knowing about when
+	// tuning, since they cost a host thread each
+	for _, thread := range vmStats.IOThreadStats {
+		serves := false
+		for _, disk := range vmStats.BlockStats {
+			if disk.IOThread == thread.ID {
+				serves = true
+				break
+			}
+		}
+		if !serves {
+			diskInfo += mutedStyle.Render(fmt.Sprintf("⚙️  iothread %d serves no disks\n", thread.ID))
+		}
 	}
 
 	sb.WriteString(style.Render(diskInfo))
 	return sb.String()
 }
 
-func renderNetwork(vmStats *stats.VMStats, width int, compact bool) string {
+// renderDiskIOThread describes the IOThread serving a disk and its adaptive
+// polling settings; disks on the main loop get nothing
+func renderDiskIOThread(threads []stats.IOThreadStats, id int) string {
+	if id == 0 {
+		return ""
+	}
+	for _, thread := range threads {
+		if thread.ID == id {
+			return mutedStyle.Render(fmt.Sprintf(" · iothread %d (poll max %s, grow %d, shrink %d)",
+				id, formatLatency(time.Duration(thread.PollMaxNs)), thread.PollGrow, thread.PollShrink))
+		}
+	}
+	return mutedStyle.Render(fmt.Sprintf(" · iothread %d", id))
+}
+
+func renderNetwork(vmStats *stats.VMStats, history *stats.History, width, innerWidth int, compact bool) string {
 	var sb strings.Builder
 
 	sb.WriteString(headerStyle.Render("🌐 Network") + "\n")
@@ -258,27 +616,70 @@ func renderNetwork(vmStats *stats.VMStats, width int, compact bool) string {
 			continue
 		}
 
-		ipStr := ""
-		if len(net.IPs) > 0 {
-			ipStr = fmt.Sprintf("   📍 IPs: %s\n", strings.Join(net.IPs, ", "))
-		}
+		ipStr := renderIPs(net.IPs)
 
 		netInfo += fmt.Sprintf(
-			"📡 %s\n"+
+			"📡 %s%s\n"+
 				"%s"+
-				"   ⬇ Rx: %s (%d pkts) │ ❌ %d errs\n"+
-				"   ⬆ Tx: %s (%d pkts) │ ❌ %d errs\n",
+				"   ⬇ Rx: %s (%d pkts) │ Now: %s %.0f pkt/s │ %s\n"+
+				"   ⬆ Tx: %s (%d pkts) │ Now: %s %.0f pkt/s │ %s\n",
 			net.Name,
+			renderCounterReset(net.Reset),
 			ipStr,
 			formatBytes(net.RxBytes),
 			net.RxPackets,
-			net.RxErrs+net.RxDrop,
+			formatRate(net.RxBytesRate),
+			net.RxPacketsRate,
+			formatNetErrors(net.RxErrs+net.RxDrop, net.RxErrsRate+net.RxDropRate),
 			formatBytes(net.TxBytes),
 			net.TxPackets,
-			net.TxErrs+net.TxDrop,
+			formatRate(net.TxBytesRate),
+			net.TxPacketsRate,
+			formatNetErrors(net.TxErrs+net.TxDrop, net.TxErrsRate+net.TxDropRate),
 		)
+		if !compact {
+			netInfo += renderRateTrends(
+				historyOf(history, vmStats, stats.MetricNetRx, net.Name),
+				historyOf(history, vmStats, stats.MetricNetTx, net.Name),
+				innerWidth)
+		}
 	}
 
 	sb.WriteString(style.Render(netInfo))
 	return sb.String()
 }
+
+// renderCounterReset notes a device whose counters started over, which is
+// why its rates read zero for one refresh
+func renderCounterReset(reset bool) string {
+	if !reset {
+		return ""
+	}
+	return mutedStyle.Render(" · counters reset")
+}
+
+// renderIPs lists an interface's addresses, one line per address family,
+// each tagged with the source it was discovered through
+func renderIPs(ips []stats.IPAddress) string {
+	var v4, v6 []string
+	for _, ip := range ips {
+		addr := fmt.Sprintf("%s/%d", ip.Address, ip.Prefix)
+		if ip.Source != "" {
+			addr += mutedStyle.Render(" (" + ip.Source + ")")
+		}
+		if ip.Family == "ipv6" {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+
+	var sb strings.Builder
+	if len(v4) > 0 {
+		sb.WriteString(fmt.Sprintf("   📍 IPv4: %s\n", strings.Join(v4, ", ")))
+	}
+	if len(v6) > 0 {
+		sb.WriteString(fmt.Sprintf("   📍 IPv6: %s\n", strings.Join(v6, ", ")))
+	}
+	return sb.String()
+}
@@ -0,0 +1,109 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/crazyuploader/vmstats/internal/stats"
+)
+
+// sparkBlocks are the heights a sparkline cell can take, lowest first
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Full scale of sparklines, so idle series stay flat instead of magnifying
+// noise
+const (
+	cpuTrendScale  = 10.0  // %
+	rateTrendScale = 1024  // bytes/s
+	memTrendScale  = 100.0 // %
+)
+
+// sidebarTrendWidth is the width of the CPU trend beside each VM
+const sidebarTrendWidth = 6
+
+// historyOf returns the recorded points of one of a VM's series
+func historyOf(history *stats.History, vm *stats.VMStats, metric, device string) []stats.Point {
+	if history == nil {
+		return nil
+	}
+	return history.Points(stats.SeriesKey{Host: vm.Host, Domain: vm.DomainName, Metric: metric, Device: device})
+}
+
+// renderSparkline draws the newest width points, newest on the right. The
+// scale is the peak of the points shown, or minScale if that is larger.
+// Missing history is padded with spaces so sparklines line up.
+func renderSparkline(points []stats.Point, width int, minScale float64) string {
+	points = newest(points, width)
+	scale := max(peakOf(points), minScale)
+
+	var sb strings.Builder
+	sb.WriteString(strings.Repeat(" ", width-len(points)))
+	for _, p := range points {
+		level := 0
+		if scale > 0 {
+			level = int(p.Value / scale * float64(len(sparkBlocks)-1))
+		}
+		level = min(max(level, 0), len(sparkBlocks)-1)
+		sb.WriteRune(sparkBlocks[level])
+	}
+	return lipgloss.NewStyle().Foreground(ColorSecondary).Render(sb.String())
+}
+
+// renderTrend draws a sparkline followed by the peak it is scaled to, or
+// nothing until there are two points to compare
+func renderTrend(points []stats.Point, width int, minScale float64, format func(float64) string) string {
+	if len(points) < 2 {
+		return ""
+	}
+	points = newest(points, width)
+	return renderSparkline(points, width, minScale) + mutedStyle.Render(" peak "+format(peakOf(points)))
+}
+
+// renderRateTrends draws the download/read and upload/write trends of a
+// device on one line, or nothing until there is history
+func renderRateTrends(in, out []stats.Point, innerWidth int) string {
+	// Room for the labels and peaks around the two sparklines
+	width := trendWidth((innerWidth - 50) / 2)
+	inTrend := renderTrend(in, width, rateTrendScale, formatRate)
+	outTrend := renderTrend(out, width, rateTrendScale, formatRate)
+	if inTrend == "" && outTrend == "" {
+		return ""
+	}
+	return "   Trend: ⬇ " + inTrend + " │ ⬆ " + outTrend + "\n"
+}
+
+// formatPlainPercent formats a percentage without threshold colors
+func formatPlainPercent(percent float64) string {
+	return fmt.Sprintf("%.1f%%", percent)
+}
+
+// formatSpan describes how much time a series covers
+func formatSpan(points []stats.Point) string {
+	if len(points) < 2 {
+		return ""
+	}
+	return mutedStyle.Render(" over " + formatDuration(points[len(points)-1].Time-points[0].Time))
+}
+
+// newest returns the last n points
+func newest(points []stats.Point, n int) []stats.Point {
+	if len(points) > n {
+		return points[len(points)-n:]
+	}
+	return points
+}
+
+// trendWidth fits a sparkline into the space left on a line
+func trendWidth(available int) int {
+	return min(max(available, 8), 60)
+}
+
+// peakOf returns the largest value among points
+func peakOf(points []stats.Point) float64 {
+	peak := 0.0
+	for _, p := range points {
+		peak = max(peak, p.Value)
+	}
+	return peak
+}
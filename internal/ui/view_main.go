@@ -14,8 +14,10 @@ func renderView(m Model) string {
 		return renderTooSmall(m.width, m.height)
 	}
 
-	if m.err != nil {
-		return errorStyle.Render(fmt.Sprintf("⚠️  Error: %v\n", m.err)) +
+	// Only take over the screen when no host has anything to show; a
+	// failing host next to healthy ones is shown as degraded in the sidebar
+	if err := m.hostErr(); err != nil && len(m.allStats) == 0 {
+		return errorStyle.Render(fmt.Sprintf("⚠️  Error: %v\n", err)) +
 			mutedStyle.Render("\nPress 'r' to retry, 'q' to quit\n")
 	}
 
@@ -24,13 +26,12 @@ func renderView(m Model) string {
 	}
 
 	currentStats := &m.allStats[m.currentVM]
-	stateInfo := GetVMStateInfo(currentStats.State)
 
 	// Determine layout mode
 	compactMode := m.height < 45
 
-	// Reserved lines: Help (1) + Footer (1) + padding (2) = 4
-	reservedLines := 4
+	// Reserved lines: Header (1) + Help (1) + Footer (1) + padding (2) = 5
+	reservedLines := 5
 	contentHeight := m.height - reservedLines
 	if contentHeight < 15 {
 		contentHeight = 15
@@ -45,7 +46,7 @@ func renderView(m Model) string {
 
 	// Main layout: sidebar + content
 	sidebar := renderVMList(m, contentHeight)
-	content := renderMainContent(currentStats, stateInfo, contentWidth, compactMode)
+	content := renderMainContent(m, currentStats, contentWidth, compactMode)
 
 	// Combine sidebar and content horizontally, then constrain height
 	mainView := lipgloss.JoinHorizontal(lipgloss.Top, sidebar, "  ", content)
@@ -64,23 +65,55 @@ func renderView(m Model) string {
 			"• Colors: %s <50%%, %s 50-90%%, %s >90%%\n"+
 			"• Phys: Physical disk space used on host\n"+
 			"• Max: Maximum virtual disk size\n"+
-			"• RSS: Resident Set Size (RAM used)",
+			"• RSS: Resident Set Size (RAM used)\n"+
+			"• Cache: Guest page cache (%s), reclaimable so not counted as used\n"+
+			"• Steal: vCPU runnable but waiting for a host CPU (host contention)\n"+
+			"• (N×): active VMs' vCPUs or memory relative to the host's; over 1× is overcommitted",
 			headerStyle.Render("Legend"),
 			lipgloss.NewStyle().Foreground(ColorSuccess).Render("Green"),
 			lipgloss.NewStyle().Foreground(ColorWarning).Render("Yellow"),
 			lipgloss.NewStyle().Foreground(ColorDanger).Render("Red"),
+			lipgloss.NewStyle().Foreground(ColorInfo).Render("blue"),
 		)
 		helpView += mutedStyle.Render(legend)
 	}
 	footer.WriteString(mutedStyle.Render(helpView) + "\n")
 
 	lastUpdated := fmt.Sprintf("Last updated: %s", m.lastUpdate.Format("15:04:05"))
+	if len(m.events) > 0 {
+		ev := m.events[len(m.events)-1]
+		lastUpdated += fmt.Sprintf(" │ Last event: %s %s %s", ev.Time.Format("15:04:05"), ev.Domain, eventText(ev))
+	}
 	if m.paused {
 		lastUpdated += " " + errorStyle.Render("[PAUSED]")
 	}
 	footer.WriteString(mutedStyle.Render(lastUpdated))
 
-	return mainViewStyled + "\n" + footer.String()
+	return renderHeader(m) + "\n" + mainViewStyled + "\n" + footer.String()
+}
+
+// renderHeader shows which libvirt connections are being monitored
+func renderHeader(m Model) string {
+	header := headerStyle.Render("vmstats")
+	if !m.multiHost() {
+		uri := m.hosts[0].URI
+		if uri == "" {
+			uri = "default connection"
+		}
+		return header + mutedStyle.Render(" • 🔌 "+uri)
+	}
+
+	degraded := 0
+	for _, h := range m.hosts {
+		if h.err != nil {
+			degraded++
+		}
+	}
+	header += mutedStyle.Render(fmt.Sprintf(" • 🔌 %d hosts", len(m.hosts)))
+	if degraded > 0 {
+		header += " " + warningStyle.Render(fmt.Sprintf("(%d degraded)", degraded))
+	}
+	return header
 }
 
 func renderTooSmall(w, h int) string {
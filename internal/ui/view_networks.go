@@ -0,0 +1,113 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/crazyuploader/vmstats/internal/stats"
+)
+
+// attachedIface is a VM interface plugged into a network
+type attachedIface struct {
+	vm       string
+	iface    stats.InterfaceStats
+	selected bool
+}
+
+// renderNetworks lists the virtual networks of the selected VM's host with
+// the VM interfaces attached to each and the DHCP leases handed out
+func renderNetworks(host *hostState, vmStats *stats.VMStats, width int, compact bool) string {
+	header := headerStyle.Render("🔀 Virtual Networks") + "\n"
+	box := configBox(width, compact)
+
+	switch {
+	case host == nil || host.NetworkInterval <= 0:
+		return header + box(mutedStyle.Render("Network collection is disabled"))
+	case !host.networksList.loaded && host.networksList.err != nil:
+		return header + box(errorStyle.Render("⚠️  "+host.networksList.err.Error()))
+	case !host.networksList.loaded:
+		if !stats.Supports(host.Collector, stats.CapNetworks) {
+			return header + box(mutedStyle.Render("This collector cannot list networks"))
+		}
+		return header + box(mutedStyle.Render("⏳ Loading networks..."))
+	case len(host.networks) == 0:
+		return header + box(mutedStyle.Render("No virtual networks defined"))
+	}
+
+	// Lease MACs are resolved to the VM interfaces that own them
+	owners := make(map[string]string)
+	for _, vm := range host.stats {
+		for _, iface := range vm.InterfaceStats {
+			if iface.MAC != "" {
+				owners[strings.ToLower(iface.MAC)] = vm.DomainName + "/" + iface.Name
+			}
+		}
+	}
+
+	var sections []string
+	for _, n := range host.networks {
+		var attached []attachedIface
+		for _, vm := range host.stats {
+			for _, iface := range vm.InterfaceStats {
+				if iface.Network == n.Name || (n.Bridge != "" && iface.Bridge == n.Bridge) {
+					attached = append(attached, attachedIface{vm: vm.DomainName, iface: iface, selected: vm.DomainName == vmStats.DomainName})
+				}
+			}
+		}
+		sections = append(sections, renderVirtualNetwork(n, attached, owners))
+	}
+	info := strings.Join(sections, "\n\n")
+	if host.networksList.err != nil {
+		info += "\n\n" + warningStyle.Render("⚠️  Last refresh failed: "+host.networksList.err.Error())
+	}
+
+	return header + box(info)
+}
+
+// renderVirtualNetwork shows one network, its attached interfaces and its leases
+func renderVirtualNetwork(n stats.Network, attached []attachedIface, owners map[string]string) string {
+	state := "inactive"
+	if n.Active {
+		state = "active"
+	}
+	lines := []string{fmt.Sprintf("%s %s", hostStyle.Render(n.Name),
+		mutedStyle.Render(fmt.Sprintf("(%s, %s) %s", valueOr(n.ForwardMode, "?"), state, n.Bridge)))}
+
+	if len(attached) == 0 {
+		lines = append(lines, mutedStyle.Render("  No VM interfaces attached"))
+	}
+	for _, a := range attached {
+		marker := "  "
+		style := normalStyle
+		if a.selected {
+			marker = "▶ "
+			style = selectedVMStyle
+		}
+		lines = append(lines, style.Render(fmt.Sprintf("  %s%-24s %-17s %s", marker,
+			truncate(a.vm+"/"+a.iface.Name, 24), a.iface.MAC, valueOr(a.iface.Model, ""))))
+	}
+
+	if !n.Active {
+		return strings.Join(lines, "\n")
+	}
+	if len(n.Leases) == 0 {
+		lines = append(lines, mutedStyle.Render("  No DHCP leases"))
+		return strings.Join(lines, "\n")
+	}
+
+	lines = append(lines, mutedStyle.Render(fmt.Sprintf("  %-17s %-22s %-16s %-20s %s", "Lease MAC", "Address", "Hostname", "VM", "Expires")))
+	for _, l := range n.Leases {
+		expires := "-"
+		if !l.Expiry.IsZero() {
+			expires = l.Expiry.Format("Jan 2 15:04")
+		}
+		// Pad before styling so escape codes do not skew the columns
+		owner := fmt.Sprintf("%-20s", truncate(owners[strings.ToLower(l.MAC)], 20))
+		if strings.TrimSpace(owner) == "" {
+			owner = mutedStyle.Render(fmt.Sprintf("%-20s", "unknown"))
+		}
+		lines = append(lines, fmt.Sprintf("  %-17s %-22s %-16s %s %s",
+			l.MAC, fmt.Sprintf("%s/%d", l.IP.Address, l.IP.Prefix), truncate(valueOr(l.Hostname, "-"), 16), owner, expires))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/crazyuploader/vmstats/internal/stats"
+)
+
+// poolDisk is a VM disk found in a storage pool
+type poolDisk struct {
+	vm       string
+	disk     stats.BlockStats
+	selected bool
+}
+
+// renderStorage lists the storage pools of the selected VM's host, each with
+// the VM disks that live in it, so pools filling up can be traced to guests
+func renderStorage(host *hostState, vmStats *stats.VMStats, width, innerWidth int, compact bool) string {
+	header := headerStyle.Render("🗄️  Storage Pools") + "\n"
+	box := configBox(width, compact)
+
+	switch {
+	case host == nil || host.PoolInterval <= 0:
+		return header + box(mutedStyle.Render("Storage pool collection is disabled"))
+	case !host.poolsList.loaded && host.poolsList.err != nil:
+		return header + box(errorStyle.Render("⚠️  "+host.poolsList.err.Error()))
+	case !host.poolsList.loaded:
+		if !stats.Supports(host.Collector, stats.CapPools) {
+			return header + box(mutedStyle.Render("This collector cannot list storage pools"))
+		}
+		return header + box(mutedStyle.Render("⏳ Loading storage pools..."))
+	case len(host.pools) == 0:
+		return header + box(mutedStyle.Render("No storage pools defined"))
+	}
+
+	// Assign every disk on the host to the first pool that contains it
+	disks := make([][]poolDisk, len(host.pools))
+	var unpooled []string
+	for _, vm := range host.stats {
+		for _, b := range vm.BlockStats {
+			found := false
+			for i, pool := range host.pools {
+				if pool.Contains(b.Path) {
+					disks[i] = append(disks[i], poolDisk{vm: vm.DomainName, disk: b, selected: vm.DomainName == vmStats.DomainName})
+					found = true
+					break
+				}
+			}
+			if !found && vm.DomainName == vmStats.DomainName && b.Path != "" {
+				unpooled = append(unpooled, b.Name)
+			}
+		}
+	}
+
+	barWidth := innerWidth - 60
+	if barWidth < 10 {
+		barWidth = 10
+	}
+
+	var sections []string
+	for i, pool := range host.pools {
+		sections = append(sections, renderPool(pool, disks[i], barWidth))
+	}
+	info := strings.Join(sections, "\n\n")
+	if len(unpooled) > 0 {
+		info += "\n\n" + mutedStyle.Render(fmt.Sprintf("%s: %s not in any pool", vmStats.DomainName, strings.Join(unpooled, ", ")))
+	}
+	if host.poolsList.err != nil {
+		info += "\n\n" + warningStyle.Render("⚠️  Last refresh failed: "+host.poolsList.err.Error())
+	}
+
+	return header + box(info)
+}
+
+// renderPool shows a pool's usage and the VM disks in it, largest first
+func renderPool(pool stats.StoragePool, disks []poolDisk, barWidth int) string {
+	title := fmt.Sprintf("%s %s", hostStyle.Render(pool.Name), mutedStyle.Render(fmt.Sprintf("(%s, %s) %s", valueOr(pool.Type, "?"), valueOr(pool.State, "unknown"), pool.Path)))
+	if pool.State != "running" {
+		return title
+	}
+
+	usage := 0.0
+	if pool.Capacity > 0 {
+		usage = float64(pool.Allocation) / float64(pool.Capacity) * 100
+	}
+	lines := []string{
+		title,
+		fmt.Sprintf("%s %s │ Alloc: %s │ Avail: %s │ Cap: %s",
+			renderColorBar(usage, barWidth), formatPercent(usage),
+			formatBytes(pool.Allocation), formatBytes(pool.Available), formatBytes(pool.Capacity)),
+	}
+
+	sort.SliceStable(disks, func(i, j int) bool {
+		return disks[i].disk.Allocation > disks[j].disk.Allocation
+	})
+	for _, d := range disks {
+		marker := "  "
+		style := normalStyle
+		if d.selected {
+			marker = "▶ "
+			style = selectedVMStyle
+		}
+		share := ""
+		if pool.Allocation > 0 {
+			share = fmt.Sprintf(" (%.1f%% of pool)", float64(d.disk.Allocation)/float64(pool.Allocation)*100)
+		}
+		lines = append(lines, style.Render(fmt.Sprintf("  %s%-24s %10s of %-10s", marker,
+			truncate(d.vm+"/"+d.disk.Name, 24), formatBytes(d.disk.Allocation), formatBytes(d.disk.Capacity)))+mutedStyle.Render(share))
+	}
+	if len(disks) == 0 {
+		lines = append(lines, mutedStyle.Render("  No VM disks"))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -3,46 +3,161 @@ package ui
 import (
 	"fmt"
 	"strings"
+
+	"github.com/crazyuploader/vmstats/internal/stats"
 )
 
 func renderVMList(m Model, height int) string {
 	var sb strings.Builder
-	sb.WriteString(headerStyle.Render("📋 VMs") + "\n")
+	title := "📋 VMs"
+	if m.sortBy != sortByName {
+		title += mutedStyle.Render(" by " + m.sortBy.String())
+	}
+	sb.WriteString(headerStyle.Render(title) + "\n")
+
+	if !m.multiHost() {
+		sb.WriteString(renderVMItems(m, m.allStats, 0, false) + "\n")
+		sb.WriteString(renderVMSummary(m.allStats))
+		if node := m.hosts[0].node; node != nil {
+			sb.WriteString("\n" + renderHostSummary(m.allStats, node, true))
+		}
+		return vmListStyle.Height(height).Render(sb.String())
+	}
+
+	// Group VMs by host; allStats holds each host's VMs in host order
+	offset := 0
+	for i, h := range m.hosts {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+
+		if h.err != nil {
+			sb.WriteString(warningStyle.Render("⚠️  "+h.Name+" (degraded)") + "\n")
+			sb.WriteString(mutedStyle.Render("  "+truncate(h.err.Error(), 26)) + "\n")
+		} else {
+			sb.WriteString(hostStyle.Render("🖥️  "+h.Name) + "\n")
+		}
+
+		if len(h.stats) > 0 {
+			sb.WriteString(renderVMItems(m, h.stats, offset, h.err != nil) + "\n")
+		}
+		sb.WriteString(renderVMSummary(h.stats))
+		if h.node != nil {
+			sb.WriteString("\n" + renderHostSummary(h.stats, h.node, false))
+		}
+		offset += len(h.stats)
+	}
+
+	return vmListStyle.Height(height).Render(sb.String())
+}
 
+// renderVMItems lists VMs, where offset is the index of the first VM in
+// m.allStats. Stale VMs from a degraded host are muted.
+func renderVMItems(m Model, vms []stats.VMStats, offset int, stale bool) string {
 	var vmItems []string
-	for i, vm := range m.allStats {
+	for i, vm := range vms {
 		stateInfo := GetVMStateInfo(vm.State)
 		marker := "  "
 		style := normalStyle
-		if i == m.currentVM {
+		if stale {
+			style = mutedStyle
+		}
+		if offset+i == m.currentVM {
 			marker = "▶ "
 			style = selectedVMStyle
 		}
-		vmItem := fmt.Sprintf("%s%s %s", marker, stateInfo.Icon, vm.DomainName)
-		vmItems = append(vmItems, style.Render(vmItem))
+		// A tiny CPU trend fits beside the name; sorting by CPU adds the
+		// current figure too
+		trend := ""
+		if vm.State == VMStateRunning {
+			trend = renderSparkline(historyOf(m.history, &vm, stats.MetricCPU, ""), sidebarTrendWidth, cpuTrendScale)
+		}
+		vmItem := style.Render(fmt.Sprintf("%s%s %-16s ", marker, stateInfo.Icon, truncate(vm.DomainName, 16))) + trend
+		if m.sortBy == sortByCPU {
+			vmItem = style.Render(fmt.Sprintf("%s%s %-11s %3.0f%% ", marker, stateInfo.Icon, truncate(vm.DomainName, 11), vm.CPU.Usage)) + trend
+		}
+		vmItems = append(vmItems, vmItem)
+	}
+
+	return strings.Join(vmItems, "\n")
+}
+
+// vmTotals sums the vCPUs and current memory, in bytes, of a set of VMs
+func vmTotals(vms []stats.VMStats) (vcpus int, memory int64) {
+	for _, vm := range vms {
+		vcpus += len(vm.VCPUStats)
+		memory += vm.BalloonStats.Current * 1024
 	}
+	return vcpus, memory
+}
 
-	content := strings.Join(vmItems, "\n")
+// activeVMs keeps the domains that are holding host resources: running,
+// idle or paused. Shut off domains report no vCPUs but still their memory,
+// so mixing them into the overcommit ratios would skew one against the other
+func activeVMs(vms []stats.VMStats) []stats.VMStats {
+	var active []stats.VMStats
+	for _, vm := range vms {
+		if getVMPriority(vm.State) == 0 {
+			active = append(active, vm)
+		}
+	}
+	return active
+}
 
-	// Resource Summary
+// renderVMSummary totals running VMs, vCPUs and memory for a set of VMs
+func renderVMSummary(vms []stats.VMStats) string {
 	running := 0
-	totalCPUs := 0
-	totalMem := int64(0)
-
-	for _, vm := range m.allStats {
+	for _, vm := range vms {
 		if vm.State == VMStateRunning {
 			running++
 		}
-		totalCPUs += len(vm.VCPUStats)
-		totalMem += vm.BalloonStats.Current * 1024
 	}
+	totalCPUs, totalMem := vmTotals(vms)
 
-	summary := fmt.Sprintf("\n%s\nRunning: %d/%d\nCPUs: %d | Mem: %s",
+	return fmt.Sprintf("%s\nRunning: %d/%d\nCPUs: %d | Mem: %s",
 		mutedStyle.Render(strings.Repeat("─", 20)),
-		running, len(m.allStats),
+		running, len(vms),
 		totalCPUs, formatBytes(totalMem),
 	)
+}
+
+// renderHostSummary shows the hypervisor's own usage and puts the active
+// VMs' vCPU and memory totals against its physical capacity
+func renderHostSummary(vms []stats.VMStats, node *stats.HostStats, title bool) string {
+	var lines []string
+	lines = append(lines, mutedStyle.Render(strings.Repeat("─", 20)))
+	if title {
+		lines = append(lines, hostStyle.Render("🖥️  Host"))
+	}
+
+	lines = append(lines, fmt.Sprintf("CPU  %s %s", renderColorBar(node.CPUUsage, 10), formatPercent(node.CPUUsage)))
+
+	physMem := node.Memory.Total
+	if physMem == 0 {
+		physMem = node.Node.Memory
+	}
+	lines = append(lines, fmt.Sprintf("Free %s, avail %s",
+		formatBytes(node.Memory.Free*1024), formatBytes(node.Memory.Available()*1024)))
+
+	totalCPUs, totalMem := vmTotals(activeVMs(vms))
+	if node.Node.CPUs > 0 {
+		lines = append(lines, fmt.Sprintf("Active vCPUs %d/%d %s",
+			totalCPUs, node.Node.CPUs, formatOvercommit(float64(totalCPUs)/float64(node.Node.CPUs))))
+	}
+	if physMem > 0 {
+		lines = append(lines, fmt.Sprintf("Active mem %s/%s %s",
+			formatBytes(totalMem), formatBytes(physMem*1024), formatOvercommit(float64(totalMem)/float64(physMem*1024))))
+	}
 
-	finalContent := sb.String() + content + summary
-	return vmListStyle.Height(height).Render(finalContent)
+	return strings.Join(lines, "\n")
+}
+
+// formatOvercommit shows how much of a resource VMs were given relative to
+// what the host has; over 1× the host is overcommitted
+func formatOvercommit(ratio float64) string {
+	s := fmt.Sprintf("(%.2f×)", ratio)
+	if ratio > 1 {
+		return warningStyle.Render(s)
+	}
+	return mutedStyle.Render(s)
 }